@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// API versions. /api/v1 keeps the original response shapes; /api/v2 returns
+// listings one page at a time in a listPage. Errors are the same JSON in
+// both. The unversioned /api is an alias of v1 kept for existing clients, and
+// says so with deprecation headers.
+const (
+	apiV1 = "v1"
+	apiV2 = "v2"
+)
+
+// apiPrefix matches /api with an optional version. The routes are registered
+// once beneath it, so every version shares their rate limits.
+const apiPrefix = "/api{version:(?:/v1|/v2)?}"
+
+const (
+	// Page sizes for ?limit= on /api/v2 listings
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+var errInvalidPageCursor = errors.New("Invalid cursor")
+
+type apiVersionKey struct{}
+
+// withAPIVersion records which version a request was made to. Requests to
+// the unversioned prefix are answered as v1, with a Deprecation header and a
+// Link to the same route under /api/v1.
+func withAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := strings.TrimPrefix(mux.Vars(r)["version"], "/")
+		if version == "" {
+			version = apiV1
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "</api/"+apiV1+strings.TrimPrefix(r.URL.Path, "/api")+`>; rel="successor-version"`)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	})
+}
+
+// apiBases are the prefixes the API is served under
+var apiBases = []string{"/api", "/api/" + apiV1, "/api/" + apiV2}
+
+// apiBase returns the prefix a request was made under, so links in its
+// response stay on the same version. It's /api outside the API router.
+func apiBase(r *http.Request) string {
+	return "/api" + mux.Vars(r)["version"]
+}
+
+// requestAPIVersion returns the version a request was made to, v1 when it
+// didn't come through the API router
+func requestAPIVersion(r *http.Request) string {
+	if version, ok := r.Context().Value(apiVersionKey{}).(string); ok {
+		return version
+	}
+	return apiV1
+}
+
+// listPage is a page of a /api/v2 listing. Total counts every item the
+// listing matches, not just this page.
+type listPage[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// pageParams is the page of a listing asked for with ?limit= and ?cursor=
+type pageParams struct {
+	offset int
+	limit  int
+}
+
+// pageCursor marks where the previous page stopped
+type pageCursor struct {
+	Offset int `json:"offset"`
+}
+
+// parsePageParams reads ?limit= and ?cursor=. Cursors are opaque to clients;
+// they hold the offset of the page.
+func parsePageParams(r *http.Request) (pageParams, error) {
+	query := r.URL.Query()
+	p := pageParams{limit: defaultPageLimit}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return p, errors.New("Invalid limit")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		p.limit = limit
+	}
+	if value := query.Get("cursor"); value != "" {
+		var cursor pageCursor
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.Offset < 0 {
+			return p, errInvalidPageCursor
+		}
+		p.offset = cursor.Offset
+	}
+	return p, nil
+}
+
+// listingPage reads the page a listing query should fetch: every row in v1,
+// where it returns nil, or the ?limit= and ?cursor= page in v2. It writes the
+// error response when it returns false.
+func listingPage(w http.ResponseWriter, r *http.Request) (*pageParams, bool) {
+	if requestAPIVersion(r) == apiV1 {
+		return nil, true
+	}
+	p, err := parsePageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, err.Error())
+		return nil, false
+	}
+	return &p, true
+}
+
+// limitClause returns the SQL LIMIT and OFFSET fetching the page, appending
+// their parameters to args. A nil page fetches every row.
+func (p *pageParams) limitClause(args []interface{}) (string, []interface{}) {
+	if p == nil {
+		return "", args
+	}
+	args = append(args, p.limit, p.offset)
+	return fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
+// after returns what is left of the page for a listing that continues
+// another one of total rows, fetched of which are already on the page
+func (p *pageParams) after(total, fetched int) *pageParams {
+	if p == nil {
+		return nil
+	}
+	offset := p.offset - total
+	if offset < 0 {
+		offset = 0
+	}
+	return &pageParams{offset: offset, limit: p.limit - fetched}
+}
+
+// countRows runs a COUNT query for a paged listing's total. Unpaged
+// listings fetched every row, so their total is how many there were.
+func (p *pageParams) countRows(fetched int, query string, args ...interface{}) (int, error) {
+	if p == nil {
+		return fetched, nil
+	}
+	var total int
+	err := db.QueryRow(query, args...).Scan(&total)
+	return total, err
+}
+
+// pageFrom builds the page of items fetched with limitClause, out of total
+func pageFrom[T any](p pageParams, items []T, total int) listPage[T] {
+	page := listPage[T]{Items: items, Total: total}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if end := p.offset + len(items); len(items) > 0 && end < total {
+		data, _ := json.Marshal(pageCursor{Offset: end})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return page
+}
+
+// pageOf returns the page of items p asks for, for listings short enough to
+// load whole: those of a single video, show or comment
+func pageOf[T any](p pageParams, items []T) listPage[T] {
+	start, end := p.offset, p.offset+p.limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+	return pageFrom(p, items[start:end], len(items))
+}
+
+// writeList writes a listing: every item in v1, or the page asked for in v2
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	p, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+	total := len(items)
+	if p != nil {
+		items = pageOf(*p, items).Items
+	}
+	writePage(w, p, items, total)
+}
+
+// writePage writes a listing fetched with limitClause: the items in v1, or
+// the page of total in v2
+func writePage[T any](w http.ResponseWriter, p *pageParams, items []T, total int) {
+	w.Header().Set("Content-Type", "application/json")
+	if p == nil {
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+	json.NewEncoder(w).Encode(pageFrom(*p, items, total))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWithAPIVersion(t *testing.T) {
+	router := mux.NewRouter()
+	api := router.PathPrefix(apiPrefix).Subrouter()
+	api.Use(withAPIVersion)
+	var seen string
+	api.HandleFunc("/videos/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seen = requestAPIVersion(r)
+	})
+
+	tests := []struct {
+		path       string
+		version    string
+		deprecated bool
+	}{
+		{"/api/v1/videos/3", apiV1, false},
+		{"/api/v2/videos/3", apiV2, false},
+		{"/api/videos/3", apiV1, true},
+	}
+	for _, test := range tests {
+		seen = ""
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if seen != test.version {
+			t.Errorf("GET %s was served as %q; expected %q", test.path, seen, test.version)
+		}
+		if deprecated := rec.Header().Get("Deprecation") == "true"; deprecated != test.deprecated {
+			t.Errorf("GET %s deprecated = %v; expected %v", test.path, deprecated, test.deprecated)
+		}
+		if test.deprecated {
+			if link := rec.Header().Get("Link"); link != `</api/v1/videos/3>; rel="successor-version"` {
+				t.Errorf("GET %s Link = %q; expected the /api/v1 route", test.path, link)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v3/videos/3", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/v3/videos/3 = %d; expected an unknown version to be 404", rec.Code)
+	}
+}
+
+func TestErrorsAreTheSameInEveryVersion(t *testing.T) {
+	router := newRouter()
+	for _, base := range apiBases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", base+"/videos/abc", nil))
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if rec.Code != http.StatusBadRequest || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body.Error.Code != errorCodeInvalidID {
+			t.Errorf("GET %s/videos/abc = %d %q; expected a JSON %s error", base, rec.Code, rec.Body.String(), errorCodeInvalidID)
+		}
+	}
+}
+
+func TestPageOf(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	page := pageOf(pageParams{limit: 2}, items)
+	if !reflect.DeepEqual(page.Items, []int{1, 2}) || page.Total != 5 || page.NextCursor == "" {
+		t.Fatalf("first page = %+v; expected 2 items, the total and a cursor", page)
+	}
+
+	var all []int
+	for cursor := ""; ; {
+		req := httptest.NewRequest("GET", "/api/v2/tags?limit=2&cursor="+cursor, nil)
+		p, err := parsePageParams(req)
+		if err != nil {
+			t.Fatalf("cursor %q: %v", cursor, err)
+		}
+		page := pageOf(p, items)
+		all = append(all, page.Items...)
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(all, items) {
+		t.Errorf("paging through = %v; expected every item once", all)
+	}
+
+	if page := pageOf(pageParams{offset: 10, limit: 2}, items); page.Items == nil || len(page.Items) != 0 || page.NextCursor != "" {
+		t.Errorf("page past the end = %+v; expected an empty list", page)
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	tests := []struct {
+		query string
+		limit int
+		valid bool
+	}{
+		{"", defaultPageLimit, true},
+		{"limit=10", 10, true},
+		{"limit=5000", maxPageLimit, true},
+		{"limit=0", 0, false},
+		{"limit=abc", 0, false},
+		{"cursor=not-a-cursor", 0, false},
+	}
+	for _, test := range tests {
+		p, err := parsePageParams(httptest.NewRequest("GET", "/api/v2/tags?"+test.query, nil))
+		if (err == nil) != test.valid {
+			t.Errorf("?%s: err = %v; expected valid = %v", test.query, err, test.valid)
+			continue
+		}
+		if test.valid && p.limit != test.limit {
+			t.Errorf("?%s: limit = %d; expected %d", test.query, p.limit, test.limit)
+		}
+	}
+}
+
+func TestWriteList(t *testing.T) {
+	router := mux.NewRouter()
+	api := router.PathPrefix(apiPrefix).Subrouter()
+	api.Use(withAPIVersion)
+	api.HandleFunc("/tags", func(w http.ResponseWriter, r *http.Request) {
+		writeList(w, r, []string{"a", "b", "c"})
+	})
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+
+	for _, url := range []string{"/api/tags", "/api/v1/tags?limit=1"} {
+		var items []string
+		if err := json.Unmarshal(get(url).Body.Bytes(), &items); err != nil || len(items) != 3 {
+			t.Errorf("GET %s = %v (%v); expected every item as a list", url, items, err)
+		}
+	}
+
+	var page listPage[string]
+	if err := json.Unmarshal(get("/api/v2/tags?limit=2").Body.Bytes(), &page); err != nil {
+		t.Fatalf("v2 listing isn't a page: %v", err)
+	}
+	if !reflect.DeepEqual(page.Items, []string{"a", "b"}) || page.Total != 3 || page.NextCursor == "" {
+		t.Errorf("v2 page = %+v; expected the first 2 items, the total and a cursor", page)
+	}
+
+	rec := get("/api/v2/tags?cursor=bogus")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad cursor got %d; expected 400", rec.Code)
+	}
+	if e := decodeError(t, rec); e.Code != errorCodeInvalidParameter {
+		t.Errorf("code = %q; expected %q", e.Code, errorCodeInvalidParameter)
+	}
+}
+
+func TestMediaLinksFollowAPIVersion(t *testing.T) {
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "SELECT EXISTS") {
+			return []string{"exists"}, [][]driver.Value{{true}}, nil
+		}
+		if strings.Contains(query, "FROM subtitle_tracks") {
+			return []string{"id", "video_id", "language", "label", "format", "source", "forced", "hearing_impaired", "path", "stream_index"},
+				[][]driver.Value{{int64(5), int64(3), "en", "English", "srt", subtitleSourceSidecar, false, false, "/videos/a.en.srt", int64(0)}}, nil
+		}
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		columns := make([]string, 22)
+		return columns, [][]driver.Value{{int64(3), "a.mp4", "/videos/a.mp4", "A", int64(0), int64(0), int64(60), int64(1024),
+			now, now, nil, nil, nil, "", []byte("{}"), []byte("{}"), nil, nil, false, false, false, []byte("{}")}}, nil
+	})
+	router := newRouter()
+	get := func(url string, into interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", url, rec.Code, rec.Body.String())
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), into); err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+	}
+
+	for _, base := range apiBases {
+		var v Video
+		get(base+"/videos/3", &v)
+		if v.ThumbnailURL != base+"/videos/3/thumbnail" || v.PreviewURL != base+"/videos/3/preview" {
+			t.Errorf("GET %s/videos/3 linked to %s and %s; expected the same prefix", base, v.ThumbnailURL, v.PreviewURL)
+		}
+	}
+
+	var tracks []SubtitleTrack
+	get("/api/v1/videos/3/subtitles", &tracks)
+	if len(tracks) != 1 || tracks[0].URL != "/api/v1/videos/3/subtitles/5.vtt" {
+		t.Errorf("v1 subtitle tracks = %+v; expected a /api/v1 URL", tracks)
+	}
+
+	var page listPage[SubtitleTrack]
+	get("/api/v2/videos/3/subtitles", &page)
+	if len(page.Items) != 1 || page.Total != 1 || page.Items[0].URL != "/api/v2/videos/3/subtitles/5.vtt" {
+		t.Errorf("v2 subtitle tracks = %+v; expected a page linking to /api/v2", page)
+	}
+}
+
+func TestListingQueriesFetchOnlyThePage(t *testing.T) {
+	var queries []string
+	var limitArgs []driver.Value
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		queries = append(queries, query)
+		if strings.Contains(query, "COUNT(DISTINCT tag_id)") {
+			return []string{"count"}, [][]driver.Value{{int64(9)}}, nil
+		}
+		limitArgs = args
+		return []string{"id", "name", "slug", "count"}, [][]driver.Value{
+			{int64(1), "Drama", "drama", int64(4)},
+			{int64(2), "News", "news", int64(1)},
+		}, nil
+	})
+	router := newRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/tags?limit=2", nil))
+	var page listPage[Tag]
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("GET /api/v2/tags = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(queries[0], "LIMIT $1 OFFSET $2") || !reflect.DeepEqual(limitArgs, []driver.Value{int64(2), int64(0)}) {
+		t.Errorf("v2 tags query = %q with %v; expected LIMIT 2 OFFSET 0", queries[0], limitArgs)
+	}
+	if len(page.Items) != 2 || page.Total != 9 || page.NextCursor == "" {
+		t.Errorf("v2 page = %+v; expected the 2 rows fetched, the counted total and a cursor", page)
+	}
+
+	queries = nil
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/tags?limit=2&cursor="+page.NextCursor, nil))
+	if !reflect.DeepEqual(limitArgs, []driver.Value{int64(2), int64(2)}) {
+		t.Errorf("second page fetched with %v; expected LIMIT 2 OFFSET 2", limitArgs)
+	}
+
+	queries = nil
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/tags", nil))
+	if len(queries) != 1 || strings.Contains(queries[0], "LIMIT") {
+		t.Errorf("v1 tags ran %q; expected one unpaged query", queries)
+	}
+}
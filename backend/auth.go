@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const (
+	// userHeader carries the caller's display name. StreamLite has no account
+	// system yet, so identity is asserted by the client the same way comment
+	// authors are.
+	userHeader = "X-User"
+
+	// maxUserLength mirrors the comment author limit
+	maxUserLength = 100
+)
+
+// requestUser returns the identity asserted by the request, or "" if anonymous
+func requestUser(r *http.Request) string {
+	user := strings.TrimSpace(r.Header.Get(userHeader))
+	if len(user) > maxUserLength {
+		user = user[:maxUserLength]
+	}
+	return user
+}
+
+// isAdmin reports whether the request carries the configured admin token.
+// Admin access is disabled entirely when ADMIN_TOKEN is not set.
+func isAdmin(r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// requireAdmin wraps a handler so it only runs for admin requests
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeError(w, http.StatusForbidden, errorCodeAdminRequired, "Admin access required")
+			return
+		}
+		next(w, r)
+	}
+}
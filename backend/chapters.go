@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chapter sources. Only the highest-priority source that has chapters for a
+// video is shown: admin edits, then sidecar files, then the container.
+const (
+	chapterSourceEmbedded = "embedded"
+	chapterSourceSidecar  = "sidecar"
+	chapterSourceAdmin    = "admin"
+
+	// chapterFileSuffix names the chapter list sidecar, e.g. Lecture.chapters.txt
+	chapterFileSuffix = ".chapters.txt"
+
+	maxChapters           = 500
+	maxChapterTitleLength = 500
+
+	// maxChapterMs is the latest a chapter may start or end, as chapter times
+	// are stored as 32-bit milliseconds
+	maxChapterMs = math.MaxInt32
+)
+
+// Chapter is a named section of a video; times are in seconds
+type Chapter struct {
+	ID     int     `json:"id"`
+	Title  string  `json:"title"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Source string  `json:"source"`
+}
+
+// chapterMark is a chapter as read from a file or request, before it is
+// stored. EndMs is 0 when the chapter simply runs until the next one.
+type chapterMark struct {
+	StartMs int
+	EndMs   int
+	Title   string
+}
+
+// chapterTime accepts a chapter time as seconds or as an HH:MM:SS.mmm string
+type chapterTime float64
+
+func (c *chapterTime) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*c = chapterTime(seconds)
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("chapter time must be seconds or HH:MM:SS")
+	}
+	seconds, err := parseSeconds(value)
+	if err != nil {
+		return fmt.Errorf("invalid chapter time %q", value)
+	}
+	*c = chapterTime(seconds)
+	return nil
+}
+
+// chapterInput is one chapter in a JSON sidecar or an admin request
+type chapterInput struct {
+	Start *chapterTime `json:"start"`
+	End   *chapterTime `json:"end"`
+	Title string       `json:"title"`
+}
+
+var (
+	ogmChapterPattern    = regexp.MustCompile(`(?i)^CHAPTER(\d+)(NAME)?=(.*)$`)
+	simpleChapterPattern = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}(?:[.,]\d{1,3})?)\s*(?:-\s+)?(.*)$`)
+)
+
+// parseChapterFile reads a chapter list in OGM format (CHAPTER01=00:00:00.000
+// followed by CHAPTER01NAME=Intro) or as one "00:00 Title" line per chapter,
+// as found in video descriptions. Invalid chapters are skipped, returning why.
+func parseChapterFile(text string) ([]chapterMark, []error, error) {
+	ogm := make(map[string]*chapterInput)
+	var ogmKeys []string
+	var simple []chapterInput
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := ogmChapterPattern.FindStringSubmatch(line); m != nil {
+			input, ok := ogm[m[1]]
+			if !ok {
+				input = &chapterInput{}
+				ogm[m[1]] = input
+				ogmKeys = append(ogmKeys, m[1])
+			}
+			if m[2] != "" {
+				input.Title = strings.TrimSpace(m[3])
+			} else if seconds, err := parseSeconds(strings.TrimSpace(m[3])); err == nil {
+				start := chapterTime(seconds)
+				input.Start = &start
+			}
+			continue
+		}
+
+		if m := simpleChapterPattern.FindStringSubmatch(line); m != nil {
+			seconds, err := parseSeconds(m[1])
+			if err != nil {
+				continue
+			}
+			start := chapterTime(seconds)
+			simple = append(simple, chapterInput{Start: &start, Title: strings.TrimSpace(m[2])})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	inputs := simple
+	if len(ogmKeys) > 0 {
+		inputs = nil
+		for _, key := range ogmKeys {
+			inputs = append(inputs, *ogm[key])
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("no chapters found")
+	}
+	marks, problems := validChapterMarks(inputs)
+	return marks, problems, nil
+}
+
+// normalizeChapters sorts chapters, drops repeated start times and names
+// untitled chapters
+func normalizeChapters(marks []chapterMark) []chapterMark {
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].StartMs < marks[j].StartMs })
+
+	var result []chapterMark
+	for _, mark := range marks {
+		if len(result) > 0 && result[len(result)-1].StartMs == mark.StartMs {
+			continue
+		}
+		if mark.EndMs <= mark.StartMs {
+			mark.EndMs = 0
+		}
+		mark.Title = strings.TrimSpace(mark.Title)
+		if mark.Title == "" {
+			mark.Title = fmt.Sprintf("Chapter %d", len(result)+1)
+		}
+		result = append(result, mark)
+	}
+	return result
+}
+
+// chapterMarks validates chapters from an admin request, failing on the first
+// invalid one
+func chapterMarks(inputs []chapterInput) ([]chapterMark, error) {
+	if len(inputs) > maxChapters {
+		return nil, fmt.Errorf("Too many chapters (max %d)", maxChapters)
+	}
+	marks, problems := validChapterMarks(inputs)
+	if len(problems) > 0 {
+		return nil, problems[0]
+	}
+	return marks, nil
+}
+
+// validChapterMarks keeps the valid chapters, at most maxChapters of them,
+// returning why each of the others was dropped
+func validChapterMarks(inputs []chapterInput) ([]chapterMark, []error) {
+	var problems []error
+	if len(inputs) > maxChapters {
+		problems = append(problems, fmt.Errorf("Too many chapters (max %d)", maxChapters))
+		inputs = inputs[:maxChapters]
+	}
+
+	marks := make([]chapterMark, 0, len(inputs))
+	seen := make(map[int]bool)
+	for _, input := range inputs {
+		mark, err := chapterMarkOf(input)
+		if err == nil && seen[mark.StartMs] {
+			err = fmt.Errorf("Two chapters start at %.3f seconds", float64(mark.StartMs)/1000)
+		}
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		seen[mark.StartMs] = true
+		marks = append(marks, mark)
+	}
+	return normalizeChapters(marks), problems
+}
+
+// chapterMarkOf validates one chapter from a JSON sidecar or admin request
+func chapterMarkOf(input chapterInput) (chapterMark, error) {
+	if input.Start == nil || *input.Start < 0 || math.IsNaN(float64(*input.Start)) {
+		return chapterMark{}, fmt.Errorf("Every chapter needs a start time of 0 or more")
+	}
+	if float64(*input.Start)*1000 > maxChapterMs || (input.End != nil && float64(*input.End)*1000 > maxChapterMs) {
+		return chapterMark{}, fmt.Errorf("Chapter times can't be later than %d seconds", maxChapterMs/1000)
+	}
+	if len(input.Title) > maxChapterTitleLength {
+		return chapterMark{}, fmt.Errorf("Chapter title too long (max %d characters)", maxChapterTitleLength)
+	}
+
+	mark := chapterMark{StartMs: int(math.Round(float64(*input.Start) * 1000)), Title: input.Title}
+	if input.End != nil {
+		mark.EndMs = int(math.Round(float64(*input.End) * 1000))
+		if mark.EndMs <= mark.StartMs {
+			return chapterMark{}, fmt.Errorf("Chapter end must be after its start")
+		}
+	}
+	return mark, nil
+}
+
+// replaceChapters stores the chapters a video has from one source
+func replaceChapters(q querier, videoID int, source string, marks []chapterMark) error {
+	if _, err := q.Exec("DELETE FROM chapters WHERE video_id = $1 AND source = $2", videoID, source); err != nil {
+		return err
+	}
+	for _, mark := range marks {
+		var end interface{}
+		if mark.EndMs > 0 {
+			end = mark.EndMs
+		}
+		if _, err := q.Exec(`
+			INSERT INTO chapters (video_id, source, start_ms, end_ms, title)
+			VALUES ($1, $2, $3, $4, $5)
+		`, videoID, source, mark.StartMs, end, mark.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeChapter is a chapter as reported by ffprobe -show_chapters
+type probeChapter struct {
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// embeddedChapters converts ffprobe chapters, skipping invalid ones and
+// returning why. An end that isn't after the start is left out, so the
+// chapter runs until the next one.
+func embeddedChapters(chapters []probeChapter) ([]chapterMark, []error) {
+	inputs := make([]chapterInput, 0, len(chapters))
+	for _, c := range chapters {
+		var input chapterInput
+		if start, err := strconv.ParseFloat(c.StartTime, 64); err == nil {
+			input.Start = (*chapterTime)(&start)
+			if end, err := strconv.ParseFloat(c.EndTime, 64); err == nil && end > start {
+				input.End = (*chapterTime)(&end)
+			}
+		}
+		input.Title = c.Tags["title"]
+		inputs = append(inputs, input)
+	}
+	return validChapterMarks(inputs)
+}
+
+// syncEmbeddedChapters stores the chapters found while probing
+func syncEmbeddedChapters(videoID int, chapters []probeChapter) {
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	marks, problems := embeddedChapters(chapters)
+	for _, problem := range problems {
+		logger.Printf("Warning: Ignoring an embedded chapter of video ID %d: %v", videoID, problem)
+	}
+	if err := replaceChapters(tx, videoID, chapterSourceEmbedded, marks); err != nil {
+		logger.Printf("Error saving embedded chapters for video ID %d: %v", videoID, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing embedded chapters for video ID %d: %v", videoID, err)
+	}
+}
+
+// fillChapterEnds gives every chapter an end: its own when stored, else the
+// next chapter's start, else the end of the video
+func fillChapterEnds(chapters []Chapter, duration float64) {
+	for i := range chapters {
+		if chapters[i].End > chapters[i].Start {
+			continue
+		}
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else if duration > chapters[i].Start {
+			chapters[i].End = duration
+		} else {
+			chapters[i].End = chapters[i].Start
+		}
+	}
+}
+
+// loadChapters returns the chapters from the highest-priority source a video has
+func loadChapters(videoID int) ([]Chapter, error) {
+	var duration int
+	if err := db.QueryRow("SELECT duration FROM videos WHERE id = $1", videoID).Scan(&duration); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, title, start_ms, COALESCE(end_ms, 0), source
+		FROM chapters
+		WHERE video_id = $1 AND source = (
+			SELECT source FROM chapters WHERE video_id = $1
+			ORDER BY CASE source WHEN $2 THEN 0 WHEN $3 THEN 1 ELSE 2 END
+			LIMIT 1
+		)
+		ORDER BY start_ms
+	`, videoID, chapterSourceAdmin, chapterSourceSidecar)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var c Chapter
+		var startMs, endMs int
+		if err := rows.Scan(&c.ID, &c.Title, &startMs, &endMs, &c.Source); err != nil {
+			return nil, err
+		}
+		c.Start = float64(startMs) / 1000
+		c.End = float64(endMs) / 1000
+		chapters = append(chapters, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	fillChapterEnds(chapters, float64(duration))
+	return chapters, nil
+}
+
+func getChapters(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	chapters, err := loadChapters(videoID)
+	if err != nil {
+		logger.Printf("Error querying chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch chapters")
+		return
+	}
+
+	writeList(w, r, chapters)
+}
+
+// writeChapters answers a change to the chapters with the full list, a plain
+// array in every API version
+func writeChapters(w http.ResponseWriter, videoID int) {
+	chapters, err := loadChapters(videoID)
+	if err != nil {
+		logger.Printf("Error querying chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch chapters")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chapters)
+}
+
+// getChaptersVTT serves the chapters as a WebVTT track for <track kind="chapters">
+func getChaptersVTT(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	chapters, err := loadChapters(videoID)
+	if err != nil {
+		logger.Printf("Error querying chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch chapters")
+		return
+	}
+
+	cues := make([]subtitleCue, len(chapters))
+	for i, c := range chapters {
+		cues[i] = subtitleCue{
+			ID:    strconv.Itoa(i + 1),
+			Start: time.Duration(c.Start * float64(time.Second)),
+			End:   time.Duration(c.End * float64(time.Second)),
+			Text:  cleanCueText(c.Title),
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Write([]byte(renderVTT(cues)))
+}
+
+// setChapters replaces a video's admin chapters, which take precedence over
+// chapters found in sidecars or the file itself (admin)
+func setChapters(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	var body chaptersInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	if len(body.Chapters) == 0 {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "At least one chapter is required (DELETE removes admin chapters)")
+		return
+	}
+	marks, err := chapterMarks(body.Chapters)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to save chapters")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := replaceChapters(tx, videoID, chapterSourceAdmin, marks); err != nil {
+		logger.Printf("Error saving chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to save chapters")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to save chapters")
+		return
+	}
+
+	logger.Printf("Set %d chapters for video ID %d", len(marks), videoID)
+	writeChapters(w, videoID)
+}
+
+// deleteChapters removes a video's admin chapters so scanned chapters show
+// again (admin)
+func deleteChapters(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	if err := replaceChapters(db, videoID, chapterSourceAdmin, nil); err != nil {
+		logger.Printf("Error deleting chapters: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to delete chapters")
+		return
+	}
+
+	logger.Printf("Removed admin chapters for video ID %d", videoID)
+	writeChapters(w, videoID)
+}
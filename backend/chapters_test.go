@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChapterFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []chapterMark
+	}{
+		{
+			"ogm",
+			"CHAPTER01=00:00:00.000\nCHAPTER01NAME=Intro\nCHAPTER02=00:05:30.500\nCHAPTER02NAME=Setup\n",
+			[]chapterMark{{0, 0, "Intro"}, {330500, 0, "Setup"}},
+		},
+		{
+			"simple",
+			"# Lecture 3\n0:00 Welcome\n12:45 - Proofs\n1:02:03 Q&A\n",
+			[]chapterMark{{0, 0, "Welcome"}, {765000, 0, "Proofs"}, {3723000, 0, "Q&A"}},
+		},
+		{
+			"unsorted and untitled",
+			"10:00\n00:00 Start\n",
+			[]chapterMark{{0, 0, "Start"}, {600000, 0, "Chapter 2"}},
+		},
+	}
+
+	for _, test := range tests {
+		marks, problems, err := parseChapterFile(test.text)
+		if err != nil || len(problems) > 0 {
+			t.Errorf("parseChapterFile(%s) failed: %v %v", test.name, err, problems)
+			continue
+		}
+		if !reflect.DeepEqual(marks, test.expected) {
+			t.Errorf("parseChapterFile(%s) = %+v; expected %+v", test.name, marks, test.expected)
+		}
+	}
+
+	if _, _, err := parseChapterFile("just some notes\n"); err == nil {
+		t.Error("Expected error for file without chapters")
+	}
+}
+
+func TestParseChapterFileSkipsBadChapters(t *testing.T) {
+	text := "0:00 Intro\n999999999:00:00 Far too late\n5:00 " + strings.Repeat("x", maxChapterTitleLength+1) + "\n10:00 Outro\n"
+	marks, problems, err := parseChapterFile(text)
+	if err != nil {
+		t.Fatalf("parseChapterFile failed: %v", err)
+	}
+	expected := []chapterMark{{0, 0, "Intro"}, {600000, 0, "Outro"}}
+	if !reflect.DeepEqual(marks, expected) {
+		t.Errorf("parseChapterFile = %+v; expected %+v", marks, expected)
+	}
+	if len(problems) != 2 {
+		t.Errorf("Expected 2 problems, got %v", problems)
+	}
+
+	var many strings.Builder
+	for i := 0; i < maxChapters+10; i++ {
+		many.WriteString(fmt.Sprintf("%d:%02d:00 Part\n", i/60, i%60))
+	}
+	marks, problems, err = parseChapterFile(many.String())
+	if err != nil || len(marks) != maxChapters || len(problems) != 1 {
+		t.Errorf("Expected %d chapters and one problem, got %d, %v, %v", maxChapters, len(marks), problems, err)
+	}
+}
+
+func TestChapterMarks(t *testing.T) {
+	var inputs []chapterInput
+	if err := json.Unmarshal([]byte(`[{"start": "00:01:00", "title": "Two"}, {"start": 0, "end": 30, "title": "One"}]`), &inputs); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	marks, err := chapterMarks(inputs)
+	if err != nil {
+		t.Fatalf("chapterMarks failed: %v", err)
+	}
+	expected := []chapterMark{{0, 30000, "One"}, {60000, 0, "Two"}}
+	if !reflect.DeepEqual(marks, expected) {
+		t.Errorf("chapterMarks = %+v; expected %+v", marks, expected)
+	}
+
+	invalid := []string{
+		`[{"title": "No start"}]`,
+		`[{"start": -1}]`,
+		`[{"start": 10, "end": 5}]`,
+		`[{"start": 10}, {"start": "0:10"}]`,
+		`[{"start": 0, "title": "` + strings.Repeat("x", maxChapterTitleLength+1) + `"}]`,
+		`[{"start": 2147484}]`,
+		`[{"start": 0, "end": 1e300}]`,
+	}
+	for _, body := range invalid {
+		var inputs []chapterInput
+		if err := json.Unmarshal([]byte(body), &inputs); err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", body, err)
+		}
+		if _, err := chapterMarks(inputs); err == nil {
+			t.Errorf("chapterMarks(%s) succeeded; expected an error", body)
+		}
+	}
+}
+
+func TestEmbeddedChapters(t *testing.T) {
+	marks, problems := embeddedChapters([]probeChapter{
+		{StartTime: "0.000000", EndTime: "95.500000", Tags: map[string]string{"title": "Opening"}},
+		{StartTime: "95.500000", EndTime: "200.000000"},
+		{StartTime: "300.000000", EndTime: "300.000000"},
+		{StartTime: "bogus"},
+		{StartTime: "400.000000", EndTime: "1e12"},
+		{StartTime: "1e12"},
+	})
+	expected := []chapterMark{{0, 95500, "Opening"}, {95500, 200000, "Chapter 2"}, {300000, 0, "Chapter 3"}}
+	if !reflect.DeepEqual(marks, expected) {
+		t.Errorf("embeddedChapters = %+v; expected %+v", marks, expected)
+	}
+	if len(problems) != 3 {
+		t.Errorf("Expected 3 problems, got %v", problems)
+	}
+}
+
+func TestFillChapterEnds(t *testing.T) {
+	chapters := []Chapter{{Start: 0}, {Start: 60, End: 90}, {Start: 120}}
+	fillChapterEnds(chapters, 300)
+
+	expected := []float64{60, 90, 300}
+	for i, c := range chapters {
+		if c.End != expected[i] {
+			t.Errorf("chapter %d end = %v; expected %v", i, c.End, expected[i])
+		}
+	}
+
+	unknown := []Chapter{{Start: 10}}
+	fillChapterEnds(unknown, 0)
+	if unknown[0].End != 10 {
+		t.Errorf("Expected last chapter of unknown-length video to end at its start, got %v", unknown[0].End)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Comment orders accepted by ?sort=
+const (
+	commentSortNewest    = "newest"
+	commentSortOldest    = "oldest"
+	commentSortTop       = "top"
+	commentSortTimestamp = "timestamp"
+)
+
+const (
+	// defaultCommentWindow is how many seconds either side of ?around= are
+	// listed when no ?window= is given
+	defaultCommentWindow = 30
+	maxCommentWindow     = 600
+
+	// Page sizes for ?limit=
+	defaultCommentLimit = 20
+	maxCommentLimit     = 100
+)
+
+var errInvalidCursor = errors.New("Invalid cursor")
+
+// commentListing is how a listing request wants comments filtered, ordered
+// and paged. A zero limit lists every comment without a page envelope.
+type commentListing struct {
+	sort   string
+	around *int
+	window int
+	limit  int
+	after  *commentCursor
+}
+
+// commentCursor marks where the previous page stopped: the sort key of its
+// last comment
+type commentCursor struct {
+	Sort      string    `json:"sort"`
+	Score     int       `json:"score,omitempty"`
+	Timestamp int       `json:"timestamp,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ID        int       `json:"id"`
+}
+
+// commentPage is the response to a paginated listing. Total counts every
+// comment the listing matches, not just this page.
+type commentPage struct {
+	Comments   []Comment `json:"comments"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// parseCommentListing reads ?sort=, ?around=, ?window=, ?limit= and
+// ?cursor=. ?around=N keeps comments timestamped within window seconds of N,
+// sorted by timestamp unless another order is asked for. A cursor without a
+// limit pages by defaultCommentLimit.
+func parseCommentListing(r *http.Request) (commentListing, error) {
+	query := r.URL.Query()
+	l := commentListing{sort: query.Get("sort"), window: defaultCommentWindow}
+
+	if value := query.Get("around"); value != "" {
+		around, err := strconv.Atoi(value)
+		if err != nil || around < 0 {
+			return l, errors.New("Invalid around")
+		}
+		l.around = &around
+		if l.sort == "" {
+			l.sort = commentSortTimestamp
+		}
+	}
+	if value := query.Get("window"); value != "" {
+		window, err := strconv.Atoi(value)
+		if err != nil || window < 0 {
+			return l, errors.New("Invalid window")
+		}
+		if window > maxCommentWindow {
+			window = maxCommentWindow
+		}
+		l.window = window
+	}
+
+	switch l.sort {
+	case "":
+		l.sort = commentSortNewest
+	case commentSortNewest, commentSortOldest, commentSortTop, commentSortTimestamp:
+	default:
+		return l, errors.New("Invalid sort")
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return l, errors.New("Invalid limit")
+		}
+		if limit > maxCommentLimit {
+			limit = maxCommentLimit
+		}
+		l.limit = limit
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeCommentCursor(value)
+		if err != nil || cursor.Sort != l.sort {
+			return l, errInvalidCursor
+		}
+		l.after = &cursor
+		if l.limit == 0 {
+			l.limit = defaultCommentLimit
+		}
+	}
+	return l, nil
+}
+
+func encodeCommentCursor(c commentCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCommentCursor(value string) (commentCursor, error) {
+	var c commentCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// paginated reports whether the listing is answered with a commentPage
+func (l commentListing) paginated() bool {
+	return l.limit > 0
+}
+
+// commentReplyCount is the SQL expression counting the replies to a comment
+// (under alias) the caller may see
+func commentReplyCount(alias string, admin bool) string {
+	return `(SELECT COUNT(*) FROM comments r WHERE r.parent_id = ` + alias + `.id AND ` + commentFilter("r", admin) + `)`
+}
+
+// keys returns the SQL expressions the listing orders comments (under alias)
+// by, most significant first, and whether they run in descending order. The
+// ID always comes last so the order is total and cursors are exact.
+func (l commentListing) keys(alias string) ([]string, bool) {
+	switch l.sort {
+	case commentSortOldest:
+		return []string{alias + ".created_at", alias + ".id"}, false
+	case commentSortTop:
+		return []string{commentReactions.totalColumn(alias + ".id"), alias + ".created_at", alias + ".id"}, true
+	case commentSortTimestamp:
+		return []string{alias + ".timestamp_seconds", alias + ".created_at", alias + ".id"}, false
+	}
+	return []string{alias + ".created_at", alias + ".id"}, true
+}
+
+// cursorValues returns the values of keys recorded in the cursor
+func (l commentListing) cursorValues() []interface{} {
+	switch l.sort {
+	case commentSortTop:
+		return []interface{}{l.after.Score, l.after.CreatedAt, l.after.ID}
+	case commentSortTimestamp:
+		return []interface{}{l.after.Timestamp, l.after.CreatedAt, l.after.ID}
+	}
+	return []interface{}{l.after.CreatedAt, l.after.ID}
+}
+
+// conditions returns the SQL conditions on comments (under alias) the listing
+// filters by, appending their parameters to args. Sorting by timestamp only
+// lists timestamped comments. The cursor is left out so the same conditions
+// can count the whole listing.
+func (l commentListing) conditions(alias string, args []interface{}) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	if l.sort == commentSortTimestamp {
+		conditions = append(conditions, alias+".timestamp_seconds IS NOT NULL")
+	}
+	if l.around != nil {
+		args = append(args, *l.around-l.window, *l.around+l.window)
+		conditions = append(conditions, fmt.Sprintf("%s.timestamp_seconds BETWEEN $%d AND $%d", alias, len(args)-1, len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// pageConditions adds to conditions the comparison that skips comments up to
+// the cursor
+func (l commentListing) pageConditions(alias string, args []interface{}) (string, []interface{}) {
+	conditions, args := l.conditions(alias, args)
+	if l.after == nil {
+		return conditions, args
+	}
+
+	keys, descending := l.keys(alias)
+	var placeholders []string
+	for _, value := range l.cursorValues() {
+		args = append(args, value)
+		cast := "::int"
+		if _, ok := value.(time.Time); ok {
+			cast = "::timestamp"
+		}
+		placeholders = append(placeholders, fmt.Sprintf("$%d%s", len(args), cast))
+	}
+	op := ">"
+	if descending {
+		op = "<"
+	}
+	return fmt.Sprintf("%s AND (%s) %s (%s)", conditions, strings.Join(keys, ", "), op, strings.Join(placeholders, ", ")), args
+}
+
+// orderBy returns the SQL ordering of the listing
+func (l commentListing) orderBy(alias string) string {
+	keys, descending := l.keys(alias)
+	if descending {
+		for i := range keys {
+			keys[i] += " DESC"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// limitClause returns the SQL LIMIT fetching one comment more than a page, so
+// a next page can be detected, appending its parameter to args
+func (l commentListing) limitClause(args []interface{}) (string, []interface{}) {
+	if !l.paginated() {
+		return "", args
+	}
+	args = append(args, l.limit+1)
+	return fmt.Sprintf("LIMIT $%d", len(args)), args
+}
+
+// less orders comments the way orderBy does
+func (l commentListing) less(a, b Comment) bool {
+	switch l.sort {
+	case commentSortOldest:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	case commentSortTimestamp:
+		if a.TimestampSeconds != nil && b.TimestampSeconds != nil && *a.TimestampSeconds != *b.TimestampSeconds {
+			return *a.TimestampSeconds < *b.TimestampSeconds
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	case commentSortTop:
+		if ta, tb := reactionTotal(a.Reactions), reactionTotal(b.Reactions); ta != tb {
+			return ta > tb
+		}
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// page builds the envelope for comments fetched with limitClause, trimming
+// the extra comment and pointing the cursor at the last one kept
+func (l commentListing) page(comments []Comment, total int) commentPage {
+	p := commentPage{Comments: comments, Total: total}
+	if len(comments) > l.limit {
+		p.Comments = comments[:l.limit]
+		last := p.Comments[l.limit-1]
+		cursor := commentCursor{Sort: l.sort, Score: reactionTotal(last.Reactions), CreatedAt: last.CreatedAt, ID: last.ID}
+		if last.TimestampSeconds != nil {
+			cursor.Timestamp = *last.TimestampSeconds
+		}
+		p.NextCursor = encodeCommentCursor(cursor)
+	}
+	return p
+}
+
+// countComments counts a video's comments matching the listing, only
+// top-level ones when topLevel is set
+func countComments(videoID string, topLevel, admin bool, listing commentListing) (int, error) {
+	conditions, args := listing.conditions("c", []interface{}{videoID})
+	if topLevel {
+		conditions += " AND c.parent_id IS NULL"
+	}
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM comments c
+		WHERE c.video_id = $1 AND `+commentFilter("c", admin)+` AND `+conditions,
+		args...).Scan(&total)
+	return total, err
+}
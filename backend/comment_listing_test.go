@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCommentListing(t *testing.T) {
+	cursor := encodeCommentCursor(commentCursor{Sort: commentSortTop, Score: 3, ID: 9})
+	tests := []struct {
+		query  string
+		sort   string
+		around int
+		window int
+		limit  int
+		ok     bool
+	}{
+		{"", commentSortNewest, -1, defaultCommentWindow, 0, true},
+		{"sort=newest", commentSortNewest, -1, defaultCommentWindow, 0, true},
+		{"sort=oldest", commentSortOldest, -1, defaultCommentWindow, 0, true},
+		{"sort=top", commentSortTop, -1, defaultCommentWindow, 0, true},
+		{"sort=timestamp", commentSortTimestamp, -1, defaultCommentWindow, 0, true},
+		{"around=123", commentSortTimestamp, 123, defaultCommentWindow, 0, true},
+		{"around=123&window=10", commentSortTimestamp, 123, 10, 0, true},
+		{"around=123&sort=newest", commentSortNewest, 123, defaultCommentWindow, 0, true},
+		{"around=5&window=9999", commentSortTimestamp, 5, maxCommentWindow, 0, true},
+		{"limit=10", commentSortNewest, -1, defaultCommentWindow, 10, true},
+		{"limit=1000", commentSortNewest, -1, defaultCommentWindow, maxCommentLimit, true},
+		{"sort=top&cursor=" + cursor, commentSortTop, -1, defaultCommentWindow, defaultCommentLimit, true},
+		{"sort=top&limit=5&cursor=" + cursor, commentSortTop, -1, defaultCommentWindow, 5, true},
+		{"around=-1", "", 0, 0, 0, false},
+		{"around=1m", "", 0, 0, 0, false},
+		{"window=-5", "", 0, 0, 0, false},
+		{"sort=random", "", 0, 0, 0, false},
+		{"limit=0", "", 0, 0, 0, false},
+		{"limit=ten", "", 0, 0, 0, false},
+		{"cursor=" + cursor, "", 0, 0, 0, false},
+		{"cursor=not-a-cursor", "", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		l, err := parseCommentListing(httptest.NewRequest("GET", "/comments?"+test.query, nil))
+		if (err == nil) != test.ok {
+			t.Errorf("parseCommentListing(%q) error = %v; expected ok %v", test.query, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		around := -1
+		if l.around != nil {
+			around = *l.around
+		}
+		if l.sort != test.sort || around != test.around || l.window != test.window || l.limit != test.limit {
+			t.Errorf("parseCommentListing(%q) = %s, %d, %d, %d; expected %s, %d, %d, %d",
+				test.query, l.sort, around, l.window, l.limit, test.sort, test.around, test.window, test.limit)
+		}
+	}
+}
+
+func TestCommentListingConditions(t *testing.T) {
+	around := 100
+	l := commentListing{sort: commentSortTimestamp, around: &around, window: 30}
+	conditions, args := l.conditions("c", []interface{}{7})
+
+	expected := "TRUE AND c.timestamp_seconds IS NOT NULL AND c.timestamp_seconds BETWEEN $2 AND $3"
+	if conditions != expected {
+		t.Errorf("conditions = %q; expected %q", conditions, expected)
+	}
+	if len(args) != 3 || args[1] != 70 || args[2] != 130 {
+		t.Errorf("args = %v; expected [7 70 130]", args)
+	}
+
+	if conditions, args := (commentListing{sort: commentSortNewest}).conditions("c", nil); conditions != "TRUE" || len(args) != 0 {
+		t.Errorf("conditions = %q, %v; expected no filter", conditions, args)
+	}
+}
+
+func TestCommentListingPageConditions(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sort       string
+		conditions string
+		args       int
+	}{
+		{commentSortNewest, "TRUE AND (c.created_at, c.id) < ($2::timestamp, $3::int)", 3},
+		{commentSortOldest, "TRUE AND (c.created_at, c.id) > ($2::timestamp, $3::int)", 3},
+		{commentSortTimestamp, "TRUE AND c.timestamp_seconds IS NOT NULL AND " +
+			"(c.timestamp_seconds, c.created_at, c.id) > ($2::int, $3::timestamp, $4::int)", 4},
+	}
+
+	for _, test := range tests {
+		l := commentListing{sort: test.sort, limit: 10, after: &commentCursor{Sort: test.sort, CreatedAt: created, ID: 5}}
+		conditions, args := l.pageConditions("c", []interface{}{1})
+		if conditions != test.conditions || len(args) != test.args {
+			t.Errorf("pageConditions(%s) = %q, %d args; expected %q, %d args",
+				test.sort, conditions, len(args), test.conditions, test.args)
+		}
+	}
+
+	l := commentListing{sort: commentSortTop, limit: 10, after: &commentCursor{Sort: commentSortTop, Score: 2}}
+	if _, args := l.pageConditions("c", nil); len(args) != 3 || args[0] != 2 {
+		t.Errorf("pageConditions(top) args = %v; expected the score first", args)
+	}
+}
+
+func TestCommentListingOrderBy(t *testing.T) {
+	tests := map[string]string{
+		commentSortNewest:    "c.created_at DESC, c.id DESC",
+		commentSortOldest:    "c.created_at, c.id",
+		commentSortTimestamp: "c.timestamp_seconds, c.created_at, c.id",
+		commentSortTop:       "(SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.id) DESC, c.created_at DESC, c.id DESC",
+	}
+
+	for sort, expected := range tests {
+		if orderBy := (commentListing{sort: sort}).orderBy("c"); orderBy != expected {
+			t.Errorf("orderBy(%s) = %q; expected %q", sort, orderBy, expected)
+		}
+	}
+}
+
+func TestCommentListingLess(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	older := Comment{ID: 1, CreatedAt: base, Reactions: map[string]int{"👍": 3, "🎉": 2}}
+	newer := Comment{ID: 2, CreatedAt: base.Add(time.Minute), Reactions: map[string]int{"👍": 1}}
+	twin := Comment{ID: 3, CreatedAt: base.Add(time.Minute), Reactions: map[string]int{"🎉": 1}}
+
+	tests := []struct {
+		sort     string
+		a, b     Comment
+		expected bool
+	}{
+		{commentSortNewest, newer, older, true},
+		{commentSortNewest, twin, newer, true},
+		{commentSortOldest, older, newer, true},
+		{commentSortOldest, newer, twin, true},
+		{commentSortTop, older, newer, true},
+		{commentSortTop, twin, newer, true},
+	}
+
+	for _, test := range tests {
+		l := commentListing{sort: test.sort}
+		if less := l.less(test.a, test.b); less != test.expected {
+			t.Errorf("less(%s, %d, %d) = %v; expected %v", test.sort, test.a.ID, test.b.ID, less, test.expected)
+		}
+	}
+}
+
+func TestCommentListingPage(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seconds := 42
+	comments := []Comment{
+		{ID: 3, CreatedAt: base.Add(2 * time.Minute)},
+		{ID: 2, CreatedAt: base.Add(time.Minute), Reactions: map[string]int{"👍": 3, "❤️": 1}, TimestampSeconds: &seconds},
+		{ID: 1, CreatedAt: base},
+	}
+	l := commentListing{sort: commentSortNewest, limit: 2}
+
+	page := l.page(comments, 10)
+	if len(page.Comments) != 2 || page.Total != 10 || page.NextCursor == "" {
+		t.Fatalf("page = %+v; expected 2 comments of 10 and a next cursor", page)
+	}
+	cursor, err := decodeCommentCursor(page.NextCursor)
+	if err != nil {
+		t.Fatalf("decodeCommentCursor(%q) error: %v", page.NextCursor, err)
+	}
+	expected := commentCursor{Sort: commentSortNewest, Score: 4, Timestamp: 42, CreatedAt: comments[1].CreatedAt, ID: 2}
+	if cursor != expected {
+		t.Errorf("cursor = %+v; expected %+v", cursor, expected)
+	}
+
+	if last := l.page(comments[:2], 2); last.NextCursor != "" || len(last.Comments) != 2 {
+		t.Errorf("last page = %+v; expected no next cursor", last)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Comment moderation states. Only approved comments that aren't hidden are
+// shown to non-admins.
+const (
+	commentPending  = "pending"
+	commentApproved = "approved"
+	commentRejected = "rejected"
+)
+
+// COMMENT_LINKS policies
+const (
+	linksAllow    = "allow"
+	linksModerate = "moderate"
+	linksBlock    = "block"
+)
+
+const maxCommentLength = 5000
+
+var (
+	errBannedWords = errors.New("contains banned words")
+	errLinks       = errors.New("links are not allowed")
+
+	linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+)
+
+// commentPolicy holds the moderation settings applied to new and edited
+// comments
+type commentPolicy struct {
+	moderation bool
+	links      string
+	banned     *regexp.Regexp
+}
+
+var commentRules commentPolicy
+
+// newCommentPolicy compiles the configured filters. Banned words match whole
+// words, ignoring case.
+func newCommentPolicy(moderation bool, bannedWords []string, links string) commentPolicy {
+	p := commentPolicy{moderation: moderation, links: links}
+	if p.links != linksModerate && p.links != linksBlock {
+		p.links = linksAllow
+	}
+
+	var quoted []string
+	for _, word := range bannedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) > 0 {
+		p.banned = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return p
+}
+
+// check runs the filters over a comment and returns the status it should be
+// stored with, or an error when it must be refused. Admin comments skip the
+// moderation queue but not the filters.
+func (p commentPolicy) check(author, content string, admin bool) (string, error) {
+	if p.banned != nil && (p.banned.MatchString(author) || p.banned.MatchString(content)) {
+		return "", errBannedWords
+	}
+
+	status := commentApproved
+	if p.moderation && !admin {
+		status = commentPending
+	}
+	if linkPattern.MatchString(content) {
+		switch p.links {
+		case linksBlock:
+			return "", errLinks
+		case linksModerate:
+			if !admin {
+				status = commentPending
+			}
+		}
+	}
+	return status, nil
+}
+
+// commentFilter restricts comments (under alias) to those a caller may see
+func commentFilter(alias string, admin bool) string {
+	if admin {
+		return "TRUE"
+	}
+	return alias + ".status = 'approved' AND NOT " + alias + ".hidden"
+}
+
+// loadOwnComment loads a comment for its author or an admin to change,
+// writing the error response when it returns false
+func loadOwnComment(w http.ResponseWriter, r *http.Request) (Comment, bool) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return Comment{}, false
+	}
+	commentID, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid comment ID")
+		return Comment{}, false
+	}
+
+	c, err := scanComment(db.QueryRow(`
+		SELECT `+commentColumns(true)+`
+		FROM comments c
+		WHERE c.id = $1 AND c.video_id = $2
+	`, commentID, videoID))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Comment not found")
+		return c, false
+	} else if err != nil {
+		logger.Printf("Error fetching comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comment")
+		return c, false
+	}
+
+	if isAdmin(r) {
+		return c, true
+	}
+	if user := requestUser(r); user == "" || user != c.Owner {
+		writeError(w, http.StatusForbidden, errorCodeForbidden, "Only the comment author can change it")
+		return c, false
+	}
+	return c, true
+}
+
+// editComment lets the author (or an admin) change a comment's content. The
+// edit is filtered like a new comment and may go back to the moderation queue.
+func editComment(w http.ResponseWriter, r *http.Request) {
+	c, ok := loadOwnComment(w, r)
+	if !ok {
+		return
+	}
+
+	var body commentEditInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	content := strings.TrimSpace(body.Content)
+	if content == "" {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Comment content is required")
+		return
+	}
+	if len(content) > maxCommentLength {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Comment content too long (max 5000 characters)")
+		return
+	}
+
+	status, err := commentRules.check(c.Author, content, isAdmin(r))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeCommentRejected, "Comment rejected: "+err.Error())
+		return
+	}
+	if c.Status != commentApproved && status == commentApproved {
+		// Editing never approves a comment a moderator hasn't
+		status = c.Status
+	}
+
+	wasVisible := c.Status == commentApproved && !c.Hidden
+	err = db.QueryRow(`
+		UPDATE comments SET content = $1, status = $2, edited_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING content, status, edited_at
+	`, content, status, c.ID).Scan(&c.Content, &c.Status, &c.EditedAt)
+	if err != nil {
+		logger.Printf("Error editing comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to edit comment")
+		return
+	}
+	publishCommentChange(c, wasVisible)
+
+	c.setMine(requestUser(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// deletedCommentText replaces the content and author of a comment its author
+// deleted while it had replies
+const deletedCommentText = "[deleted]"
+
+// deleteComment removes a comment. Admins may delete any comment, removing
+// its replies with it. Authors may delete their own; one that has replies is
+// kept as a "[deleted]" placeholder so the replies stay in their thread.
+func deleteComment(w http.ResponseWriter, r *http.Request) {
+	c, ok := loadOwnComment(w, r)
+	if !ok {
+		return
+	}
+
+	query := "DELETE FROM comments WHERE id = $1"
+	if !isAdmin(r) {
+		query += " AND NOT EXISTS(SELECT 1 FROM comments r WHERE r.parent_id = $1)"
+	}
+	result, err := db.Exec(query, c.ID)
+	if err != nil {
+		logger.Printf("Error deleting comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to delete comment")
+		return
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		logger.Printf("Deleted comment %d on video ID %d", c.ID, c.VideoID)
+		events.publish(eventCommentRemoved, c.VideoID, map[string]int{"id": c.ID, "video_id": c.VideoID})
+	} else {
+		wasVisible := c.Status == commentApproved && !c.Hidden
+		err := db.QueryRow(`
+			UPDATE comments SET content = $1, author = $1, owner = NULL
+			WHERE id = $2
+			RETURNING content, author
+		`, deletedCommentText, c.ID).Scan(&c.Content, &c.Author)
+		if err != nil {
+			logger.Printf("Error deleting comment: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to delete comment")
+			return
+		}
+		c.Owner = ""
+		logger.Printf("Replaced comment %d on video ID %d, which has replies, with a placeholder", c.ID, c.VideoID)
+		publishCommentChange(c, wasVisible)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusResponse{Status: "success"})
+}
+
+// publishCommentChange tells connected clients about a comment whose content
+// or visibility changed, and notifies users about a comment that was held
+// for moderation once it is shown
+func publishCommentChange(c Comment, wasVisible bool) {
+	visible := c.Status == commentApproved && !c.Hidden
+	switch {
+	case visible && wasVisible:
+		events.publish(eventCommentUpdated, c.VideoID, c)
+	case visible:
+		events.publish(eventCommentAdded, c.VideoID, c)
+		notifyComment(c)
+	case wasVisible:
+		events.publish(eventCommentRemoved, c.VideoID, map[string]int{"id": c.ID, "video_id": c.VideoID})
+	}
+}
+
+// getModerationQueue lists comments across all videos by status, oldest
+// first (admin). ?status= defaults to pending; ?hidden=true lists hidden
+// comments instead.
+func getModerationQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = commentPending
+	}
+	if status != commentPending && status != commentApproved && status != commentRejected {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid status")
+		return
+	}
+	hidden := r.URL.Query().Get("hidden") == "true"
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+
+	where := `c.status = $1`
+	args := []interface{}{status}
+	if hidden {
+		where = `c.hidden`
+		args = nil
+	}
+	limit, pageArgs := page.limitClause(args)
+	rows, err := db.Query(`SELECT `+commentColumns(true)+` FROM comments c WHERE `+where+` ORDER BY c.created_at, c.id `+limit, pageArgs...)
+	if err != nil {
+		logger.Printf("Error querying moderation queue: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comments")
+		return
+	}
+	defer rows.Close()
+
+	queue := []Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			logger.Printf("Error scanning comment: %v", err)
+			continue
+		}
+		queue = append(queue, c)
+	}
+
+	total, err := page.countRows(len(queue), `SELECT COUNT(*) FROM comments c WHERE `+where, args...)
+	if err != nil {
+		logger.Printf("Error counting moderation queue: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comments")
+		return
+	}
+	writePage(w, page, queue, total)
+}
+
+// moderateComment sets a comment's status and/or hidden flag (admin)
+func moderateComment(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid comment ID")
+		return
+	}
+
+	var body moderationInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	if body.Status == nil && body.Hidden == nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Nothing to change (expected status or hidden)")
+		return
+	}
+	if body.Status != nil && *body.Status != commentPending && *body.Status != commentApproved && *body.Status != commentRejected {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Invalid status")
+		return
+	}
+
+	c, err := scanComment(db.QueryRow(`SELECT `+commentColumns(true)+` FROM comments c WHERE c.id = $1`, commentID))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Comment not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comment")
+		return
+	}
+	wasVisible := c.Status == commentApproved && !c.Hidden
+
+	if body.Status != nil {
+		c.Status = *body.Status
+	}
+	if body.Hidden != nil {
+		c.Hidden = *body.Hidden
+	}
+	if _, err := db.Exec("UPDATE comments SET status = $1, hidden = $2 WHERE id = $3", c.Status, c.Hidden, c.ID); err != nil {
+		logger.Printf("Error moderating comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to moderate comment")
+		return
+	}
+	logger.Printf("Comment %d moderated: status %s, hidden %v", c.ID, c.Status, c.Hidden)
+	publishCommentChange(c, wasVisible)
+
+	c.setMine(requestUser(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql/driver"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommentPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  commentPolicy
+		author  string
+		content string
+		admin   bool
+		status  string
+		err     error
+	}{
+		{"default", newCommentPolicy(false, nil, ""), "Ann", "Great video", false, commentApproved, nil},
+		{"moderated", newCommentPolicy(true, nil, ""), "Ann", "Great video", false, commentPending, nil},
+		{"moderated admin", newCommentPolicy(true, nil, ""), "Ann", "Great video", true, commentApproved, nil},
+		{"banned word", newCommentPolicy(false, []string{"spam"}, ""), "Ann", "Buy SPAM now", false, "", errBannedWords},
+		{"banned author", newCommentPolicy(false, []string{"spam"}, ""), "Spam Bot", "Hello", false, "", errBannedWords},
+		{"banned whole words only", newCommentPolicy(false, []string{"spam"}, ""), "Ann", "Spammy but fine", false, commentApproved, nil},
+		{"banned admin", newCommentPolicy(false, []string{" spam ", ""}, ""), "Ann", "spam", true, "", errBannedWords},
+		{"links allowed", newCommentPolicy(false, nil, linksAllow), "Ann", "See https://example.com", false, commentApproved, nil},
+		{"links blocked", newCommentPolicy(false, nil, linksBlock), "Ann", "See www.example.com", false, "", errLinks},
+		{"links moderated", newCommentPolicy(false, nil, linksModerate), "Ann", "See http://example.com", false, commentPending, nil},
+		{"links moderated admin", newCommentPolicy(false, nil, linksModerate), "Ann", "See http://example.com", true, commentApproved, nil},
+		{"no link", newCommentPolicy(false, nil, linksBlock), "Ann", "example dot com", false, commentApproved, nil},
+	}
+
+	for _, test := range tests {
+		status, err := test.policy.check(test.author, test.content, test.admin)
+		if status != test.status || err != test.err {
+			t.Errorf("%s: check(%q, %q, %v) = %q, %v; expected %q, %v",
+				test.name, test.author, test.content, test.admin, status, err, test.status, test.err)
+		}
+	}
+}
+
+func TestNewCommentPolicyLinks(t *testing.T) {
+	tests := map[string]string{
+		"":         linksAllow,
+		"allow":    linksAllow,
+		"moderate": linksModerate,
+		"block":    linksBlock,
+		"bogus":    linksAllow,
+	}
+
+	for value, expected := range tests {
+		if p := newCommentPolicy(false, nil, value); p.links != expected {
+			t.Errorf("newCommentPolicy links %q = %q; expected %q", value, p.links, expected)
+		}
+	}
+}
+
+func TestCommentFilter(t *testing.T) {
+	if filter := commentFilter("c", true); filter != "TRUE" {
+		t.Errorf("commentFilter(%q, true) = %q; expected %q", "c", filter, "TRUE")
+	}
+	expected := "r.status = 'approved' AND NOT r.hidden"
+	if filter := commentFilter("r", false); filter != expected {
+		t.Errorf("commentFilter(%q, false) = %q; expected %q", "r", filter, expected)
+	}
+}
+
+func TestDeleteCommentKeepsRepliesForAuthors(t *testing.T) {
+	savedConfig, savedEvents, savedLogger := config, events, logger
+	defer func() { config, events, logger = savedConfig, savedEvents, savedLogger }()
+	config.AdminToken = "secret"
+	logger = log.New(io.Discard, "", 0)
+
+	var statements []string
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT EXISTS"):
+			return []string{"exists"}, [][]driver.Value{{true}}, nil
+		case strings.Contains(query, "WHERE c.id = $1 AND c.video_id = $2"):
+			created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			return make([]string, 13), [][]driver.Value{{int64(4), int64(3), nil, "Ann", "ann", "Hi", nil,
+				commentApproved, false, created, nil, int64(1), []byte("{}")}}, nil
+		case strings.Contains(query, "DELETE FROM comments"):
+			statements = append(statements, query)
+			if strings.Contains(query, "NOT EXISTS") {
+				// The comment has a reply, so nothing matches
+				return nil, nil, nil
+			}
+			return nil, [][]driver.Value{{}}, nil
+		case strings.Contains(query, "UPDATE comments"):
+			statements = append(statements, query)
+			return []string{"content", "author"}, [][]driver.Value{{deletedCommentText, deletedCommentText}}, nil
+		}
+		t.Errorf("unexpected query: %s", query)
+		return nil, nil, nil
+	})
+	router := newRouter()
+
+	for _, test := range []struct {
+		name       string
+		header     string
+		value      string
+		statements int
+		event      string
+	}{
+		{"author", userHeader, "ann", 2, eventCommentUpdated},
+		{"admin", "Authorization", "Bearer secret", 1, eventCommentRemoved},
+	} {
+		statements = nil
+		events = newTestBroker()
+		req := httptest.NewRequest("DELETE", "/api/v1/videos/3/comments/4", nil)
+		req.Header.Set(test.header, test.value)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: DELETE = %d: %s", test.name, rec.Code, rec.Body.String())
+			continue
+		}
+		if len(statements) != test.statements {
+			t.Errorf("%s: ran %q; expected %d statements", test.name, statements, test.statements)
+		}
+		if len(events.history) != 1 || events.history[0].Type != test.event {
+			t.Errorf("%s: published %+v; expected %s", test.name, events.history, test.event)
+		}
+	}
+}
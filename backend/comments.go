@@ -0,0 +1,456 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCommentDepth caps how many levels of replies one request can nest
+const maxCommentDepth = 10
+
+// Comment represents a comment on a video. ParentID is nil for top-level
+// comments. Replies is only filled in when a nesting depth is requested.
+// Owner is the X-User identity that posted it, which may edit or delete it;
+// it is never sent to clients, who instead see Mine on their own comments.
+// TimestampSeconds is the playback position the comment is about, if any.
+type Comment struct {
+	ID               int            `json:"id"`
+	VideoID          int            `json:"video_id"`
+	ParentID         *int           `json:"parent_id"`
+	Author           string         `json:"author"`
+	Owner            string         `json:"-"`
+	Mine             bool           `json:"mine"`
+	Content          string         `json:"content"`
+	TimestampSeconds *int           `json:"timestamp_seconds"`
+	Reactions        map[string]int `json:"reactions"`
+	Status           string         `json:"status"`
+	Hidden           bool           `json:"hidden"`
+	CreatedAt        time.Time      `json:"created_at"`
+	EditedAt         *time.Time     `json:"edited_at"`
+	ReplyCount       int            `json:"reply_count"`
+	Replies          []Comment      `json:"replies,omitempty"`
+}
+
+// setMine marks whether user posted the comment and each of its replies
+func (c *Comment) setMine(user string) {
+	c.Mine = user != "" && c.Owner == user
+	for i := range c.Replies {
+		c.Replies[i].setMine(user)
+	}
+}
+
+// setMine marks the comments user posted, see Comment.setMine
+func setMine(comments []Comment, user string) {
+	for i := range comments {
+		comments[i].setMine(user)
+	}
+}
+
+// commentColumns lists the columns read by scanComment, in order. Reply
+// counts only include replies the caller may see.
+func commentColumns(admin bool) string {
+	return `c.id, c.video_id, c.parent_id, c.author, COALESCE(c.owner, ''), c.content, c.timestamp_seconds,
+		c.status, c.hidden, c.created_at, c.edited_at, ` + commentReplyCount("c", admin) + `,
+		` + commentReactions.countsColumn("c.id")
+}
+
+// scanComment reads a row selected with commentColumns
+func scanComment(row interface{ Scan(...interface{}) error }) (Comment, error) {
+	var c Comment
+	var parentID, timestamp sql.NullInt64
+	var editedAt sql.NullTime
+	var reactions []byte
+	err := row.Scan(&c.ID, &c.VideoID, &parentID, &c.Author, &c.Owner, &c.Content, &timestamp,
+		&c.Status, &c.Hidden, &c.CreatedAt, &editedAt, &c.ReplyCount, &reactions)
+	if err != nil {
+		return c, err
+	}
+	c.Reactions = decodeReactions(reactions)
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+	if timestamp.Valid {
+		seconds := int(timestamp.Int64)
+		c.TimestampSeconds = &seconds
+	}
+	if editedAt.Valid {
+		c.EditedAt = &editedAt.Time
+	}
+	return c, err
+}
+
+// parseCommentDepth reads ?depth=, the number of reply levels to nest under
+// each returned comment. ok is false for an invalid value.
+func parseCommentDepth(r *http.Request) (depth int, present, ok bool) {
+	value := r.URL.Query().Get("depth")
+	if value == "" {
+		return 0, false, true
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 0 {
+		return 0, true, false
+	}
+	if depth > maxCommentDepth {
+		depth = maxCommentDepth
+	}
+	return depth, true, true
+}
+
+// loadCommentThreads loads a video's comments under parentID (nil for
+// top-level comments) and their replies down to depth levels, as a tree.
+// The listing filters, orders and pages the top level. Replies under a
+// comment the caller can't see are left out too.
+func loadCommentThreads(videoID int, parentID *int, depth int, admin bool, listing commentListing) ([]Comment, error) {
+	conditions, args := listing.pageConditions("c", []interface{}{videoID, depth, parentID})
+	limit, args := listing.limitClause(args)
+	rows, err := db.Query(`
+		WITH RECURSIVE thread AS (
+			(SELECT c.id, 0 AS depth FROM comments c
+			WHERE c.video_id = $1 AND c.parent_id IS NOT DISTINCT FROM $3::int AND `+commentFilter("c", admin)+`
+				AND `+conditions+`
+			ORDER BY `+listing.orderBy("c")+`
+			`+limit+`)
+			UNION ALL
+			SELECT c.id, t.depth + 1 FROM comments c JOIN thread t ON c.parent_id = t.id
+			WHERE t.depth < $2 AND `+commentFilter("c", admin)+`
+		)
+		SELECT `+commentColumns(admin)+`
+		FROM thread JOIN comments c ON c.id = thread.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buildCommentTree(comments, parentID, listing), nil
+}
+
+// buildCommentTree nests comments under their parents. Top-level comments
+// come in the listing's order; replies read as a conversation, oldest first.
+func buildCommentTree(comments []Comment, root *int, listing commentListing) []Comment {
+	children := make(map[int][]Comment)
+	var roots []Comment
+	for _, c := range comments {
+		if c.ParentID == nil || (root != nil && *c.ParentID == *root) {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+
+	var attach func(list []Comment)
+	attach = func(list []Comment) {
+		for i := range list {
+			replies := children[list[i].ID]
+			sort.SliceStable(replies, func(a, b int) bool {
+				return replies[a].CreatedAt.Before(replies[b].CreatedAt)
+			})
+			attach(replies)
+			list[i].Replies = replies
+		}
+	}
+
+	sort.SliceStable(roots, func(a, b int) bool {
+		if root != nil {
+			return roots[a].CreatedAt.Before(roots[b].CreatedAt)
+		}
+		return listing.less(roots[a], roots[b])
+	})
+	attach(roots)
+	if roots == nil {
+		roots = []Comment{}
+	}
+	return roots
+}
+
+// getComments lists a video's comments. Without ?depth= it returns every
+// comment, replies included, as a flat list newest first; with ?depth=N it
+// returns top-level comments with N levels of replies nested under each.
+// ?sort= and ?around= order and filter the list (see parseCommentListing).
+// With ?limit= or ?cursor= one page is returned in a commentPage envelope;
+// nested listings page through top-level comments. /api/v2 always returns a
+// page, as a listPage.
+// Admins also see pending, rejected and hidden comments.
+func getComments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	depth, nested, ok := parseCommentDepth(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid depth")
+		return
+	}
+	listing, err := parseCommentListing(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, err.Error())
+		return
+	}
+	v1 := requestAPIVersion(r) == apiV1
+	if !v1 && !listing.paginated() {
+		listing.limit = defaultCommentLimit
+	}
+
+	admin := isAdmin(r)
+	var comments []Comment
+	if nested {
+		videoID, ok := videoIDFromRoute(w, r)
+		if !ok {
+			return
+		}
+		comments, err = loadCommentThreads(videoID, nil, depth, admin, listing)
+	} else {
+		comments, err = loadComments(id, admin, listing)
+	}
+	if err != nil {
+		logger.Printf("Error querying comments: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comments")
+		return
+	}
+	setMine(comments, requestUser(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	if !listing.paginated() {
+		json.NewEncoder(w).Encode(comments)
+		return
+	}
+
+	total, err := countComments(id, nested, admin, listing)
+	if err != nil {
+		logger.Printf("Error counting comments: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comments")
+		return
+	}
+	page := listing.page(comments, total)
+	if v1 {
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	json.NewEncoder(w).Encode(listPage[Comment]{Items: page.Comments, Total: page.Total, NextCursor: page.NextCursor})
+}
+
+// loadComments loads a video's comments, replies included, as a flat list
+func loadComments(videoID string, admin bool, listing commentListing) ([]Comment, error) {
+	conditions, args := listing.pageConditions("c", []interface{}{videoID})
+	limit, args := listing.limitClause(args)
+	rows, err := db.Query(`
+		SELECT `+commentColumns(admin)+`
+		FROM comments c
+		WHERE c.video_id = $1 AND `+commentFilter("c", admin)+` AND `+conditions+`
+		ORDER BY `+listing.orderBy("c")+`
+		`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			logger.Printf("Error scanning comment: %v", err)
+			continue
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// commentFromRoute loads the parent comment named by {commentId}, checking it
+// belongs to the video and the caller can see it, and writes the error
+// response when it returns false
+func commentFromRoute(w http.ResponseWriter, r *http.Request, videoID int) (int, bool) {
+	commentID, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid comment ID")
+		return 0, false
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM comments c WHERE c.id = $1 AND c.video_id = $2 AND `+
+		commentFilter("c", isAdmin(r))+`)`, commentID, videoID).Scan(&exists)
+	if err != nil {
+		logger.Printf("Error checking comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch comment")
+		return 0, false
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Comment not found")
+		return 0, false
+	}
+	return commentID, true
+}
+
+// getReplies lists the replies to a comment, oldest first, with ?depth=
+// further levels nested under each (default 0)
+func getReplies(w http.ResponseWriter, r *http.Request) {
+	depth, _, ok := parseCommentDepth(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid depth")
+		return
+	}
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := commentFromRoute(w, r, videoID)
+	if !ok {
+		return
+	}
+
+	replies, err := loadCommentThreads(videoID, &commentID, depth, isAdmin(r), commentListing{})
+	if err != nil {
+		logger.Printf("Error querying replies: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch replies")
+		return
+	}
+	setMine(replies, requestUser(r))
+
+	writeList(w, r, replies)
+}
+
+// comment returns the new comment a request body describes
+func (in commentInput) comment() Comment {
+	return Comment{Author: in.Author, Content: in.Content, TimestampSeconds: in.TimestampSeconds}
+}
+
+func addComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var body commentInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	insertComment(w, r, id, body.comment())
+}
+
+// addReply adds a comment in reply to {commentId}
+func addReply(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := commentFromRoute(w, r, videoID)
+	if !ok {
+		return
+	}
+
+	var body commentInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	comment := body.comment()
+	comment.ParentID = &commentID
+
+	insertComment(w, r, strconv.Itoa(videoID), comment)
+}
+
+// insertComment sanitizes, filters and stores a new comment or reply. When
+// moderation holds it back it is stored as pending and not broadcast.
+func insertComment(w http.ResponseWriter, r *http.Request, id string, comment Comment) {
+	// Sanitize and validate input
+	comment.Author = strings.TrimSpace(comment.Author)
+	comment.Content = strings.TrimSpace(comment.Content)
+
+	// Limit author name length
+	if len(comment.Author) > 100 {
+		comment.Author = comment.Author[:100]
+	}
+
+	// Limit content length
+	if len(comment.Content) > maxCommentLength {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Comment content too long (max 5000 characters)")
+		return
+	}
+
+	if comment.Author == "" {
+		comment.Author = "Anonymous"
+	}
+
+	if comment.Content == "" {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Comment content is required")
+		return
+	}
+
+	// A timestamp must fall within the video when its duration is known
+	if comment.TimestampSeconds != nil {
+		if *comment.TimestampSeconds < 0 {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, "Invalid timestamp_seconds")
+			return
+		}
+		var duration int
+		err := db.QueryRow("SELECT COALESCE(duration, 0) FROM videos WHERE id = $1", id).Scan(&duration)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Printf("Error fetching video duration: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add comment")
+			return
+		}
+		if duration > 0 && *comment.TimestampSeconds > duration {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, "timestamp_seconds is past the end of the video")
+			return
+		}
+	}
+
+	// Apply banned-word, link and moderation rules
+	status, err := commentRules.check(comment.Author, comment.Content, isAdmin(r))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeCommentRejected, "Comment rejected: "+err.Error())
+		return
+	}
+	comment.Status = status
+	comment.Owner = requestUser(r)
+
+	var owner sql.NullString
+	if comment.Owner != "" {
+		owner = sql.NullString{String: comment.Owner, Valid: true}
+	}
+	err = db.QueryRow(`
+		INSERT INTO comments (video_id, parent_id, author, owner, content, timestamp_seconds, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, video_id, created_at
+	`, id, comment.ParentID, comment.Author, owner, comment.Content, comment.TimestampSeconds, comment.Status).Scan(&comment.ID, &comment.VideoID, &comment.CreatedAt)
+
+	if err != nil {
+		logger.Printf("Error inserting comment: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add comment")
+		return
+	}
+	comment.Hidden = false
+	comment.EditedAt = nil
+	comment.ReplyCount = 0
+	comment.Reactions = map[string]int{}
+	comment.Replies = nil
+	if comment.Status == commentApproved {
+		events.publish(eventCommentAdded, comment.VideoID, comment)
+		notifyComment(comment)
+	} else {
+		logger.Printf("Comment %d on video ID %d is awaiting moderation", comment.ID, comment.VideoID)
+	}
+
+	comment.setMine(comment.Owner)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
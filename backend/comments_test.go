@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCommentTree(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	parent := func(id int) *int { return &id }
+	comments := []Comment{
+		{ID: 1, CreatedAt: base},
+		{ID: 2, CreatedAt: base.Add(time.Hour)},
+		{ID: 3, ParentID: parent(1), CreatedAt: base.Add(3 * time.Minute)},
+		{ID: 4, ParentID: parent(1), CreatedAt: base.Add(2 * time.Minute)},
+		{ID: 5, ParentID: parent(4), CreatedAt: base.Add(4 * time.Minute)},
+	}
+
+	newest := commentListing{sort: commentSortNewest}
+	tree := buildCommentTree(comments, nil, newest)
+	if len(tree) != 2 || tree[0].ID != 2 || tree[1].ID != 1 {
+		t.Fatalf("Expected top-level comments newest first, got %+v", tree)
+	}
+	replies := tree[1].Replies
+	if len(replies) != 2 || replies[0].ID != 4 || replies[1].ID != 3 {
+		t.Fatalf("Expected replies oldest first, got %+v", replies)
+	}
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].ID != 5 {
+		t.Errorf("Expected reply 5 nested under reply 4, got %+v", replies[0].Replies)
+	}
+	if tree[0].Replies != nil {
+		t.Errorf("Expected comment without replies to have none, got %+v", tree[0].Replies)
+	}
+
+	thread := buildCommentTree(comments[2:], parent(1), newest)
+	if len(thread) != 2 || thread[0].ID != 4 || thread[1].ID != 3 || len(thread[0].Replies) != 1 {
+		t.Errorf("Expected replies to comment 1 oldest first with nesting, got %+v", thread)
+	}
+
+	if empty := buildCommentTree(nil, nil, newest); empty == nil || len(empty) != 0 {
+		t.Errorf("Expected empty (non-nil) list, got %#v", empty)
+	}
+}
+
+func TestBuildCommentTreeByTimestamp(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seconds := func(s int) *int { return &s }
+	comments := []Comment{
+		{ID: 1, TimestampSeconds: seconds(90), CreatedAt: base},
+		{ID: 2, TimestampSeconds: seconds(30), CreatedAt: base.Add(time.Hour)},
+		{ID: 3, TimestampSeconds: seconds(90), CreatedAt: base.Add(-time.Hour)},
+	}
+
+	tree := buildCommentTree(comments, nil, commentListing{sort: commentSortTimestamp})
+	if len(tree) != 3 || tree[0].ID != 2 || tree[1].ID != 3 || tree[2].ID != 1 {
+		t.Errorf("Expected comments by timestamp then oldest first, got %+v", tree)
+	}
+}
+
+func TestParseCommentDepth(t *testing.T) {
+	tests := []struct {
+		query   string
+		depth   int
+		present bool
+		ok      bool
+	}{
+		{"", 0, false, true},
+		{"depth=0", 0, true, true},
+		{"depth=3", 3, true, true},
+		{"depth=99", maxCommentDepth, true, true},
+		{"depth=-1", 0, true, false},
+		{"depth=all", 0, true, false},
+	}
+
+	for _, test := range tests {
+		depth, present, ok := parseCommentDepth(httptest.NewRequest("GET", "/comments?"+test.query, nil))
+		if depth != test.depth || present != test.present || ok != test.ok {
+			t.Errorf("parseCommentDepth(%q) = %d, %v, %v; expected %d, %v, %v",
+				test.query, depth, present, ok, test.depth, test.present, test.ok)
+		}
+	}
+}
+
+func TestCommentsHideOwners(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return make([]string, 13), [][]driver.Value{
+			{int64(1), int64(3), nil, "Ann", "guest-ann", "Hi", nil, commentApproved, false, created, nil, int64(0), []byte("{}")},
+			{int64(2), int64(3), nil, "Bob", "guest-bob", "Hey", nil, commentApproved, false, created, nil, int64(0), []byte("{}")},
+		}, nil
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/videos/3/comments", nil)
+	req.Header.Set(userHeader, "guest-ann")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+
+	if strings.Contains(rec.Body.String(), "guest-") {
+		t.Errorf("comment listing exposes owners: %s", rec.Body.String())
+	}
+	var comments []Comment
+	if err := json.Unmarshal(rec.Body.Bytes(), &comments); err != nil || len(comments) != 2 {
+		t.Fatalf("GET comments = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !comments[0].Mine || comments[1].Mine {
+		t.Errorf("mine = %v, %v; expected only Ann's comment to be hers", comments[0].Mine, comments[1].Mine)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	subtitleSourceEmbedded = "embedded"
+
+	// subtitleCacheDir holds extracted embedded tracks, under CONFIG_DIR
+	subtitleCacheDir = "subtitles"
+
+	// extractTimeout bounds one ffmpeg extraction; ffmpeg has to read through
+	// the whole file to collect a subtitle stream
+	extractTimeout = 10 * time.Minute
+)
+
+// textSubtitleCodecs lists the embedded subtitle codecs that can be turned
+// into WebVTT. Bitmap formats (PGS, VobSub, DVB) would need OCR.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// embeddedSubtitleTracks picks the text subtitle streams out of a probe
+func embeddedSubtitleTracks(streams []probeStream) []SubtitleTrack {
+	var tracks []SubtitleTrack
+	for _, stream := range streams {
+		if stream.CodecType != "subtitle" || !textSubtitleCodecs[stream.CodecName] {
+			continue
+		}
+
+		track := SubtitleTrack{
+			Format:          stream.CodecName,
+			Source:          subtitleSourceEmbedded,
+			Forced:          stream.Disposition["forced"] == 1,
+			HearingImpaired: stream.Disposition["hearing_impaired"] == 1,
+			streamIndex:     stream.Index,
+		}
+		if language := stream.Tags["language"]; language != "" && language != "und" {
+			track.Language = normalizeLanguageCode(language)
+		}
+		track.Label = subtitleLabel(track)
+		if title := strings.TrimSpace(stream.Tags["title"]); title != "" {
+			track.Label = title
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// syncEmbeddedSubtitles records a video's embedded text subtitle streams
+// after probing. Extracted copies are dropped since the file has changed.
+func syncEmbeddedSubtitles(videoID int, videoPath string, streams []probeStream) {
+	tracks := embeddedSubtitleTracks(streams)
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	indexes := make([]int, len(tracks))
+	for i, track := range tracks {
+		indexes[i] = track.streamIndex
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM subtitle_tracks
+		WHERE video_id = $1 AND source = $2 AND (path <> $3 OR NOT (stream_index = ANY($4)))
+	`, videoID, subtitleSourceEmbedded, videoPath, pq.Array(indexes)); err != nil {
+		logger.Printf("Error removing embedded subtitles for video ID %d: %v", videoID, err)
+		return
+	}
+
+	for _, track := range tracks {
+		if _, err := tx.Exec(`
+			INSERT INTO subtitle_tracks (video_id, source, path, stream_index, language, label, format, forced, hearing_impaired)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (video_id, path, stream_index) DO UPDATE
+			SET language = EXCLUDED.language, label = EXCLUDED.label, format = EXCLUDED.format,
+				forced = EXCLUDED.forced, hearing_impaired = EXCLUDED.hearing_impaired
+		`, videoID, track.Source, videoPath, track.streamIndex, track.Language, track.Label, track.Format,
+			track.Forced, track.HearingImpaired); err != nil {
+			logger.Printf("Error saving embedded subtitle for video ID %d: %v", videoID, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing embedded subtitles for video ID %d: %v", videoID, err)
+		return
+	}
+
+	removeCachedSubtitles(videoID)
+	if len(tracks) > 0 {
+		logger.Printf("Found %d embedded subtitle tracks in video ID %d", len(tracks), videoID)
+	}
+}
+
+// subtitleCachePath is where an extracted track is kept
+func subtitleCachePath(videoID, streamIndex int) string {
+	return filepath.Join(config.ConfigDir, subtitleCacheDir, fmt.Sprintf("%d_%d.vtt", videoID, streamIndex))
+}
+
+// removeCachedSubtitles deletes every extracted track of a video
+func removeCachedSubtitles(videoID int) {
+	matches, _ := filepath.Glob(filepath.Join(config.ConfigDir, subtitleCacheDir, fmt.Sprintf("%d_*.vtt", videoID)))
+	for _, path := range matches {
+		if err := os.Remove(path); err != nil {
+			logger.Printf("Warning: Cannot remove cached subtitle %s: %v", path, err)
+		}
+	}
+}
+
+// cachedEmbeddedSubtitle returns an embedded track's extracted WebVTT, or
+// false when it hasn't been extracted since the video last changed
+func cachedEmbeddedSubtitle(videoID int, track SubtitleTrack) (string, bool) {
+	cachePath := subtitleCachePath(videoID, track.streamIndex)
+	videoInfo, err := os.Stat(track.path)
+	if err != nil {
+		return "", false
+	}
+	cacheInfo, err := os.Stat(cachePath)
+	if err != nil || cacheInfo.ModTime().Before(videoInfo.ModTime()) {
+		return "", false
+	}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// subtitleJobPayload names the track a subtitle job extracts
+type subtitleJobPayload struct {
+	Track int `json:"track"`
+}
+
+// queueSubtitleExtraction schedules extraction of an embedded track
+func queueSubtitleExtraction(videoID, trackID int) (bool, error) {
+	return enqueueJob("subtitle", videoID, subtitleJobPayload{Track: trackID}, jobPriorityRequest)
+}
+
+// runSubtitleJob is the job handler for embedded subtitle extraction
+func runSubtitleJob(ctx context.Context, job Job) error {
+	videoID, err := jobVideoID(job)
+	if err != nil {
+		return err
+	}
+	var payload subtitleJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("reading payload: %w", err)
+	}
+	track, err := scanSubtitleTrack(db.QueryRow(`
+		SELECT `+subtitleColumns+`
+		FROM subtitle_tracks
+		WHERE id = $1 AND video_id = $2 AND source = $3
+	`, payload.Track, videoID, subtitleSourceEmbedded))
+	if err != nil {
+		return fmt.Errorf("loading subtitle track %d: %w", payload.Track, err)
+	}
+	return extractEmbeddedSubtitle(ctx, videoID, track)
+}
+
+// extractEmbeddedSubtitle extracts an embedded track as WebVTT with ffmpeg at
+// the lowest CPU priority. The cached copy is reused until the video changes.
+func extractEmbeddedSubtitle(ctx context.Context, videoID int, track SubtitleTrack) error {
+	if _, ok := cachedEmbeddedSubtitle(videoID, track); ok {
+		return nil
+	}
+	if _, err := os.Stat(track.path); err != nil {
+		return fmt.Errorf("video not accessible: %w", err)
+	}
+
+	cachePath := subtitleCachePath(videoID, track.streamIndex)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return fmt.Errorf("creating subtitle cache: %w", err)
+	}
+	tmpPath := cachePath + ".tmp"
+	defer os.Remove(tmpPath)
+
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+
+	output, err := lowPriorityCommand(ctx,
+		"-v", "error",
+		"-y",
+		"-i", track.path,
+		"-map", fmt.Sprintf("0:%d", track.streamIndex),
+		"-c:s", "webvtt",
+		"-f", "webvtt",
+		tmpPath,
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return fmt.Errorf("caching subtitle: %w", err)
+	}
+
+	logger.Printf("Extracted subtitle stream %d of video ID %d", track.streamIndex, videoID)
+	return nil
+}
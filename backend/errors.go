@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Error codes returned in the "code" field of error responses. Clients should
+// branch on these rather than on messages, which may change.
+const (
+	errorCodeInvalidID        = "invalid_id"
+	errorCodeInvalidParameter = "invalid_parameter"
+	errorCodeInvalidBody      = "invalid_body"
+	errorCodeValidation       = "validation_failed"
+	errorCodeCommentRejected  = "comment_rejected"
+	errorCodeIdentityRequired = "identity_required"
+	errorCodeAdminRequired    = "admin_required"
+	errorCodeForbidden        = "forbidden"
+	errorCodeNotFound         = "not_found"
+	errorCodeMethodNotAllowed = "method_not_allowed"
+	errorCodeConflict         = "conflict"
+	errorCodeRateLimited      = "rate_limited"
+	errorCodeUnavailable      = "unavailable"
+	errorCodeInternal         = "internal_error"
+)
+
+// requestIDHeader carries the ID that ties a response to the server's logs
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs passed in by clients or proxies
+const maxRequestIDLength = 64
+
+var validRequestID = regexp.MustCompile(`^[\w.-]+$`)
+
+// numericRouteParams are the path parameters holding database IDs and
+// numbers. They fit Postgres INTEGER columns, so larger values are refused
+// before reaching a query.
+var numericRouteParams = map[string]bool{
+	"id":        true,
+	"commentId": true,
+	"videoId":   true,
+	"season":    true,
+	"track":     true,
+	"sprite":    true,
+}
+
+// apiError is the body of every error response, under "error"
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+// writeError writes a JSON error response, the same in every API version
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails writes a JSON error response with details about what
+// went wrong, such as the parameter that was invalid
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]apiError{"error": {
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(requestIDHeader),
+	}})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID gives every request an ID, reusing a well-formed X-Request-ID
+// from the client or a proxy, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if len(id) > maxRequestIDLength || !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateRouteParams refuses requests whose numeric path parameters aren't
+// non-negative integers, so handlers never pass them to Postgres as text
+func validateRouteParams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range mux.Vars(r) {
+			if !numericRouteParams[name] {
+				continue
+			}
+			if n, err := strconv.ParseInt(value, 10, 32); err != nil || n < 0 || value[0] == '+' {
+				writeErrorDetails(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid "+name+" (expected a number)",
+					map[string]string{"parameter": name, "value": value})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFound answers requests no route matched. gorilla/mux only reports a
+// method mismatch under a subrouter when the last route registered has the
+// path, so routes serving the path with other methods are looked for here.
+func notFound(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			methods, err := route.GetMethods()
+			if err != nil {
+				return nil
+			}
+			var match mux.RouteMatch
+			if !route.Match(r, &match) && match.MatchErr == mux.ErrMethodMismatch {
+				allowed = append(allowed, methods...)
+			}
+			return nil
+		})
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			methodNotAllowed(w, r)
+			return
+		}
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "No such route: "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// methodNotAllowed answers requests for a route with a method it doesn't serve
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// decodeError reads the envelope written by writeError
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var body map[string]apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error response isn't JSON: %v (%q)", err, rec.Body.String())
+	}
+	return body["error"]
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set(requestIDHeader, "abc123")
+	writeErrorDetails(rec, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid limit", map[string]string{"parameter": "limit"})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; expected 400", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q; expected application/json", ct)
+	}
+	e := decodeError(t, rec)
+	if e.Code != errorCodeInvalidParameter || e.Message != "Invalid limit" || e.RequestID != "abc123" {
+		t.Errorf("error = %+v; expected invalid_parameter, the message and the request ID", e)
+	}
+	if details, _ := e.Details.(map[string]interface{}); details["parameter"] != "limit" {
+		t.Errorf("details = %v; expected the parameter", e.Details)
+	}
+
+	rec = httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, errorCodeNotFound, "Video not found")
+	if e := decodeError(t, rec); e.Details != nil {
+		t.Errorf("details = %v; expected none", e.Details)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = w.Header().Get(requestIDHeader)
+	}))
+
+	tests := []struct {
+		incoming string
+		keep     bool
+	}{
+		{"", false},
+		{"req-42.a_b", true},
+		{"has spaces", false},
+		{"<script>", false},
+		{string(make([]byte, maxRequestIDLength+1)), false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/api/videos", nil)
+		if test.incoming != "" {
+			req.Header.Set(requestIDHeader, test.incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(requestIDHeader)
+		if id == "" || id != seen {
+			t.Errorf("request ID %q: response has %q, handler saw %q", test.incoming, id, seen)
+		}
+		if kept := id == test.incoming; kept != test.keep {
+			t.Errorf("request ID %q: kept = %v; expected %v", test.incoming, kept, test.keep)
+		}
+	}
+}
+
+func TestValidateRouteParams(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(validateRouteParams)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/videos/{id}/comments/{commentId}", ok)
+	router.HandleFunc("/playlists/{playlistId}", ok)
+	router.HandleFunc("/tags/{tag}", ok)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/videos/12/comments/3", http.StatusOK},
+		{"/videos/0/comments/3", http.StatusOK},
+		{"/videos/abc/comments/3", http.StatusBadRequest},
+		{"/videos/12/comments/x1", http.StatusBadRequest},
+		{"/videos/-1/comments/3", http.StatusBadRequest},
+		{"/videos/+1/comments/3", http.StatusBadRequest},
+		{"/videos/99999999999/comments/3", http.StatusBadRequest},
+		{"/playlists/up_3", http.StatusOK},
+		{"/tags/rock", http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("GET %s = %d; expected %d", test.path, rec.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusBadRequest {
+			if e := decodeError(t, rec); e.Code != errorCodeInvalidID {
+				t.Errorf("GET %s code = %q; expected %q", test.path, e.Code, errorCodeInvalidID)
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types sent on GET /api/events
+const (
+	eventVideoAdded     = "video.added"
+	eventVideoUpdated   = "video.updated"
+	eventVideoRemoved   = "video.removed"
+	eventScanStarted    = "scan.started"
+	eventScanProgress   = "scan.progress"
+	eventScanCompleted  = "scan.completed"
+	eventScanFailed     = "scan.failed"
+	eventCommentAdded   = "comment.added"
+	eventCommentUpdated = "comment.updated"
+	eventCommentRemoved = "comment.removed"
+	eventLikesUpdated   = "likes.updated"
+
+	eventReactionsUpdated = "reactions.updated"
+
+	// eventNotification is only sent to the user it is addressed to
+	eventNotification = "notification"
+
+	// eventReset tells a resuming client that events it missed are no longer
+	// buffered and it should reload whatever it displays
+	eventReset = "reset"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients
+	// resuming with Last-Event-ID
+	eventHistorySize = 1000
+
+	// eventClientBuffer is how many events may wait for a slow client before
+	// it is disconnected; it catches up from the history when it reconnects
+	eventClientBuffer = 64
+
+	// eventHeartbeat keeps idle connections open through proxies
+	eventHeartbeat = 30 * time.Second
+
+	// eventRetry is the reconnection delay suggested to clients, in milliseconds
+	eventRetry = 5000
+
+	// scanProgressInterval is how many files a scan reads between progress events
+	scanProgressInterval = 50
+)
+
+// Event is a change broadcast to connected clients. VideoID is 0 for
+// library-wide events such as scan progress. Events with a User are only
+// sent to clients connected as that user. Events carrying links have Data
+// encoded once per API prefix in Linked, keyed by apiBase.
+type Event struct {
+	ID      int64
+	Type    string
+	VideoID int
+	User    string
+	Data    []byte
+	Linked  map[string][]byte
+}
+
+// dataFor returns the event's data as sent to a client connected under base
+func (e Event) dataFor(base string) []byte {
+	if data, ok := e.Linked[base]; ok {
+		return data
+	}
+	return e.Data
+}
+
+// eventSubscriber is one connected client
+type eventSubscriber struct {
+	events   chan Event
+	user     string
+	videoIDs map[int]bool
+	types    map[string]bool
+	base     string // API prefix the client connected under
+}
+
+// wants reports whether the client asked for the event
+func (s *eventSubscriber) wants(e Event) bool {
+	if e.User != "" && e.User != s.user {
+		return false
+	}
+	if s.types != nil && !s.types[e.Type] && e.Type != eventReset {
+		return false
+	}
+	if s.videoIDs != nil && !s.videoIDs[e.VideoID] && e.Type != eventReset {
+		return false
+	}
+	return true
+}
+
+// eventBroker fans events out to subscribers and keeps a short history
+type eventBroker struct {
+	mu          sync.Mutex
+	lastID      int64
+	history     []Event
+	subscribers map[*eventSubscriber]bool
+}
+
+// Event IDs start at the boot time in milliseconds so they keep increasing
+// across restarts, and an ID from before a restart is simply too old to replay
+var events = &eventBroker{
+	lastID:      time.Now().UnixMilli(),
+	subscribers: make(map[*eventSubscriber]bool),
+}
+
+// publish broadcasts an event. Data is encoded as JSON.
+func (b *eventBroker) publish(eventType string, videoID int, data interface{}) {
+	b.publishTo("", eventType, videoID, data)
+}
+
+// publishTo sends an event only to clients connected as user, or to everyone
+// when user is empty
+func (b *eventBroker) publishTo(user, eventType string, videoID int, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		logger.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+	b.send(Event{Type: eventType, VideoID: videoID, User: user, Data: encoded})
+}
+
+// send numbers an event, keeps it in the history and hands it to every
+// subscriber that wants it
+func (b *eventBroker) send(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for s := range b.subscribers {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			// Too slow; drop it so it reconnects and resumes from the history
+			delete(b.subscribers, s)
+			close(s.events)
+		}
+	}
+}
+
+// subscribe registers a client and returns the buffered events after
+// lastEventID it should be sent first. A reset event is returned instead when
+// events after lastEventID have already been dropped from the history.
+func (b *eventBroker) subscribe(s *eventSubscriber, lastEventID int64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[s] = true
+	if lastEventID <= 0 || lastEventID >= b.lastID {
+		return nil
+	}
+	if len(b.history) == 0 || b.history[0].ID > lastEventID+1 {
+		return []Event{{ID: b.lastID, Type: eventReset, Data: []byte("{}")}}
+	}
+
+	var missed []Event
+	for _, e := range b.history {
+		if e.ID > lastEventID && s.wants(e) {
+			missed = append(missed, e)
+		}
+	}
+	return missed
+}
+
+func (b *eventBroker) unsubscribe(s *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// publishVideo broadcasts a video.added or video.updated event carrying the
+// video as returned by GET /api/videos/{id}
+func publishVideo(eventType string, videoID int) {
+	v, err := scanVideo(db.QueryRow(`
+		SELECT `+videoColumns+`
+		FROM `+videoTables+`
+		WHERE v.id = $1
+	`, videoID))
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		logger.Printf("Error loading video ID %d for %s event: %v", videoID, eventType, err)
+		return
+	}
+	publishVideoData(eventType, v)
+}
+
+// publishVideoData broadcasts a video event with the video's media URLs under
+// each API prefix, so clients are linked to the version they connected to
+func publishVideoData(eventType string, v Video) {
+	linked := make(map[string][]byte, len(apiBases))
+	for _, base := range apiBases {
+		v.setLinks(base)
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			logger.Printf("Error encoding %s event: %v", eventType, err)
+			return
+		}
+		linked[base] = encoded
+	}
+	events.send(Event{Type: eventType, VideoID: v.ID, Data: linked["/api"], Linked: linked})
+}
+
+// writeEvent writes one event in text/event-stream format, as sent to a
+// client connected under base
+func writeEvent(w http.ResponseWriter, e Event, base string) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.dataFor(base))
+	return err
+}
+
+// parseEventFilter reads a repeatable, comma-separated query parameter
+func parseEventFilter(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// getEvents streams events to the client with Server-Sent Events. Clients
+// may narrow the stream with ?video_id= and ?type= (both repeatable or
+// comma-separated) and resume with the Last-Event-ID header, or the
+// last_event_id parameter when the header can't be set. Clients identify
+// themselves with X-User, or ?user= since EventSource can't set headers, to
+// receive their notifications.
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Streaming unsupported")
+		return
+	}
+
+	query := r.URL.Query()
+	s := &eventSubscriber{events: make(chan Event, eventClientBuffer), user: requestUser(r), base: apiBase(r)}
+	if s.user == "" {
+		s.user = strings.TrimSpace(query.Get("user"))
+		if len(s.user) > maxUserLength {
+			s.user = s.user[:maxUserLength]
+		}
+	}
+	if ids := parseEventFilter(query["video_id"]); len(ids) > 0 {
+		s.videoIDs = make(map[int]bool)
+		for _, value := range ids {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid video ID")
+				return
+			}
+			s.videoIDs[id] = true
+		}
+	}
+	if types := parseEventFilter(query["type"]); len(types) > 0 {
+		s.types = make(map[string]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	var resumeFrom int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid Last-Event-ID")
+			return
+		}
+		resumeFrom = id
+	}
+
+	missed := events.subscribe(s, resumeFrom)
+	defer events.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+	for _, e := range missed {
+		if err := writeEvent(w, e, s.base); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, open := <-s.events:
+			if !open {
+				return
+			}
+			if err := writeEvent(w, e, s.base); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestBroker() *eventBroker {
+	return &eventBroker{lastID: 100, subscribers: make(map[*eventSubscriber]bool)}
+}
+
+func TestEventSubscriberFilters(t *testing.T) {
+	tests := []struct {
+		name       string
+		subscriber eventSubscriber
+		event      Event
+		expected   bool
+	}{
+		{"no filter", eventSubscriber{}, Event{Type: eventScanStarted}, true},
+		{"matching video", eventSubscriber{videoIDs: map[int]bool{7: true}}, Event{Type: eventCommentAdded, VideoID: 7}, true},
+		{"other video", eventSubscriber{videoIDs: map[int]bool{7: true}}, Event{Type: eventCommentAdded, VideoID: 8}, false},
+		{"library-wide with video filter", eventSubscriber{videoIDs: map[int]bool{7: true}}, Event{Type: eventScanProgress}, false},
+		{"matching type", eventSubscriber{types: map[string]bool{eventVideoAdded: true}}, Event{Type: eventVideoAdded, VideoID: 3}, true},
+		{"other type", eventSubscriber{types: map[string]bool{eventVideoAdded: true}}, Event{Type: eventLikesUpdated, VideoID: 3}, false},
+		{"reset always sent", eventSubscriber{videoIDs: map[int]bool{7: true}, types: map[string]bool{eventVideoAdded: true}}, Event{Type: eventReset}, true},
+	}
+
+	for _, test := range tests {
+		if got := test.subscriber.wants(test.event); got != test.expected {
+			t.Errorf("wants(%s) = %v; expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestEventBrokerResume(t *testing.T) {
+	b := newTestBroker()
+	b.publish(eventVideoAdded, 1, map[string]int{"id": 1})
+	b.publish(eventVideoAdded, 2, map[string]int{"id": 2})
+	b.publish(eventCommentAdded, 1, map[string]int{"id": 9})
+
+	s := &eventSubscriber{events: make(chan Event, 1), videoIDs: map[int]bool{1: true}}
+	missed := b.subscribe(s, 101)
+	if len(missed) != 1 || missed[0].ID != 103 || missed[0].Type != eventCommentAdded {
+		t.Errorf("Expected to resume with comment event 103, got %+v", missed)
+	}
+
+	if missed := b.subscribe(&eventSubscriber{events: make(chan Event, 1)}, 103); len(missed) != 0 {
+		t.Errorf("Expected nothing to replay for an up-to-date client, got %+v", missed)
+	}
+
+	b.history = b.history[2:]
+	missed = b.subscribe(&eventSubscriber{events: make(chan Event, 1)}, 100)
+	if len(missed) != 1 || missed[0].Type != eventReset {
+		t.Errorf("Expected reset for events no longer buffered, got %+v", missed)
+	}
+}
+
+func TestEventBrokerDropsSlowSubscribers(t *testing.T) {
+	b := newTestBroker()
+	s := &eventSubscriber{events: make(chan Event, 1)}
+	b.subscribe(s, 0)
+
+	b.publish(eventScanStarted, 0, struct{}{})
+	b.publish(eventScanCompleted, 0, struct{}{})
+
+	if b.subscribers[s] {
+		t.Error("Expected slow subscriber to be dropped")
+	}
+	if _, open := <-s.events; !open {
+		t.Error("Expected buffered event to still be readable")
+	}
+	if _, open := <-s.events; open {
+		t.Error("Expected dropped subscriber's channel to be closed")
+	}
+	b.unsubscribe(s)
+}
+
+func TestGetEventsReplaysMissedEvents(t *testing.T) {
+	saved := events
+	events = newTestBroker()
+	defer func() { events = saved }()
+
+	events.publish(eventVideoAdded, 1, map[string]int{"id": 1})
+	events.publish(eventLikesUpdated, 1, map[string]int{"video_id": 1, "likes": 4})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/api/events?type=likes.updated", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "100")
+	rec := httptest.NewRecorder()
+	getEvents(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q; expected text/event-stream", ct)
+	}
+	body := rec.Body.String()
+	expected := "id: 102\nevent: likes.updated\ndata: {\"likes\":4,\"video_id\":1}\n\n"
+	if !strings.Contains(body, expected) {
+		t.Errorf("Expected stream to contain %q, got %q", expected, body)
+	}
+	if strings.Contains(body, "video.added") {
+		t.Errorf("Expected video.added to be filtered out, got %q", body)
+	}
+
+	rec = httptest.NewRecorder()
+	getEvents(rec, httptest.NewRequest("GET", "/api/events?video_id=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid video ID, got %d", rec.Code)
+	}
+}
+
+func TestEventSubscriberUserEvents(t *testing.T) {
+	alice := &eventSubscriber{user: "alice"}
+	anonymous := &eventSubscriber{}
+
+	for _, test := range []struct {
+		s        *eventSubscriber
+		e        Event
+		expected bool
+	}{
+		{alice, Event{Type: eventNotification, User: "alice"}, true},
+		{alice, Event{Type: eventNotification, User: "bob"}, false},
+		{anonymous, Event{Type: eventNotification, User: "alice"}, false},
+		{anonymous, Event{Type: eventCommentAdded}, true},
+	} {
+		if result := test.s.wants(test.e); result != test.expected {
+			t.Errorf("subscriber %q wants(%s for %q) = %v; expected %v",
+				test.s.user, test.e.Type, test.e.User, result, test.expected)
+		}
+	}
+}
+
+func TestVideoEventsLinkToTheClientsVersion(t *testing.T) {
+	saved := events
+	events = newTestBroker()
+	defer func() { events = saved }()
+
+	publishVideoData(eventVideoUpdated, Video{ID: 3})
+
+	router := newRouter()
+	for _, base := range apiBases {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("GET", base+"/events", nil).WithContext(ctx)
+		req.Header.Set("Last-Event-ID", "100")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		expected := `"thumbnail_url":"` + base + `/videos/3/thumbnail"`
+		if body := rec.Body.String(); !strings.Contains(body, expected) {
+			t.Errorf("GET %s/events: expected %s, got %q", base, expected, body)
+		}
+	}
+}
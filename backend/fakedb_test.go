@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeQuery answers one query sent to the fake database with the result's
+// columns and rows. A statement run with Exec affects as many rows as its
+// answer has.
+type fakeQuery func(query string, args []driver.Value) (columns []string, rows [][]driver.Value, err error)
+
+// fakeDriver is a database/sql driver answering every query with the current
+// test's fakeQuery, so handlers can be exercised through the router without
+// Postgres
+type fakeDriver struct {
+	mu     sync.Mutex
+	answer fakeQuery
+}
+
+var fakeDB = &fakeDriver{}
+
+func init() {
+	sql.Register("fakedb", fakeDB)
+}
+
+// withFakeDB points db at the fake database for the rest of the test
+func withFakeDB(t *testing.T, answer fakeQuery) {
+	t.Helper()
+	fakeDB.mu.Lock()
+	fakeDB.answer = answer
+	fakeDB.mu.Unlock()
+
+	conn, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatalf("opening fake database: %v", err)
+	}
+	saved := db
+	db = conn
+	t.Cleanup(func() {
+		db = saved
+		conn.Close()
+	})
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{d}, nil
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.d, query}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (s fakeStmt) run(args []driver.Value) ([]string, [][]driver.Value, error) {
+	s.d.mu.Lock()
+	answer := s.d.answer
+	s.d.mu.Unlock()
+	if answer == nil {
+		return nil, nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	return answer(s.query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Grouping rule types
+const (
+	// ruleRegex groups files whose names match a pattern by the captured series name
+	ruleRegex = "regex"
+	// ruleDirectory turns every matching directory into one playlist
+	ruleDirectory = "directory"
+	// ruleParentFolder groups files by an ancestor folder across its subdirectories
+	ruleParentFolder = "parent_folder"
+
+	// ruleFilename is the built-in normalizePlaylistName grouping applied to
+	// files no configured rule claims
+	ruleFilename = "filename"
+
+	defaultMinGroupSize = 2
+
+	groupingConfigFile = "playlist_rules.json"
+)
+
+// GroupingRule configures one playlist grouping strategy. Rules are tried in
+// order and the first one that applies to a file decides its playlist.
+type GroupingRule struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+
+	// Pattern is the filename regex for regex rules. The series name is taken
+	// from the "series" named group, else the first group, else the whole match.
+	Pattern string `json:"pattern,omitempty"`
+
+	// CrossDirectory lets a regex rule group the same series across directories
+	CrossDirectory bool `json:"cross_directory,omitempty"`
+
+	// Levels is how many folders above the file's own directory a
+	// parent_folder rule groups by (default 1)
+	Levels int `json:"levels,omitempty"`
+
+	// Path optionally restricts the rule to directories matching this regex
+	Path string `json:"path,omitempty"`
+
+	// MinGroupSize overrides the global minimum for this rule
+	MinGroupSize int `json:"min_group_size,omitempty"`
+
+	pattern *regexp.Regexp
+	path    *regexp.Regexp
+}
+
+// GroupingConfig is the contents of CONFIG_DIR/playlist_rules.json
+type GroupingConfig struct {
+	MinGroupSize int            `json:"min_group_size"`
+	Rules        []GroupingRule `json:"rules"`
+}
+
+// groupingPreview is the response of POST /api/playlists/preview: the
+// playlists the rules would build, without saving them
+type groupingPreview struct {
+	Rules           GroupingConfig         `json:"rules"`
+	Playlists       []groupingPreviewEntry `json:"playlists"`
+	GroupedVideos   int                    `json:"grouped_videos"`
+	UngroupedVideos int                    `json:"ungrouped_videos"`
+}
+
+// groupingPreviewEntry is a previewed playlist and the rule that made it
+type groupingPreviewEntry struct {
+	Playlist
+	Rule string `json:"rule"`
+}
+
+// playlistGroup is a set of videos the grouping rules placed together
+type playlistGroup struct {
+	Key       string
+	Name      string
+	Directory string
+	Rule      string
+	MinSize   int
+	ByPath    bool // order by full path because the group spans directories
+	Videos    []playlistVideo
+}
+
+func defaultGroupingConfig() GroupingConfig {
+	return GroupingConfig{MinGroupSize: defaultMinGroupSize}
+}
+
+// loadGroupingConfig reads the grouping rules from the config directory,
+// falling back to filename grouping alone when the file does not exist
+func loadGroupingConfig() (GroupingConfig, error) {
+	path := filepath.Join(config.ConfigDir, groupingConfigFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return defaultGroupingConfig(), nil
+	} else if err != nil {
+		return GroupingConfig{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var cfg GroupingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return GroupingConfig{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.compile(); err != nil {
+		return GroupingConfig{}, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// compile validates the rules and prepares their regular expressions
+func (cfg *GroupingConfig) compile() error {
+	if cfg.MinGroupSize == 0 {
+		cfg.MinGroupSize = defaultMinGroupSize
+	}
+	if cfg.MinGroupSize < 1 {
+		return fmt.Errorf("min_group_size must be at least 1")
+	}
+
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s #%d", rule.Type, i+1)
+		}
+		if rule.MinGroupSize < 0 {
+			return fmt.Errorf("rule %q: min_group_size must not be negative", rule.Name)
+		}
+
+		switch rule.Type {
+		case ruleRegex:
+			if rule.Pattern == "" {
+				return fmt.Errorf("rule %q: pattern is required", rule.Name)
+			}
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rule.pattern = pattern
+		case ruleDirectory:
+		case ruleParentFolder:
+			if rule.Levels == 0 {
+				rule.Levels = 1
+			}
+			if rule.Levels < 0 {
+				return fmt.Errorf("rule %q: levels must be positive", rule.Name)
+			}
+		default:
+			return fmt.Errorf("rule %q: unknown type %q", rule.Name, rule.Type)
+		}
+
+		if rule.Path != "" {
+			path, err := regexp.Compile(rule.Path)
+			if err != nil {
+				return fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rule.path = path
+		}
+	}
+	return nil
+}
+
+// seriesName extracts the series from a filename matched by a regex rule
+func (rule *GroupingRule) seriesName(filename string) (string, bool) {
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	m := rule.pattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+
+	series := m[0]
+	if idx := rule.pattern.SubexpIndex("series"); idx > 0 {
+		series = m[idx]
+	} else if len(m) > 1 {
+		series = m[1]
+	}
+	series = strings.TrimSpace(series)
+	return series, series != ""
+}
+
+// groupKeyName normalizes a series name so that case and separators don't split groups
+func groupKeyName(series string) string {
+	series = strings.ToLower(series)
+	series = strings.NewReplacer("_", " ", "-", " ", ".", " ").Replace(series)
+	return strings.Join(strings.Fields(series), " ")
+}
+
+// displayName capitalizes the first letter the way auto-generated names always have
+func displayName(name string) string {
+	if len(name) == 0 {
+		return name
+	}
+	return strings.ToUpper(string(name[0])) + name[1:]
+}
+
+// ancestorDir walks levels folders up from dir without leaving the video directory
+func ancestorDir(dir string, levels int) string {
+	root := filepath.Clean(config.VideoDir)
+	for i := 0; i < levels && dir != root; i++ {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return dir
+}
+
+// assignGroup applies the first matching rule to a video, falling back to
+// filename grouping within the video's directory
+func assignGroup(v playlistVideo, cfg GroupingConfig) playlistGroup {
+	dir := filepath.Dir(v.Filepath)
+
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.path != nil && !rule.path.MatchString(dir) {
+			continue
+		}
+
+		minSize := cfg.MinGroupSize
+		if rule.MinGroupSize > 0 {
+			minSize = rule.MinGroupSize
+		}
+
+		switch rule.Type {
+		case ruleRegex:
+			series, ok := rule.seriesName(v.Filename)
+			if !ok {
+				continue
+			}
+			key := groupKeyName(series)
+			group := playlistGroup{Name: displayName(strings.ReplaceAll(series, "_", " ")), Directory: dir, Rule: rule.Name, MinSize: minSize}
+			if rule.CrossDirectory {
+				group.Key = rule.Name + "||" + key
+				group.Directory = ""
+				group.ByPath = true
+			} else {
+				group.Key = dir + "||" + key
+			}
+			return group
+		case ruleDirectory:
+			return playlistGroup{Key: dir + "||", Name: displayName(filepath.Base(dir)), Directory: dir, Rule: rule.Name, MinSize: minSize}
+		case ruleParentFolder:
+			parent := ancestorDir(dir, rule.Levels)
+			return playlistGroup{Key: parent + "||*", Name: displayName(filepath.Base(parent)), Directory: parent, Rule: rule.Name, MinSize: minSize, ByPath: true}
+		}
+	}
+
+	normalized := normalizePlaylistName(v.Filename)
+	return playlistGroup{Key: dir + "||" + normalized, Name: displayName(normalized), Directory: dir, Rule: ruleFilename, MinSize: cfg.MinGroupSize}
+}
+
+// groupVideos places every video in a group and returns the groups large
+// enough to become playlists, sorted by name
+func groupVideos(videos []playlistVideo, cfg GroupingConfig) []playlistGroup {
+	groups := make(map[string]*playlistGroup)
+	var keys []string
+	for _, v := range videos {
+		assigned := assignGroup(v, cfg)
+		group, ok := groups[assigned.Key]
+		if !ok {
+			group = &assigned
+			groups[assigned.Key] = group
+			keys = append(keys, assigned.Key)
+		}
+		group.Videos = append(group.Videos, v)
+	}
+
+	var result []playlistGroup
+	for _, key := range keys {
+		group := groups[key]
+		if len(group.Videos) < group.MinSize {
+			continue
+		}
+
+		// Sort videos by episode marker, then naturally by filename
+		sort.SliceStable(group.Videos, func(i, j int) bool {
+			if group.ByPath {
+				return playlistOrderLess(group.Videos[i].Filepath, group.Videos[j].Filepath)
+			}
+			return playlistOrderLess(group.Videos[i].Filename, group.Videos[j].Filename)
+		})
+		result = append(result, *group)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// playlistID derives the deterministic "pl_" ID from a group key
+func playlistID(key string) string {
+	hash := md5.Sum([]byte(key))
+	return "pl_" + hex.EncodeToString(hash[:])[:12]
+}
+
+// playlist converts the group to its API representation
+func (g playlistGroup) playlist() Playlist {
+	videoIDs := make([]int, len(g.Videos))
+	totalDuration := 0
+	for i, v := range g.Videos {
+		videoIDs[i] = v.ID
+		totalDuration += v.Duration
+	}
+
+	return Playlist{
+		ID:            playlistID(g.Key),
+		Name:          g.Name,
+		VideoIDs:      videoIDs,
+		VideoCount:    len(videoIDs),
+		ThumbnailID:   videoIDs[0],
+		Directory:     g.Directory,
+		Source:        playlistSourceAuto,
+		TotalDuration: totalDuration,
+	}
+}
+
+// previewPlaylists shows how the library would be grouped, using the rules
+// in the request body or the saved rules when the body is empty. Nothing is
+// written to the database.
+func previewPlaylists(w http.ResponseWriter, r *http.Request) {
+	var cfg GroupingConfig
+	err := json.NewDecoder(r.Body).Decode(&cfg)
+	if errors.Is(err, io.EOF) {
+		cfg, err = loadGroupingConfig()
+		if err != nil {
+			logger.Printf("Error loading grouping rules: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to load grouping rules")
+			return
+		}
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	} else if err := cfg.compile(); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+
+	videos, err := queryPlaylistVideos()
+	if err != nil {
+		logger.Printf("Error querying videos for preview: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch videos")
+		return
+	}
+
+	entries := []groupingPreviewEntry{}
+	grouped := 0
+	for _, group := range groupVideos(videos, cfg) {
+		entries = append(entries, groupingPreviewEntry{Playlist: group.playlist(), Rule: group.Rule})
+		grouped += len(group.Videos)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groupingPreview{
+		Rules:           cfg,
+		Playlists:       entries,
+		GroupedVideos:   grouped,
+		UngroupedVideos: len(videos) - grouped,
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func compileRules(t *testing.T, cfg GroupingConfig) GroupingConfig {
+	t.Helper()
+	if err := cfg.compile(); err != nil {
+		t.Fatalf("compile() failed: %v", err)
+	}
+	return cfg
+}
+
+func groupNames(groups []playlistGroup) map[string][]int {
+	names := make(map[string][]int)
+	for _, g := range groups {
+		for _, v := range g.Videos {
+			names[g.Name] = append(names[g.Name], v.ID)
+		}
+	}
+	return names
+}
+
+func TestGroupVideosRegexRule(t *testing.T) {
+	cfg := compileRules(t, GroupingConfig{Rules: []GroupingRule{
+		{Name: "lectures", Type: ruleRegex, Pattern: `^(?P<series>.+?)\s*\d+\s*-`},
+	}})
+
+	videos := []playlistVideo{
+		{ID: 1, Filename: "Lecture 10 - Wrap up.mp4", Filepath: "/videos/cs101/Lecture 10 - Wrap up.mp4"},
+		{ID: 2, Filename: "Lecture 02 - Loops.mp4", Filepath: "/videos/cs101/Lecture 02 - Loops.mp4"},
+		{ID: 3, Filename: "Lecture 01 - Intro.mp4", Filepath: "/videos/cs101/Lecture 01 - Intro.mp4"},
+		{ID: 4, Filename: "notes.mp4", Filepath: "/videos/cs101/notes.mp4"},
+	}
+
+	groups := groupVideos(videos, cfg)
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(groups))
+	}
+	g := groups[0]
+	if g.Name != "Lecture" || g.Rule != "lectures" {
+		t.Errorf("Unexpected group %q from rule %q", g.Name, g.Rule)
+	}
+	ids := []int{g.Videos[0].ID, g.Videos[1].ID, g.Videos[2].ID}
+	if ids[0] != 3 || ids[1] != 2 || ids[2] != 1 {
+		t.Errorf("Expected natural order [3 2 1], got %v", ids)
+	}
+}
+
+func TestGroupVideosDirectoryRules(t *testing.T) {
+	saved := config.VideoDir
+	config.VideoDir = "/videos"
+	defer func() { config.VideoDir = saved }()
+
+	cfg := compileRules(t, GroupingConfig{Rules: []GroupingRule{
+		{Type: ruleParentFolder, Path: `^/videos/courses/`},
+		{Type: ruleDirectory, Path: `^/videos/talks$`},
+	}})
+
+	videos := []playlistVideo{
+		{ID: 1, Filename: "b.mp4", Filepath: "/videos/courses/go/week2/b.mp4"},
+		{ID: 2, Filename: "a.mp4", Filepath: "/videos/courses/go/week10/a.mp4"},
+		{ID: 3, Filename: "z.mp4", Filepath: "/videos/courses/go/week1/z.mp4"},
+		{ID: 4, Filename: "keynote.mp4", Filepath: "/videos/talks/keynote.mp4"},
+		{ID: 5, Filename: "panel.mp4", Filepath: "/videos/talks/panel.mp4"},
+	}
+
+	names := groupNames(groupVideos(videos, cfg))
+	if ids := names["Go"]; len(ids) != 3 || ids[0] != 3 || ids[1] != 1 || ids[2] != 2 {
+		t.Errorf("Expected parent folder group Go = [3 1 2], got %v", ids)
+	}
+	if ids := names["Talks"]; len(ids) != 2 {
+		t.Errorf("Expected directory group Talks with 2 videos, got %v", ids)
+	}
+}
+
+func TestGroupVideosMinGroupSize(t *testing.T) {
+	videos := []playlistVideo{
+		{ID: 1, Filename: "clip_1.mp4", Filepath: "/videos/clip_1.mp4"},
+		{ID: 2, Filename: "clip_2.mp4", Filepath: "/videos/clip_2.mp4"},
+	}
+
+	if groups := groupVideos(videos, compileRules(t, GroupingConfig{MinGroupSize: 3})); len(groups) != 0 {
+		t.Errorf("Expected no groups below minimum size, got %d", len(groups))
+	}
+	if groups := groupVideos(videos, compileRules(t, GroupingConfig{})); len(groups) != 1 {
+		t.Errorf("Expected default minimum of 2 to form a group, got %d", len(groups))
+	}
+}
+
+func TestGroupingKeepsFilenamePlaylistIDs(t *testing.T) {
+	videos := []playlistVideo{
+		{ID: 1, Filename: "episode_1.mp4", Filepath: "/videos/show/episode_1.mp4"},
+		{ID: 2, Filename: "episode_2.mp4", Filepath: "/videos/show/episode_2.mp4"},
+	}
+
+	playlists := groupPlaylists(videos, defaultGroupingConfig())
+	if len(playlists) != 1 || playlists[0].ID != playlistID("/videos/show||episode") {
+		t.Errorf("Expected filename grouping to keep its ID scheme, got %+v", playlists)
+	}
+}
+
+func TestGroupingConfigValidation(t *testing.T) {
+	invalid := []GroupingConfig{
+		{Rules: []GroupingRule{{Type: "unknown"}}},
+		{Rules: []GroupingRule{{Type: ruleRegex}}},
+		{Rules: []GroupingRule{{Type: ruleRegex, Pattern: "("}}},
+		{Rules: []GroupingRule{{Type: ruleDirectory, Path: "["}}},
+		{MinGroupSize: -1},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.compile(); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestLoadGroupingConfig(t *testing.T) {
+	saved := config.ConfigDir
+	config.ConfigDir = t.TempDir()
+	defer func() { config.ConfigDir = saved }()
+
+	cfg, err := loadGroupingConfig()
+	if err != nil || cfg.MinGroupSize != defaultMinGroupSize || len(cfg.Rules) != 0 {
+		t.Fatalf("Expected default config without a rules file, got %+v, %v", cfg, err)
+	}
+
+	rules := `{"min_group_size": 3, "rules": [{"type": "directory"}]}`
+	if err := os.WriteFile(filepath.Join(config.ConfigDir, groupingConfigFile), []byte(rules), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+	cfg, err = loadGroupingConfig()
+	if err != nil || cfg.MinGroupSize != 3 || len(cfg.Rules) != 1 || cfg.Rules[0].Name != "directory #1" {
+		t.Errorf("Unexpected config %+v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	jobStatusPending   = "pending"
+	jobStatusRunning   = "running"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+
+	// Jobs someone is waiting on run before work queued by a scan
+	jobPriorityScan    = 0
+	jobPriorityRequest = 10
+
+	// jobPollInterval is how often idle workers look for jobs whose retry
+	// delay has passed
+	jobPollInterval = 5 * time.Second
+
+	// Failed attempts are retried after 30s, 1m, 2m, ... up to an hour
+	jobBaseBackoff = 30 * time.Second
+	jobMaxBackoff  = time.Hour
+
+	// jobFailureCooldown is how long work that used up its attempts is left
+	// alone before it may be queued again
+	jobFailureCooldown = time.Hour
+
+	// jobRetention is how long finished jobs are kept for GET /api/admin/jobs
+	jobRetention = 7 * 24 * time.Hour
+
+	defaultJobListLimit = 100
+	maxJobListLimit     = 500
+)
+
+// Job is a unit of background work stored in the jobs table
+type Job struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	VideoID     *int            `json:"video_id,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Priority    int             `json:"priority"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	RunAfter    time.Time       `json:"run_after"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// jobList is the response of GET /api/admin/jobs: recent jobs and how many
+// there are in each status
+type jobList struct {
+	Jobs   []Job          `json:"jobs"`
+	Counts map[string]int `json:"counts"`
+}
+
+// jobHandler describes how jobs of one type run. concurrency is the default
+// number of workers, which JOB_CONCURRENCY can override.
+type jobHandler struct {
+	concurrency int
+	run         func(ctx context.Context, job Job) error
+}
+
+var jobHandlers = map[string]jobHandler{
+	"probe":     {concurrency: 2, run: runProbeJob},
+	"trickplay": {concurrency: 1, run: runTrickplayJob},
+	"preview":   {concurrency: 1, run: runPreviewJob},
+	"subtitle":  {concurrency: 1, run: runSubtitleJob},
+}
+
+// jobQueue runs the workers and tracks running jobs so they can be cancelled
+type jobQueue struct {
+	mu      sync.Mutex
+	running map[int]context.CancelFunc
+	wake    map[string]chan struct{}
+}
+
+var backgroundJobs = &jobQueue{
+	running: make(map[int]context.CancelFunc),
+	wake:    make(map[string]chan struct{}),
+}
+
+// jobColumns lists the columns read by scanJob, in order
+const jobColumns = `id, type, video_id, payload, status, priority, attempts, max_attempts,
+		COALESCE(last_error, ''), run_after, created_at, started_at, finished_at`
+
+// scanJob reads a row selected with jobColumns
+func scanJob(row interface{ Scan(...interface{}) error }) (Job, error) {
+	var job Job
+	var videoID sql.NullInt64
+	var payload []byte
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Type, &videoID, &payload, &job.Status, &job.Priority, &job.Attempts, &job.MaxAttempts,
+		&job.LastError, &job.RunAfter, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+	if videoID.Valid {
+		id := int(videoID.Int64)
+		job.VideoID = &id
+	}
+	job.Payload = json.RawMessage(payload)
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+// jobKey identifies a piece of work so it is only queued once at a time
+func jobKey(jobType string, videoID int, payload []byte) string {
+	return fmt.Sprintf("%s:%d:%s", jobType, videoID, payload)
+}
+
+// jobBackoff is the delay before retrying a job that has failed attempts times
+func jobBackoff(attempts int) time.Duration {
+	delay := jobBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	return delay
+}
+
+// parseJobConcurrency reads JOB_CONCURRENCY, e.g. "probe=2,trickplay=1".
+// Unknown types and malformed entries are skipped; 0 pauses a job type.
+func parseJobConcurrency(value string) map[string]int {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		name, count, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if _, known := jobHandlers[name]; !known || err != nil || n < 0 {
+			continue
+		}
+		limits[name] = n
+	}
+	return limits
+}
+
+// enqueueJob queues work unless the same work is already pending or running,
+// in which case its priority is raised to at least the given one. A running
+// job may have started before whatever prompted this request, such as a
+// changed file, so it is flagged to run again once it finishes. It reports
+// false when the same work used up its attempts within the failure cooldown.
+func enqueueJob(jobType string, videoID int, payload interface{}, priority int) (bool, error) {
+	data := []byte("{}")
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return false, err
+		}
+	}
+	key := jobKey(jobType, videoID, data)
+
+	var recentlyFailed bool
+	err := db.QueryRow(`
+		SELECT COALESCE((
+			SELECT status = 'failed' AND finished_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'
+			FROM jobs
+			WHERE job_key = $1 AND finished_at IS NOT NULL
+			ORDER BY finished_at DESC
+			LIMIT 1
+		), false)
+	`, key, jobFailureCooldown.Seconds()).Scan(&recentlyFailed)
+	if err != nil {
+		return false, err
+	}
+	if recentlyFailed {
+		return false, nil
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO jobs (type, job_key, video_id, payload, priority)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (job_key) WHERE status IN ('pending', 'running')
+		DO UPDATE SET priority = GREATEST(jobs.priority, EXCLUDED.priority),
+			rerun = jobs.rerun OR jobs.status = 'running'
+	`, jobType, key, videoID, data, priority)
+	if err != nil {
+		return false, err
+	}
+
+	backgroundJobs.notify(jobType)
+	return true, nil
+}
+
+// notify wakes an idle worker for the job type
+func (q *jobQueue) notify(jobType string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.wake[jobType] <- struct{}{}:
+	default:
+	}
+}
+
+// start requeues jobs interrupted by a restart and runs the workers until
+// the process exits
+func (q *jobQueue) start() {
+	if _, err := db.Exec("UPDATE jobs SET status = 'pending', rerun = false WHERE status = 'running'"); err != nil {
+		logger.Printf("Error requeueing interrupted jobs: %v", err)
+	}
+
+	for jobType, handler := range jobHandlers {
+		workers := handler.concurrency
+		if n, ok := config.JobConcurrency[jobType]; ok {
+			workers = n
+		}
+		if workers == 0 {
+			logger.Printf("Job type %s is paused (JOB_CONCURRENCY)", jobType)
+			continue
+		}
+
+		wake := make(chan struct{}, 1)
+		q.mu.Lock()
+		q.wake[jobType] = wake
+		q.mu.Unlock()
+		for i := 0; i < workers; i++ {
+			go q.work(jobType, handler, wake)
+		}
+	}
+
+	go func() {
+		for {
+			pruneJobs()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// pruneJobs drops finished jobs past the retention period
+func pruneJobs() {
+	result, err := db.Exec(`
+		DELETE FROM jobs
+		WHERE status IN ('completed', 'failed', 'cancelled') AND finished_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+	`, jobRetention.Seconds())
+	if err != nil {
+		logger.Printf("Error pruning finished jobs: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Printf("Pruned %d finished jobs", n)
+	}
+}
+
+// work claims and runs jobs of one type, polling for retries while idle
+func (q *jobQueue) work(jobType string, handler jobHandler, wake chan struct{}) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := scanJob(db.QueryRow(`
+			UPDATE jobs
+			SET status = 'running', attempts = attempts + 1, started_at = CURRENT_TIMESTAMP
+			WHERE id = (
+				SELECT id FROM jobs
+				WHERE type = $1 AND status = 'pending' AND run_after <= CURRENT_TIMESTAMP
+				ORDER BY priority DESC, id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+jobColumns, jobType))
+		if err == nil {
+			q.run(job, handler)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			logger.Printf("Error claiming %s job: %v", jobType, err)
+		}
+
+		select {
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// finishJob is the SET clause recording that a job ended with status, unless
+// it was flagged to run again (see enqueueJob) and goes back to pending with
+// fresh attempts instead
+func finishJob(status string) string {
+	return `status = CASE WHEN rerun THEN 'pending' ELSE '` + status + `' END,
+		attempts = CASE WHEN rerun THEN 0 ELSE attempts END,
+		finished_at = CASE WHEN rerun THEN NULL ELSE CURRENT_TIMESTAMP END,
+		rerun = false`
+}
+
+// run executes a claimed job and records the outcome. Updates only apply
+// while the job is still running, so a cancellation isn't overwritten.
+func (q *jobQueue) run(job Job, handler jobHandler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+
+	started := time.Now()
+	err := handler.run(ctx, job)
+	cancelled := ctx.Err() != nil
+
+	q.mu.Lock()
+	delete(q.running, job.ID)
+	q.mu.Unlock()
+	cancel()
+
+	switch {
+	case cancelled:
+		logger.Printf("Stopped cancelled %s job %d", job.Type, job.ID)
+		return
+	case err == nil:
+		_, err = db.Exec(`
+			UPDATE jobs SET `+finishJob("completed")+`, last_error = NULL
+			WHERE id = $1 AND status = 'running'
+		`, job.ID)
+		logger.Printf("Completed %s job %d in %s", job.Type, job.ID, time.Since(started).Round(time.Millisecond))
+	case job.Attempts < job.MaxAttempts:
+		delay := jobBackoff(job.Attempts)
+		logger.Printf("Error running %s job %d (attempt %d of %d, retrying in %s): %v",
+			job.Type, job.ID, job.Attempts, job.MaxAttempts, delay, err)
+		_, err = db.Exec(`
+			UPDATE jobs SET status = 'pending', rerun = false, last_error = $2, run_after = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+			WHERE id = $1 AND status = 'running'
+		`, job.ID, err.Error(), delay.Seconds())
+	default:
+		logger.Printf("Error running %s job %d, giving up after %d attempts: %v", job.Type, job.ID, job.Attempts, err)
+		_, err = db.Exec(`
+			UPDATE jobs SET `+finishJob("failed")+`, last_error = $2
+			WHERE id = $1 AND status = 'running'
+		`, job.ID, err.Error())
+	}
+	if err != nil {
+		logger.Printf("Error recording outcome of job %d: %v", job.ID, err)
+	}
+}
+
+// cancelRunning stops a job's worker if it is running in this process
+func (q *jobQueue) cancelRunning(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+}
+
+// jobVideoID returns the video a job is for
+func jobVideoID(job Job) (int, error) {
+	if job.VideoID == nil {
+		return 0, fmt.Errorf("%s job %d has no video", job.Type, job.ID)
+	}
+	return *job.VideoID, nil
+}
+
+// getJobs lists recent jobs, newest first, with counts by status. Filter with
+// ?status= and ?type=; ?limit= defaults to 100.
+func getJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultJobListLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid limit")
+			return
+		}
+		if n > maxJobListLimit {
+			n = maxJobListLimit
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR type = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`, query.Get("status"), query.Get("type"), limit)
+	if err != nil {
+		logger.Printf("Error fetching jobs: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch jobs")
+		return
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			logger.Printf("Error scanning job: %v", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	counts := map[string]int{
+		jobStatusPending:   0,
+		jobStatusRunning:   0,
+		jobStatusCompleted: 0,
+		jobStatusFailed:    0,
+		jobStatusCancelled: 0,
+	}
+	countRows, err := db.Query("SELECT status, COUNT(*) FROM jobs WHERE ($1 = '' OR type = $1) GROUP BY status", query.Get("type"))
+	if err != nil {
+		logger.Printf("Error counting jobs: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch jobs")
+		return
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var status string
+		var count int
+		if err := countRows.Scan(&status, &count); err != nil {
+			logger.Printf("Error scanning job count: %v", err)
+			continue
+		}
+		counts[status] = count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobList{Jobs: jobs, Counts: counts})
+}
+
+// cancelJob cancels a pending or running job
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid job ID")
+		return
+	}
+
+	job, err := scanJob(db.QueryRow(`
+		UPDATE jobs SET status = 'cancelled', finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('pending', 'running')
+		RETURNING `+jobColumns, id))
+	if err == sql.ErrNoRows {
+		var status string
+		err = db.QueryRow("SELECT status FROM jobs WHERE id = $1", id).Scan(&status)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, errorCodeNotFound, "Job not found")
+		} else if err != nil {
+			logger.Printf("Error fetching job: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to cancel job")
+		} else {
+			writeError(w, http.StatusConflict, errorCodeConflict, fmt.Sprintf("Job already %s", status))
+		}
+		return
+	} else if err != nil {
+		logger.Printf("Error cancelling job: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to cancel job")
+		return
+	}
+
+	backgroundJobs.cancelRunning(id)
+	logger.Printf("Cancelled %s job %d", job.Type, job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, test := range tests {
+		if delay := jobBackoff(test.attempts); delay != test.expected {
+			t.Errorf("jobBackoff(%d) = %v; expected %v", test.attempts, delay, test.expected)
+		}
+	}
+}
+
+func TestParseJobConcurrency(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected map[string]int
+	}{
+		{"", map[string]int{}},
+		{"probe=4", map[string]int{"probe": 4}},
+		{" trickplay = 0 , preview=2", map[string]int{"trickplay": 0, "preview": 2}},
+		{"transcode=2,probe=-1,preview=x,trickplay", map[string]int{}},
+	}
+
+	for _, test := range tests {
+		limits := parseJobConcurrency(test.value)
+		if !reflect.DeepEqual(limits, test.expected) {
+			t.Errorf("parseJobConcurrency(%q) = %v; expected %v", test.value, limits, test.expected)
+		}
+	}
+}
+
+func TestJobKey(t *testing.T) {
+	webp := jobKey("preview", 7, []byte(`{"format":"webp"}`))
+	mp4 := jobKey("preview", 7, []byte(`{"format":"mp4"}`))
+	if webp == mp4 {
+		t.Errorf("Expected payload to distinguish job keys, got %q for both", webp)
+	}
+	if jobKey("trickplay", 7, []byte("{}")) == jobKey("trickplay", 8, []byte("{}")) {
+		t.Error("Expected video ID to distinguish job keys")
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"crypto/md5"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -17,42 +15,117 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/rs/cors"
 )
 
 // Video represents a video file
 type Video struct {
-	ID           int       `json:"id"`
-	Filename     string    `json:"filename"`
-	Filepath     string    `json:"filepath"`
-	Title        string    `json:"title"`
-	Views        int       `json:"views"`
-	Likes        int       `json:"likes"`
-	Duration     int       `json:"duration"`
-	FileSize     int64     `json:"file_size"`
-	CreatedAt    time.Time `json:"created_at"`
-	ModifiedAt   time.Time `json:"modified_at"`
-	ThumbnailURL string    `json:"thumbnail_url"`
+	ID           int            `json:"id"`
+	Filename     string         `json:"filename"`
+	Filepath     string         `json:"filepath"`
+	Title        string         `json:"title"`
+	Views        int            `json:"views"`
+	Likes        int            `json:"likes"`
+	Reactions    map[string]int `json:"reactions"`
+	Duration     int            `json:"duration"`
+	FileSize     int64          `json:"file_size"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ModifiedAt   time.Time      `json:"modified_at"`
+	ThumbnailURL string         `json:"thumbnail_url"`
+	PreviewURL   string         `json:"preview_url"`
+	ShowID       *int           `json:"show_id,omitempty"`
+	Season       *int           `json:"season,omitempty"`
+	Episode      *int           `json:"episode,omitempty"`
+	Description  string         `json:"description"`
+	Tags         []string       `json:"tags"`
+	Cast         []string       `json:"cast"`
+	ReleaseDate  *string        `json:"release_date,omitempty"`
+	SortOrder    *int           `json:"sort_order,omitempty"`
+	Overridden   []string       `json:"overridden_fields"`
 }
 
-// Comment represents a comment on a video
-type Comment struct {
-	ID        int       `json:"id"`
-	VideoID   int       `json:"video_id"`
-	Author    string    `json:"author"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+// videoColumns lists the columns read by scanVideo, in order. Manual
+// overrides take precedence over scanned and sidecar values; tags are read
+// from the tag tables, where admin edits show up as admin or suppressed rows.
+var videoColumns = `v.id, v.filename, v.filepath, COALESCE(o.title, v.title), v.views, v.likes, v.duration, v.file_size,
+		v.created_at, v.modified_at, v.show_id, v.season_number, v.episode_number,
+		COALESCE(o.description, v.description),
+		COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM effective_video_tags e JOIN tags t ON t.id = e.tag_id
+			WHERE e.video_id = v.id), '{}'),
+		v.cast_members, v.release_date,
+		o.sort_order, o.title IS NOT NULL, o.description IS NOT NULL,
+		EXISTS(SELECT 1 FROM video_tags vt WHERE vt.video_id = v.id AND vt.source IN ('admin', 'suppressed')),
+		` + videoReactions.countsColumn("v.id")
+
+// videoTables is the FROM clause matching videoColumns
+const videoTables = `videos v LEFT JOIN video_overrides o ON o.video_id = v.id`
+
+// scanVideo reads a row selected with videoColumns
+func scanVideo(row interface{ Scan(...interface{}) error }) (Video, error) {
+	var v Video
+	var tags, cast pq.StringArray
+	var releaseDate sql.NullTime
+	var titleOverridden, descriptionOverridden, tagsOverridden bool
+	var reactions []byte
+	err := row.Scan(&v.ID, &v.Filename, &v.Filepath, &v.Title, &v.Views, &v.Likes, &v.Duration, &v.FileSize,
+		&v.CreatedAt, &v.ModifiedAt, &v.ShowID, &v.Season, &v.Episode,
+		&v.Description, &tags, &cast, &releaseDate,
+		&v.SortOrder, &titleOverridden, &descriptionOverridden, &tagsOverridden, &reactions)
+	if err != nil {
+		return v, err
+	}
+	v.Reactions = decodeReactions(reactions)
+	v.Overridden = []string{}
+	for field, overridden := range map[string]bool{"title": titleOverridden, "description": descriptionOverridden, "tags": tagsOverridden} {
+		if overridden {
+			v.Overridden = append(v.Overridden, field)
+		}
+	}
+	sort.Strings(v.Overridden)
+	v.Tags = []string(tags)
+	v.Cast = []string(cast)
+	if v.Tags == nil {
+		v.Tags = []string{}
+	}
+	if v.Cast == nil {
+		v.Cast = []string{}
+	}
+	if releaseDate.Valid {
+		date := releaseDate.Time.Format("2006-01-02")
+		v.ReleaseDate = &date
+	}
+	v.setLinks("/api")
+	return v, nil
+}
+
+// setLinks points the video's media URLs at the API under base, see apiBase
+func (v *Video) setLinks(base string) {
+	v.ThumbnailURL = fmt.Sprintf("%s/videos/%d/thumbnail", base, v.ID)
+	v.PreviewURL = fmt.Sprintf("%s/videos/%d/preview", base, v.ID)
+}
+
+// statusResponse acknowledges a request that has nothing else to return
+type statusResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
 }
 
 // Playlist represents a group of related videos
 type Playlist struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	VideoIDs    []int   `json:"video_ids"`
-	VideoCount  int     `json:"video_count"`
-	ThumbnailID int     `json:"thumbnail_id"`
-	Directory   string  `json:"directory"`
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	VideoIDs      []int     `json:"video_ids"`
+	VideoCount    int       `json:"video_count"`
+	ThumbnailID   int       `json:"thumbnail_id"`
+	Directory     string    `json:"directory"`
+	Source        string    `json:"source"`
+	Owner         string    `json:"owner,omitempty"`
+	Visibility    string    `json:"visibility,omitempty"`
+	TotalDuration int       `json:"total_duration"`
+	OrderPinned   bool      `json:"order_pinned"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // Config holds application configuration
@@ -61,6 +134,28 @@ type Config struct {
 	VideoDir    string
 	ConfigDir   string
 	Port        string
+	AdminToken  string
+	AutoTags    bool
+	FFprobePath string
+	FFmpegPath  string
+
+	TrickplayInterval    int
+	TrickplayPregenerate bool
+
+	PreviewThreads int
+	PreviewTimeout int
+
+	JobConcurrency map[string]int
+
+	CommentModeration bool
+	BannedWords       []string
+	CommentLinks      string
+
+	Reactions []string
+
+	RateLimits      map[string]rateLimit
+	RefreshCooldown int
+	TrustProxy      bool
 }
 
 var (
@@ -85,7 +180,31 @@ func main() {
 		VideoDir:    getEnv("VIDEO_DIR", "./videos"),
 		ConfigDir:   getEnv("CONFIG_DIR", "./config"),
 		Port:        getEnv("PORT", "8082"),
+		AdminToken:  os.Getenv("ADMIN_TOKEN"),
+		AutoTags:    getEnv("AUTO_TAGS", "true") != "false",
+		FFprobePath: getEnv("FFPROBE_PATH", "ffprobe"),
+		FFmpegPath:  getEnv("FFMPEG_PATH", "ffmpeg"),
+
+		TrickplayInterval:    getEnvInt("TRICKPLAY_INTERVAL", 10),
+		TrickplayPregenerate: getEnv("TRICKPLAY_PREGENERATE", "false") == "true",
+
+		PreviewThreads: getEnvInt("PREVIEW_THREADS", 1),
+		PreviewTimeout: getEnvInt("PREVIEW_TIMEOUT", 120),
+
+		JobConcurrency: parseJobConcurrency(os.Getenv("JOB_CONCURRENCY")),
+
+		CommentModeration: getEnv("COMMENT_MODERATION", "false") == "true",
+		BannedWords:       strings.Split(os.Getenv("BANNED_WORDS"), ","),
+		CommentLinks:      getEnv("COMMENT_LINKS", "allow"),
+
+		Reactions: parseReactions(os.Getenv("REACTIONS")),
+
+		RateLimits:      parseRateLimits(os.Getenv("RATE_LIMITS")),
+		RefreshCooldown: getEnvInt("REFRESH_COOLDOWN", 60),
+		TrustProxy:      getEnv("TRUST_PROXY", "false") == "true",
 	}
+	commentRules = newCommentPolicy(config.CommentModeration, config.BannedWords, config.CommentLinks)
+	refreshCooldown.period = time.Duration(config.RefreshCooldown) * time.Second
 
 	// Setup logging
 	setupLogging()
@@ -104,27 +223,20 @@ func main() {
 	}
 	logger.Println("Connected to database successfully")
 
+	// Create or upgrade the schema
+	if err := applySchema(); err != nil {
+		logger.Fatalf("Failed to apply database schema: %v", err)
+	}
+
+	// Start background job workers
+	backgroundJobs.start()
+
 	// Scan video directory
 	if err := scanVideoDirectory(); err != nil {
 		logger.Printf("Warning: Failed to scan video directory: %v", err)
 	}
 
-	// Setup router
-	router := mux.NewRouter()
-
-	// API routes
-	api := router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/videos", getVideos).Methods("GET")
-	api.HandleFunc("/videos/refresh", refreshVideos).Methods("POST")
-	api.HandleFunc("/videos/{id}", getVideo).Methods("GET")
-	api.HandleFunc("/videos/{id}/stream", streamVideo).Methods("GET")
-	api.HandleFunc("/videos/{id}/thumbnail", getThumbnail).Methods("GET")
-	api.HandleFunc("/videos/{id}/view", incrementView).Methods("POST")
-	api.HandleFunc("/videos/{id}/like", toggleLike).Methods("POST")
-	api.HandleFunc("/videos/{id}/comments", getComments).Methods("GET")
-	api.HandleFunc("/videos/{id}/comments", addComment).Methods("POST")
-	api.HandleFunc("/playlists", getPlaylists).Methods("GET")
-	api.HandleFunc("/playlists/{id}", getPlaylist).Methods("GET")
+	router := newRouter()
 
 	// Setup CORS
 	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
@@ -132,21 +244,30 @@ func main() {
 		allowedOrigins = "*"
 	}
 
+	// Clients read these response headers to retry and to report errors
+	exposedHeaders := []string{"Retry-After", requestIDHeader, "Deprecation", "Link"}
+
 	var c *cors.Cors
 	if allowedOrigins == "*" {
-		// Use AllowAll for wildcard
-		c = cors.AllowAll()
+		// Allow every origin, like cors.AllowAll
+		c = cors.New(cors.Options{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"*"},
+			ExposedHeaders: exposedHeaders,
+		})
 	} else {
 		// Use specific origins
 		c = cors.New(cors.Options{
 			AllowedOrigins:   strings.Split(allowedOrigins, ","),
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 			AllowedHeaders:   []string{"*"},
+			ExposedHeaders:   exposedHeaders,
 			AllowCredentials: true,
 		})
 	}
 
-	handler := c.Handler(router)
+	handler := c.Handler(withRequestID(router))
 
 	// Start server
 	logger.Printf("Server starting on port %s", config.Port)
@@ -155,6 +276,83 @@ func main() {
 	}
 }
 
+// newRouter registers the API routes under /api/v1, /api/v2 and the
+// unversioned /api. Every route must be described in apiOperations for the
+// OpenAPI document.
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	// API routes
+	api := router.PathPrefix(apiPrefix).Subrouter()
+	api.Use(validateRouteParams, withAPIVersion)
+	api.HandleFunc("/openapi.json", serveOpenAPI(router)).Methods("GET")
+	api.HandleFunc("/events", getEvents).Methods("GET")
+	api.HandleFunc("/videos", getVideos).Methods("GET")
+	api.HandleFunc("/videos/refresh", rateLimited(rateLimitRefresh, coolingDown(refreshCooldown, refreshVideos))).Methods("POST")
+	api.HandleFunc("/videos/{id}", getVideo).Methods("GET")
+	api.HandleFunc("/videos/{id}", requireAdmin(updateVideo)).Methods("PATCH")
+	api.HandleFunc("/videos/{id}/history", requireAdmin(getVideoHistory)).Methods("GET")
+	api.HandleFunc("/videos/{id}/stream", streamVideo).Methods("GET")
+	api.HandleFunc("/videos/{id}/thumbnail", getThumbnail).Methods("GET")
+	api.HandleFunc("/videos/{id}/preview", getPreview).Methods("GET")
+	api.HandleFunc("/videos/{id}/trickplay.vtt", getTrickplayVTT).Methods("GET")
+	api.HandleFunc("/videos/{id}/trickplay/{sprite:[0-9]+}.jpg", getTrickplaySprite).Methods("GET")
+	api.HandleFunc("/videos/{id}/view", rateLimited(rateLimitView, incrementView)).Methods("POST")
+	api.HandleFunc("/videos/{id}/like", rateLimited(rateLimitLike, toggleLike)).Methods("POST")
+	api.HandleFunc("/videos/{id}/reactions", getVideoReactions).Methods("GET")
+	api.HandleFunc("/videos/{id}/reactions", rateLimited(rateLimitReaction, toggleVideoReaction)).Methods("POST")
+	api.HandleFunc("/reactions", getReactionSet).Methods("GET")
+	api.HandleFunc("/notifications", getNotifications).Methods("GET")
+	api.HandleFunc("/notifications/read", markNotificationsRead).Methods("POST")
+	api.HandleFunc("/videos/{id}/comments", getComments).Methods("GET")
+	api.HandleFunc("/videos/{id}/comments", rateLimited(rateLimitComment, addComment)).Methods("POST")
+	api.HandleFunc("/videos/{id}/comments/{commentId}/replies", getReplies).Methods("GET")
+	api.HandleFunc("/videos/{id}/comments/{commentId}/replies", rateLimited(rateLimitComment, addReply)).Methods("POST")
+	api.HandleFunc("/videos/{id}/comments/{commentId}", rateLimited(rateLimitComment, editComment)).Methods("PATCH")
+	api.HandleFunc("/videos/{id}/comments/{commentId}", deleteComment).Methods("DELETE")
+	api.HandleFunc("/videos/{id}/comments/{commentId}/reactions", getCommentReactions).Methods("GET")
+	api.HandleFunc("/videos/{id}/comments/{commentId}/reactions", rateLimited(rateLimitReaction, toggleCommentReaction)).Methods("POST")
+	api.HandleFunc("/videos/{id}/next-episode", getNextEpisode).Methods("GET")
+	api.HandleFunc("/videos/{id}/subtitles", getSubtitles).Methods("GET")
+	api.HandleFunc("/videos/{id}/subtitles/{track:[0-9]+}.vtt", getSubtitleVTT).Methods("GET")
+	api.HandleFunc("/videos/{id}/chapters", getChapters).Methods("GET")
+	api.HandleFunc("/videos/{id}/chapters", requireAdmin(setChapters)).Methods("PUT")
+	api.HandleFunc("/videos/{id}/chapters", requireAdmin(deleteChapters)).Methods("DELETE")
+	api.HandleFunc("/videos/{id}/chapters.vtt", getChaptersVTT).Methods("GET")
+	api.HandleFunc("/videos/{id}/tags", getVideoTags).Methods("GET")
+	api.HandleFunc("/videos/{id}/tags", addVideoTag).Methods("POST")
+	api.HandleFunc("/videos/{id}/tags/{tag}", removeVideoTag).Methods("DELETE")
+	api.HandleFunc("/tags", getTags).Methods("GET")
+	api.HandleFunc("/tags/{tag}", requireAdmin(renameTag)).Methods("PATCH")
+	api.HandleFunc("/tags/{tag}", requireAdmin(deleteTag)).Methods("DELETE")
+	api.HandleFunc("/shows", getShows).Methods("GET")
+	api.HandleFunc("/shows/{id}", getShow).Methods("GET")
+	api.HandleFunc("/shows/{id}/seasons", getShowSeasons).Methods("GET")
+	api.HandleFunc("/shows/{id}/seasons/{season}/episodes", getSeasonEpisodes).Methods("GET")
+	api.HandleFunc("/admin/jobs", requireAdmin(getJobs)).Methods("GET")
+	api.HandleFunc("/admin/jobs/{id}/cancel", requireAdmin(cancelJob)).Methods("POST")
+	api.HandleFunc("/admin/comments", requireAdmin(getModerationQueue)).Methods("GET")
+	api.HandleFunc("/admin/comments/{commentId}", requireAdmin(moderateComment)).Methods("PATCH")
+	api.HandleFunc("/playlists", getPlaylists).Methods("GET")
+	api.HandleFunc("/playlists", createPlaylist).Methods("POST")
+	api.HandleFunc("/playlists/preview", requireAdmin(previewPlaylists)).Methods("POST")
+	api.HandleFunc("/playlists/{playlistId}", getPlaylist).Methods("GET")
+	api.HandleFunc("/playlists/{playlistId}", updatePlaylist).Methods("PATCH")
+	api.HandleFunc("/playlists/{playlistId}", deletePlaylist).Methods("DELETE")
+	api.HandleFunc("/playlists/{playlistId}/items", addPlaylistItem).Methods("POST")
+	api.HandleFunc("/playlists/{playlistId}/items", reorderPlaylistItems).Methods("PUT")
+	api.HandleFunc("/playlists/{playlistId}/items/{videoId}", removePlaylistItem).Methods("DELETE")
+	api.HandleFunc("/playlists/{playlistId}/order", requireAdmin(pinPlaylistOrder)).Methods("PUT")
+	api.HandleFunc("/playlists/{playlistId}/order", requireAdmin(unpinPlaylistOrder)).Methods("DELETE")
+
+	for _, r := range []*mux.Router{router, api} {
+		r.NotFoundHandler = notFound(router)
+		r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	}
+
+	return router
+}
+
 func setupLogging() {
 	logFile := filepath.Join(config.ConfigDir, "streamlite.log")
 
@@ -178,8 +376,24 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func scanVideoDirectory() error {
+// getEnvInt reads a positive integer setting, falling back to the default
+// when it is unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 1 {
+		return defaultValue
+	}
+	return value
+}
+
+func scanVideoDirectory() (err error) {
 	logger.Printf("Scanning video directory: %s", config.VideoDir)
+	events.publish(eventScanStarted, 0, struct{}{})
+	defer func() {
+		if err != nil {
+			events.publish(eventScanFailed, 0, map[string]string{"error": err.Error()})
+		}
+	}()
 
 	// Verify video directory exists and is accessible
 	if _, err := os.Stat(config.VideoDir); err != nil {
@@ -209,11 +423,12 @@ func scanVideoDirectory() error {
 	foundFiles := make(map[string]bool)
 	addedCount := 0
 	updatedCount := 0
+	removedCount := 0
 
 	// Track visited directories to avoid infinite loops with circular symlinks
 	visitedDirs := make(map[string]bool)
 
-	err := walkWithSymlinks(config.VideoDir, visitedDirs, func(path string, info os.FileInfo, err error) error {
+	err = walkWithSymlinks(config.VideoDir, visitedDirs, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			logger.Printf("Error accessing path %s: %v", path, err)
 			return nil // Continue walking
@@ -239,24 +454,29 @@ func scanVideoDirectory() error {
 
 		// Mark this file as found
 		foundFiles[path] = true
+		if len(foundFiles)%scanProgressInterval == 0 {
+			events.publish(eventScanProgress, 0, map[string]int{"scanned": len(foundFiles), "added": addedCount, "updated": updatedCount})
+		}
 
 		// Check if video already exists in database
 		var existingID int
 		var existingModTime time.Time
 		var existingFileSize int64
-		err = db.QueryRow("SELECT id, modified_at, file_size FROM videos WHERE filepath = $1", path).Scan(&existingID, &existingModTime, &existingFileSize)
+		var existingSidecar string
+		var existingProbed bool
+		err = db.QueryRow("SELECT id, modified_at, file_size, sidecar_signature, probed_at IS NOT NULL FROM videos WHERE filepath = $1", path).Scan(&existingID, &existingModTime, &existingFileSize, &existingSidecar, &existingProbed)
 
 		if err == sql.ErrNoRows {
 			// New video - insert it
 			filename := info.Name()
-			title := strings.TrimSuffix(filename, ext)
-			title = strings.ReplaceAll(title, "_", " ")
-			title = strings.ReplaceAll(title, "-", " ")
+			title := titleFromFilename(filename)
 
-			_, err = db.Exec(`
+			var newID int
+			err = db.QueryRow(`
 				INSERT INTO videos (filename, filepath, title, file_size, modified_at)
 				VALUES ($1, $2, $3, $4, $5)
-			`, filename, path, title, info.Size(), info.ModTime())
+				RETURNING id
+			`, filename, path, title, info.Size(), info.ModTime()).Scan(&newID)
 
 			if err != nil {
 				logger.Printf("Error inserting video %s: %v", filename, err)
@@ -265,12 +485,18 @@ func scanVideoDirectory() error {
 
 			addedCount++
 			logger.Printf("Added new video: %s", filename)
+
+			syncSidecar(newID, path, "")
+			syncSubtitles(newID, path)
+			queueProbe(newID)
+			publishVideo(eventVideoAdded, newID)
 		} else if err != nil {
 			logger.Printf("Error checking video existence: %v", err)
 			return nil
 		} else {
 			// Video exists - check if metadata needs updating
-			if info.ModTime().After(existingModTime) || info.Size() != existingFileSize {
+			changed := info.ModTime().After(existingModTime) || info.Size() != existingFileSize
+			if changed {
 				_, err = db.Exec(`
 					UPDATE videos 
 					SET file_size = $1, modified_at = $2
@@ -284,6 +510,15 @@ func scanVideoDirectory() error {
 					logger.Printf("Updated metadata for video ID %d", existingID)
 				}
 			}
+
+			syncSidecar(existingID, path, existingSidecar)
+			syncSubtitles(existingID, path)
+			if changed || !existingProbed {
+				queueProbe(existingID)
+			}
+			if changed {
+				publishVideo(eventVideoUpdated, existingID)
+			}
 		}
 
 		return nil
@@ -301,7 +536,6 @@ func scanVideoDirectory() error {
 			logger.Printf("Error querying videos for cleanup: %v", err)
 		} else {
 			defer rows.Close()
-			removedCount := 0
 
 			for rows.Next() {
 				var id int
@@ -318,6 +552,9 @@ func scanVideoDirectory() error {
 						logger.Printf("Error removing video %s: %v", filename, err)
 					} else {
 						removedCount++
+						removeCachedSubtitles(id)
+						os.RemoveAll(videoCacheDir(id))
+						events.publish(eventVideoRemoved, id, map[string]int{"id": id})
 						logger.Printf("Removed deleted video: %s", filename)
 					}
 				}
@@ -329,9 +566,30 @@ func scanVideoDirectory() error {
 		logger.Printf("Scan complete: %d added, %d updated (no cleanup performed - no files found)", addedCount, updatedCount)
 	}
 
+	if err := materializePlaylists(); err != nil {
+		logger.Printf("Error materializing playlists: %v", err)
+	}
+
+	if err := indexShows(); err != nil {
+		logger.Printf("Error indexing shows: %v", err)
+	}
+
+	if err := indexAutoTags(); err != nil {
+		logger.Printf("Error indexing auto-tags: %v", err)
+	}
+
+	events.publish(eventScanCompleted, 0, map[string]int{"scanned": len(foundFiles), "added": addedCount, "updated": updatedCount, "removed": removedCount})
 	return nil
 }
 
+// titleFromFilename derives a display title when no sidecar provides one
+func titleFromFilename(filename string) string {
+	title := strings.TrimSuffix(filename, filepath.Ext(filename))
+	title = strings.ReplaceAll(title, "_", " ")
+	title = strings.ReplaceAll(title, "-", " ")
+	return title
+}
+
 // walkWithSymlinks walks the file tree following symbolic links
 func walkWithSymlinks(root string, visitedDirs map[string]bool, walkFn filepath.WalkFunc) error {
 	// Get absolute path to handle symlinks properly
@@ -406,33 +664,78 @@ func walkWithSymlinks(root string, visitedDirs map[string]bool, walkFn filepath.
 	})
 }
 
+// getVideos lists videos, optionally filtered to those carrying every
+// ?tag= slug given. With ?facets=true the list is wrapped in an object that
+// also carries tag counts across the matching videos. /api/v2 returns a page
+// of them, as a listPage or, with facets, a videoPage.
 func getVideos(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	slugs := []string{}
+	for _, tag := range query["tag"] {
+		if slug := tagSlug(tag); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	where := ""
+	var args []interface{}
+	if len(slugs) > 0 {
+		where = "WHERE " + tagFilterClause
+		args = append(args, pq.Array(slugs), len(cleanList(slugs)))
+	}
+
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+	limit, pageArgs := page.limitClause(args)
 	rows, err := db.Query(`
-		SELECT id, filename, filepath, title, views, likes, duration, file_size, created_at, modified_at
-		FROM videos
-		ORDER BY modified_at DESC
-	`)
+		SELECT `+videoColumns+`
+		FROM `+videoTables+`
+		`+where+`
+		ORDER BY o.sort_order ASC NULLS LAST, v.modified_at DESC, v.id DESC
+		`+limit, pageArgs...)
 	if err != nil {
 		logger.Printf("Error querying videos: %v", err)
-		http.Error(w, "Failed to fetch videos", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch videos")
 		return
 	}
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var v Video
-		err := rows.Scan(&v.ID, &v.Filename, &v.Filepath, &v.Title, &v.Views, &v.Likes, &v.Duration, &v.FileSize, &v.CreatedAt, &v.ModifiedAt)
+		v, err := scanVideo(rows)
 		if err != nil {
 			logger.Printf("Error scanning video: %v", err)
 			continue
 		}
-		v.ThumbnailURL = fmt.Sprintf("/api/videos/%d/thumbnail", v.ID)
+		v.setLinks(apiBase(r))
 		videos = append(videos, v)
 	}
 
+	total, err := page.countRows(len(videos), `SELECT COUNT(*) FROM videos v `+where, args...)
+	if err != nil {
+		logger.Printf("Error counting videos: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch videos")
+		return
+	}
+	if query.Get("facets") != "true" {
+		writePage(w, page, videos, total)
+		return
+	}
+
+	facets, err := loadFacets(where, args)
+	if err != nil {
+		logger.Printf("Error querying tag facets: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch videos")
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(videos)
+	if page == nil {
+		json.NewEncoder(w).Encode(facetedVideos{Videos: videos, Facets: facets})
+		return
+	}
+	json.NewEncoder(w).Encode(videoPage{listPage: pageFrom(*page, videos, total), Facets: facets})
 }
 
 func refreshVideos(w http.ResponseWriter, r *http.Request) {
@@ -440,35 +743,33 @@ func refreshVideos(w http.ResponseWriter, r *http.Request) {
 
 	if err := scanVideoDirectory(); err != nil {
 		logger.Printf("Error during video refresh: %v", err)
-		http.Error(w, "Failed to refresh videos", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to refresh videos")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Videos refreshed successfully"})
+	json.NewEncoder(w).Encode(statusResponse{Status: "success", Message: "Videos refreshed successfully"})
 }
 
 func getVideo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var v Video
-	err := db.QueryRow(`
-		SELECT id, filename, filepath, title, views, likes, duration, file_size, created_at, modified_at
-		FROM videos
-		WHERE id = $1
-	`, id).Scan(&v.ID, &v.Filename, &v.Filepath, &v.Title, &v.Views, &v.Likes, &v.Duration, &v.FileSize, &v.CreatedAt, &v.ModifiedAt)
+	v, err := scanVideo(db.QueryRow(`
+		SELECT `+videoColumns+`
+		FROM `+videoTables+`
+		WHERE v.id = $1
+	`, id))
 
 	if err == sql.ErrNoRows {
-		http.Error(w, "Video not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
 		return
 	} else if err != nil {
 		logger.Printf("Error fetching video: %v", err)
-		http.Error(w, "Failed to fetch video", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
 		return
 	}
-
-	v.ThumbnailURL = fmt.Sprintf("/api/videos/%d/thumbnail", v.ID)
+	v.setLinks(apiBase(r))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -481,11 +782,11 @@ func streamVideo(w http.ResponseWriter, r *http.Request) {
 	var videoPath string
 	err := db.QueryRow("SELECT filepath FROM videos WHERE id = $1", id).Scan(&videoPath)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Video not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
 		return
 	} else if err != nil {
 		logger.Printf("Error fetching video filepath: %v", err)
-		http.Error(w, "Failed to fetch video", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
 		return
 	}
 
@@ -497,13 +798,13 @@ func streamVideo(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			logger.Printf("Video file not found: %s", videoPath)
-			http.Error(w, "Video file not found", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, errorCodeNotFound, "Video file not found")
 		} else if os.IsPermission(err) {
 			logger.Printf("Permission denied accessing video file: %s", videoPath)
-			http.Error(w, "Permission denied accessing video file", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errorCodeForbidden, "Permission denied accessing video file")
 		} else {
 			logger.Printf("Error accessing video file %s: %v", videoPath, err)
-			http.Error(w, "Failed to access video file", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to access video file")
 		}
 		return
 	}
@@ -512,7 +813,7 @@ func streamVideo(w http.ResponseWriter, r *http.Request) {
 	file, err := os.Open(videoPath)
 	if err != nil {
 		logger.Printf("Error opening video file %s: %v", videoPath, err)
-		http.Error(w, "Failed to open video file", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to open video file")
 		return
 	}
 	defer file.Close()
@@ -581,21 +882,19 @@ func incrementView(w http.ResponseWriter, r *http.Request) {
 	_, err := db.Exec("UPDATE videos SET views = views + 1 WHERE id = $1", id)
 	if err != nil {
 		logger.Printf("Error incrementing view count: %v", err)
-		http.Error(w, "Failed to increment view count", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to increment view count")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(statusResponse{Status: "success"})
 }
 
 func toggleLike(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var body struct {
-		Action string `json:"action"` // "like" or "unlike"
-	}
+	var body likeInput
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		body.Action = "like" // Default to like
 	}
@@ -607,112 +906,33 @@ func toggleLike(w http.ResponseWriter, r *http.Request) {
 		query = "UPDATE videos SET likes = likes + 1 WHERE id = $1"
 	}
 
-	_, err := db.Exec(query, id)
-	if err != nil {
+	var videoID, likes int
+	err := db.QueryRow(query+" RETURNING id, likes", id).Scan(&videoID, &likes)
+	if err != nil && err != sql.ErrNoRows {
 		logger.Printf("Error updating like count: %v", err)
-		http.Error(w, "Failed to update like count", http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
-
-func getComments(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	rows, err := db.Query(`
-		SELECT id, video_id, author, content, created_at
-		FROM comments
-		WHERE video_id = $1
-		ORDER BY created_at DESC
-	`, id)
-	if err != nil {
-		logger.Printf("Error querying comments: %v", err)
-		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update like count")
 		return
 	}
-	defer rows.Close()
-
-	comments := []Comment{}
-	for rows.Next() {
-		var c Comment
-		err := rows.Scan(&c.ID, &c.VideoID, &c.Author, &c.Content, &c.CreatedAt)
-		if err != nil {
-			logger.Printf("Error scanning comment: %v", err)
-			continue
-		}
-		comments = append(comments, c)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
-}
-
-func addComment(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	var comment Comment
-	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	if err == nil {
+		events.publish(eventLikesUpdated, videoID, map[string]int{"video_id": videoID, "likes": likes})
 	}
 
-	// Sanitize and validate input
-	comment.Author = strings.TrimSpace(comment.Author)
-	comment.Content = strings.TrimSpace(comment.Content)
-
-	// Limit author name length
-	if len(comment.Author) > 100 {
-		comment.Author = comment.Author[:100]
-	}
-
-	// Limit content length
-	if len(comment.Content) > 5000 {
-		http.Error(w, "Comment content too long (max 5000 characters)", http.StatusBadRequest)
-		return
-	}
-
-	if comment.Author == "" {
-		comment.Author = "Anonymous"
-	}
-
-	if comment.Content == "" {
-		http.Error(w, "Comment content is required", http.StatusBadRequest)
-		return
-	}
-
-	err := db.QueryRow(`
-		INSERT INTO comments (video_id, author, content)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at
-	`, id, comment.Author, comment.Content).Scan(&comment.ID, &comment.CreatedAt)
-
-	if err != nil {
-		logger.Printf("Error inserting comment: %v", err)
-		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(comment)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statusResponse{Status: "success"})
 }
 
 func getThumbnail(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var videoPath string
-	err := db.QueryRow("SELECT filepath FROM videos WHERE id = $1", id).Scan(&videoPath)
+	var videoPath, thumbnailPath string
+	err := db.QueryRow("SELECT filepath, thumbnail_path FROM videos WHERE id = $1", id).Scan(&videoPath, &thumbnailPath)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Video not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
 		return
 	} else if err != nil {
 		logger.Printf("Error fetching video filepath: %v", err)
-		http.Error(w, "Failed to fetch video", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
 		return
 	}
 
@@ -726,6 +946,19 @@ func getThumbnail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Serve the custom thumbnail named in a sidecar file
+	if thumbnailPath != "" {
+		if f, err := os.Open(thumbnailPath); err == nil {
+			defer f.Close()
+			if info, err := f.Stat(); err == nil && !info.IsDir() {
+				w.Header().Set("Cache-Control", "public, max-age=3600")
+				http.ServeContent(w, r, filepath.Base(thumbnailPath), info.ModTime(), f)
+				return
+			}
+		}
+		logger.Printf("Custom thumbnail not readable, using placeholder: %s", thumbnailPath)
+	}
+
 	// Serve placeholder thumbnail
 	// In production, you could generate real thumbnails using ffmpeg
 	servePlaceholderThumbnail(w)
@@ -759,6 +992,9 @@ func normalizePlaylistName(filename string) string {
 	} {
 		name = strings.TrimSuffix(name, pattern)
 	}
+
+	// Remove season/episode markers (e.g., S01E02, part 3)
+	name = stripEpisodeMarker(name)
 	
 	// Remove trailing numbers (e.g., _1, _2, -1, -2, etc.)
 	for i := len(name) - 1; i >= 0; i-- {
@@ -782,113 +1018,131 @@ func normalizePlaylistName(filename string) string {
 	return name
 }
 
-// generatePlaylists groups videos by similar names within the same directory
-func generatePlaylists() []Playlist {
+// playlistVideo is the subset of video columns used to group playlists
+type playlistVideo struct {
+	ID       int
+	Filename string
+	Filepath string
+	Title    string
+	Duration int
+}
+
+// generatePlaylists groups the library using the configured grouping rules
+func generatePlaylists() ([]Playlist, error) {
+	rules, err := loadGroupingConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	videos, err := queryPlaylistVideos()
+	if err != nil {
+		return nil, err
+	}
+
+	return groupPlaylists(videos, rules), nil
+}
+
+// queryPlaylistVideos loads the columns needed to group every video
+func queryPlaylistVideos() ([]playlistVideo, error) {
 	rows, err := db.Query(`
-		SELECT id, filename, filepath, title
+		SELECT id, filename, filepath, title, duration
 		FROM videos
 		ORDER BY filepath
 	`)
 	if err != nil {
-		logger.Printf("Error querying videos for playlists: %v", err)
-		return []Playlist{}
+		return nil, fmt.Errorf("querying videos for playlists: %w", err)
 	}
 	defer rows.Close()
 
-	// Group videos by directory and normalized name
-	type videoInfo struct {
-		ID       int
-		Filename string
-		Filepath string
-		Title    string
-	}
-	
-	var videos []videoInfo
+	var videos []playlistVideo
 	for rows.Next() {
-		var v videoInfo
-		if err := rows.Scan(&v.ID, &v.Filename, &v.Filepath, &v.Title); err != nil {
+		var v playlistVideo
+		if err := rows.Scan(&v.ID, &v.Filename, &v.Filepath, &v.Title, &v.Duration); err != nil {
 			logger.Printf("Error scanning video: %v", err)
 			continue
 		}
 		videos = append(videos, v)
 	}
-
-	// Group by directory and normalized name
-	playlistMap := make(map[string][]videoInfo)
-	for _, v := range videos {
-		dir := filepath.Dir(v.Filepath)
-		normalized := normalizePlaylistName(v.Filename)
-		key := dir + "||" + normalized
-		playlistMap[key] = append(playlistMap[key], v)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading videos for playlists: %w", err)
 	}
 
-	// Create playlists only for groups with more than one video
-	var playlists []Playlist
-	for key, vids := range playlistMap {
-		if len(vids) < 2 {
-			continue
-		}
-
-		parts := strings.Split(key, "||")
-		dir := parts[0]
-		normalized := parts[1]
+	return videos, nil
+}
 
-		// Sort videos alphabetically by filename using built-in sort
-		sortedVids := make([]videoInfo, len(vids))
-		copy(sortedVids, vids)
-		sort.Slice(sortedVids, func(i, j int) bool {
-			return sortedVids[i].Filename < sortedVids[j].Filename
-		})
+// groupPlaylists builds playlists from the groups produced by the grouping rules
+func groupPlaylists(videos []playlistVideo, rules GroupingConfig) []Playlist {
+	playlists := []Playlist{}
+	for _, group := range groupVideos(videos, rules) {
+		playlists = append(playlists, group.playlist())
+	}
+	return playlists
+}
 
-		var videoIDs []int
-		for _, v := range sortedVids {
-			videoIDs = append(videoIDs, v.ID)
-		}
+// getPlaylists lists the materialized playlists followed by the curated ones
+// the caller can see. /api/v2 pages through both as one listing.
+func getPlaylists(w http.ResponseWriter, r *http.Request) {
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
 
-		// Use first video's title as playlist name (cleaned up)
-		playlistName := normalized
-		if len(playlistName) > 0 {
-			// Capitalize first letter
-			playlistName = strings.ToUpper(string(playlistName[0])) + playlistName[1:]
-		}
+	playlists, err := loadAutoPlaylists(page)
+	if err != nil {
+		logger.Printf("Error querying playlists: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlists")
+		return
+	}
+	total, err := page.countRows(len(playlists), `SELECT COUNT(*) FROM auto_playlists`)
+	if err != nil {
+		logger.Printf("Error counting playlists: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlists")
+		return
+	}
 
-		// Generate deterministic playlist ID based on directory and normalized name
-		hashInput := dir + "||" + normalized
-		hash := md5.Sum([]byte(hashInput))
-		playlistID := "pl_" + hex.EncodeToString(hash[:])[:12]
-
-		playlist := Playlist{
-			ID:          playlistID,
-			Name:        playlistName,
-			VideoIDs:    videoIDs,
-			VideoCount:  len(videoIDs),
-			ThumbnailID: videoIDs[0],
-			Directory:   dir,
-		}
-		playlists = append(playlists, playlist)
+	user := requestUser(r)
+	userPlaylists, err := loadUserPlaylists(user, page.after(total, len(playlists)))
+	if err == nil {
+		var userTotal int
+		userTotal, err = page.countRows(len(userPlaylists), `
+			SELECT COUNT(*) FROM user_playlists WHERE visibility = $1 OR owner = $2
+		`, visibilityShared, user)
+		total += userTotal
+	}
+	if err != nil {
+		logger.Printf("Error querying user playlists: %v", err)
+	} else {
+		playlists = append(playlists, userPlaylists...)
 	}
 
-	return playlists
+	writePage(w, page, playlists, total)
 }
 
-func getPlaylists(w http.ResponseWriter, r *http.Request) {
-	playlists := generatePlaylists()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(playlists)
-}
 
 func getPlaylist(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	playlistID := vars["id"]
-
-	playlists := generatePlaylists()
-	for _, playlist := range playlists {
-		if playlist.ID == playlistID {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(playlist)
-			return
+	playlistID := vars["playlistId"]
+
+	var playlist Playlist
+	var err error
+	if userID, ok := parseUserPlaylistID(playlistID); ok {
+		playlist, err = loadUserPlaylist(userID)
+		if err == nil && !canViewUserPlaylist(playlist, r) {
+			err = sql.ErrNoRows
 		}
+	} else {
+		playlist, err = loadAutoPlaylist(playlistID)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Playlist not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlist")
+		return
 	}
 
-	http.Error(w, "Playlist not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
 }
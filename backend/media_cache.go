@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// thumbnailCacheDir holds generated images under CONFIG_DIR, one directory
+// per video
+const thumbnailCacheDir = "thumbnails"
+
+// sourceStamp records the video file a cached artifact was generated from
+type sourceStamp struct {
+	VideoSize     int64     `json:"video_size"`
+	VideoModified time.Time `json:"video_modified"`
+}
+
+func newSourceStamp(info os.FileInfo) sourceStamp {
+	return sourceStamp{VideoSize: info.Size(), VideoModified: info.ModTime()}
+}
+
+// current reports whether the artifact was made from the file as it is now
+func (s sourceStamp) current(info os.FileInfo) bool {
+	return s.VideoSize == info.Size() && s.VideoModified.Equal(info.ModTime())
+}
+
+// videoCacheDir is where generated images for a video are kept
+func videoCacheDir(videoID int) string {
+	return filepath.Join(config.ConfigDir, thumbnailCacheDir, strconv.Itoa(videoID))
+}
+
+// lowPriorityCommand builds an ffmpeg command that runs at the lowest CPU
+// priority when nice is available
+func lowPriorityCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if nice, err := exec.LookPath("nice"); err == nil {
+		return exec.CommandContext(ctx, nice, append([]string{"-n", "19", config.FFmpegPath}, args...)...)
+	}
+	return exec.CommandContext(ctx, config.FFmpegPath, args...)
+}
+
+// videoFileInfo loads the video a generated-media handler is for, writing the
+// error response when it returns false
+func videoFileInfo(w http.ResponseWriter, r *http.Request) (int, os.FileInfo, bool) {
+	videoID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid video ID")
+		return 0, nil, false
+	}
+
+	var videoPath string
+	err = db.QueryRow("SELECT filepath FROM videos WHERE id = $1", videoID).Scan(&videoPath)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+		return 0, nil, false
+	} else if err != nil {
+		logger.Printf("Error fetching video filepath: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
+		return 0, nil, false
+	}
+
+	fileInfo, err := os.Stat(videoPath)
+	if err != nil {
+		logger.Printf("Video file not found for generated media: %s", videoPath)
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video file not accessible")
+		return 0, nil, false
+	}
+	return videoID, fileInfo, true
+}
+
+// durationKnown reports whether a video has been probed for its duration,
+// which generating timed media needs, writing the error response when not
+func durationKnown(w http.ResponseWriter, videoID int) bool {
+	var duration int
+	if err := db.QueryRow("SELECT duration FROM videos WHERE id = $1", videoID).Scan(&duration); err != nil {
+		logger.Printf("Error fetching video duration: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
+		return false
+	}
+	if duration <= 0 {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video duration unknown")
+		return false
+	}
+	return true
+}
+
+// queueMediaResponse queues generation of something that isn't cached yet and
+// answers 202 Accepted, or explains why it can't be generated
+func queueMediaResponse(w http.ResponseWriter, videoID int, what string, queue func() (bool, error)) {
+	if _, err := exec.LookPath(config.FFmpegPath); err != nil {
+		writeError(w, http.StatusServiceUnavailable, errorCodeUnavailable, what+" generation unavailable (ffmpeg not installed)")
+		return
+	}
+
+	queued, err := queue()
+	if err != nil {
+		logger.Printf("Error queueing %s generation for video ID %d: %v", strings.ToLower(what), videoID, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to queue "+strings.ToLower(what)+" generation")
+		return
+	}
+	if !queued {
+		writeError(w, http.StatusServiceUnavailable, errorCodeUnavailable, what+" generation failed recently")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "30")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(statusResponse{Status: "queued"})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// seasonEpisodePattern matches markers such as S01E02, s1e2 and S01.E02
+	seasonEpisodePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,3})[ ._-]?e(\d{1,4})(?:[^0-9]|$)`)
+
+	// partPattern matches markers such as "Part 3", part_3 and Part-03
+	partPattern = regexp.MustCompile(`(?i)(?:^|[^a-z])part[ ._-]*(\d+)`)
+
+	// seasonEpisodeSuffixPattern strips an S01E02 marker and any episode title after it
+	seasonEpisodeSuffixPattern = regexp.MustCompile(`(?i)[ ._-]*s\d{1,3}[ ._-]?e\d{1,4}(?:[^0-9].*)?$`)
+
+	// partSuffixPattern strips a trailing "part N" marker
+	partSuffixPattern = regexp.MustCompile(`(?i)[ ._-]*part[ ._-]*\d+$`)
+)
+
+// episodeKey extracts the season and episode (or part) number from a filename.
+// Part markers are reported as season 0.
+func episodeKey(filename string) (season, episode int, ok bool) {
+	if m := seasonEpisodePattern.FindStringSubmatch(filename); m != nil {
+		season, _ = strconv.Atoi(m[1])
+		episode, _ = strconv.Atoi(m[2])
+		return season, episode, true
+	}
+	if m := partPattern.FindStringSubmatch(filename); m != nil {
+		episode, _ = strconv.Atoi(m[1])
+		return 0, episode, true
+	}
+	return 0, 0, false
+}
+
+// stripEpisodeMarker removes season/episode and part markers used by
+// normalizePlaylistName, leaving the name untouched if nothing would remain
+func stripEpisodeMarker(name string) string {
+	stripped := seasonEpisodeSuffixPattern.ReplaceAllString(name, "")
+	stripped = partSuffixPattern.ReplaceAllString(stripped, "")
+	if strings.TrimSpace(stripped) == "" {
+		return name
+	}
+	return stripped
+}
+
+// naturalLess compares strings case-insensitively, treating runs of digits
+// as numbers so that "episode_2" sorts before "episode_10"
+func naturalLess(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	i, j := 0, 0
+	for i < len(la) && j < len(lb) {
+		ca, cb := la[i], lb[j]
+		if isDigit(ca) && isDigit(cb) {
+			startA, startB := i, j
+			for i < len(la) && isDigit(la[i]) {
+				i++
+			}
+			for j < len(lb) && isDigit(lb[j]) {
+				j++
+			}
+			numA := strings.TrimLeft(la[startA:i], "0")
+			numB := strings.TrimLeft(lb[startB:j], "0")
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			continue
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(la)-i != len(lb)-j {
+		return len(la)-i < len(lb)-j
+	}
+	// Equal ignoring case and zero padding; fall back to a stable byte order
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// playlistOrderLess orders playlist members by season/episode or part number
+// when both files carry one, and naturally by filename otherwise
+func playlistOrderLess(a, b string) bool {
+	seasonA, episodeA, okA := episodeKey(a)
+	seasonB, episodeB, okB := episodeKey(b)
+	if okA && okB {
+		if seasonA != seasonB {
+			return seasonA < seasonB
+		}
+		if episodeA != episodeB {
+			return episodeA < episodeB
+		}
+	}
+	return naturalLess(a, b)
+}
+
+// applyPinnedOrder reorders videoIDs to follow a manually pinned order. Videos
+// that have since left the playlist are dropped from the pin, and videos that
+// joined after it was pinned keep their generated order at the end.
+func applyPinnedOrder(videoIDs, pinned []int) []int {
+	present := make(map[int]bool, len(videoIDs))
+	for _, id := range videoIDs {
+		present[id] = true
+	}
+
+	ordered := make([]int, 0, len(videoIDs))
+	placed := make(map[int]bool, len(videoIDs))
+	for _, id := range pinned {
+		if present[id] && !placed[id] {
+			ordered = append(ordered, id)
+			placed[id] = true
+		}
+	}
+	for _, id := range videoIDs {
+		if !placed[id] {
+			ordered = append(ordered, id)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"episode_2.mp4", "episode_10.mp4", true},
+		{"episode_10.mp4", "episode_2.mp4", false},
+		{"episode_02.mp4", "episode_10.mp4", true},
+		{"Episode_3.mp4", "episode_4.mp4", true},
+		{"a.mp4", "b.mp4", true},
+		{"video.mp4", "video_1.mp4", true},
+		{"v1.9.mp4", "v1.10.mp4", true},
+	}
+
+	for _, test := range tests {
+		if result := naturalLess(test.a, test.b); result != test.expected {
+			t.Errorf("naturalLess(%q, %q) = %v; expected %v", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestEpisodeKey(t *testing.T) {
+	tests := []struct {
+		filename        string
+		season, episode int
+		ok              bool
+	}{
+		{"Show.S01E02.mkv", 1, 2, true},
+		{"show_s2e10_title.mkv", 2, 10, true},
+		{"Show S03.E04.mkv", 3, 4, true},
+		{"Lecture Part 3.mp4", 0, 3, true},
+		{"lecture_part_12.mp4", 0, 12, true},
+		{"episode_2.mp4", 0, 0, false},
+		{"counterpart1.mp4", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		season, episode, ok := episodeKey(test.filename)
+		if season != test.season || episode != test.episode || ok != test.ok {
+			t.Errorf("episodeKey(%q) = %d, %d, %v; expected %d, %d, %v",
+				test.filename, season, episode, ok, test.season, test.episode, test.ok)
+		}
+	}
+}
+
+func TestPlaylistOrderLess(t *testing.T) {
+	files := []string{
+		"show.S02E01.mkv",
+		"show.S01E10.mkv",
+		"show.S01E02.mkv",
+		"episode_10.mp4",
+		"episode_2.mp4",
+	}
+	sort.Slice(files, func(i, j int) bool { return playlistOrderLess(files[i], files[j]) })
+
+	expected := []string{"episode_2.mp4", "episode_10.mp4", "show.S01E02.mkv", "show.S01E10.mkv", "show.S02E01.mkv"}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Fatalf("Expected order %v, got %v", expected, files)
+		}
+	}
+}
+
+func TestApplyPinnedOrder(t *testing.T) {
+	tests := []struct {
+		videoIDs, pinned, expected []int
+	}{
+		{[]int{1, 2, 3}, []int{3, 1, 2}, []int{3, 1, 2}},
+		{[]int{1, 2, 3, 4}, []int{3, 1, 2}, []int{3, 1, 2, 4}},
+		{[]int{1, 3}, []int{3, 2, 1}, []int{3, 1}},
+		{[]int{1, 2}, nil, []int{1, 2}},
+	}
+
+	for _, test := range tests {
+		result := applyPinnedOrder(test.videoIDs, test.pinned)
+		if len(result) != len(test.expected) {
+			t.Errorf("applyPinnedOrder(%v, %v) = %v; expected %v", test.videoIDs, test.pinned, result, test.expected)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("applyPinnedOrder(%v, %v) = %v; expected %v", test.videoIDs, test.pinned, result, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Notification types
+const (
+	notificationReply   = "reply"
+	notificationMention = "mention"
+)
+
+const (
+	// maxMentions caps how many users one comment can notify by mentioning them
+	maxMentions = 10
+
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// mentionPattern matches @username where username is an X-User identity. The
+// @ must not follow a word character, so email addresses aren't mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// Notification tells a user about a reply to their comment or a comment
+// mentioning them. Actor is the comment's author name.
+type Notification struct {
+	ID        int        `json:"id"`
+	Type      string     `json:"type"`
+	VideoID   int        `json:"video_id"`
+	CommentID int        `json:"comment_id"`
+	Actor     string     `json:"actor"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// notificationList is the response of GET /api/notifications
+type notificationList struct {
+	Notifications []Notification `json:"notifications"`
+	Unread        int            `json:"unread"`
+}
+
+// unreadCount is the response of POST /api/notifications/read
+type unreadCount struct {
+	Unread int `json:"unread"`
+}
+
+// parseMentions returns the users mentioned in a comment, in order and
+// without duplicates
+func parseMentions(content string) []string {
+	var users []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// Trailing punctuation ends the sentence, not the name
+		user := strings.TrimRight(m[1], ".-")
+		if user == "" || len(user) > maxUserLength || seen[user] {
+			continue
+		}
+		seen[user] = true
+		users = append(users, user)
+		if len(users) == maxMentions {
+			break
+		}
+	}
+	return users
+}
+
+// notifyComment notifies the owner of the comment replied to and the users
+// mentioned in a comment that has just become visible. Nobody is notified
+// about their own comment, or twice about the same comment.
+func notifyComment(c Comment) {
+	type recipient struct{ user, kind string }
+	var recipients []recipient
+	seen := map[string]bool{"": true, c.Owner: true}
+
+	if c.ParentID != nil {
+		var owner sql.NullString
+		err := db.QueryRow("SELECT owner FROM comments WHERE id = $1", *c.ParentID).Scan(&owner)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Printf("Error fetching parent of comment %d: %v", c.ID, err)
+		}
+		if !seen[owner.String] {
+			seen[owner.String] = true
+			recipients = append(recipients, recipient{owner.String, notificationReply})
+		}
+	}
+	for _, user := range parseMentions(c.Content) {
+		if !seen[user] {
+			seen[user] = true
+			recipients = append(recipients, recipient{user, notificationMention})
+		}
+	}
+
+	for _, to := range recipients {
+		n := Notification{Type: to.kind, VideoID: c.VideoID, CommentID: c.ID, Actor: c.Author, Content: c.Content}
+		err := db.QueryRow(`
+			INSERT INTO notifications (recipient, type, video_id, comment_id, actor)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (recipient, comment_id) DO NOTHING
+			RETURNING id, created_at
+		`, to.user, n.Type, n.VideoID, n.CommentID, n.Actor).Scan(&n.ID, &n.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			logger.Printf("Error notifying %s about comment %d: %v", to.user, c.ID, err)
+			continue
+		}
+		events.publishTo(to.user, eventNotification, n.VideoID, n)
+	}
+}
+
+// unreadNotifications counts a user's unread notifications about comments
+// that are still visible
+func unreadNotifications(user string) (int, error) {
+	var unread int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM notifications n JOIN comments c ON c.id = n.comment_id
+		WHERE n.recipient = $1 AND n.read_at IS NULL AND `+commentFilter("c", false),
+		user).Scan(&unread)
+	return unread, err
+}
+
+// getNotifications lists the caller's notifications, newest first, with the
+// unread count. ?unread=true lists only unread ones; ?limit= defaults to 50.
+func getNotifications(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return
+	}
+
+	limit := defaultNotificationLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid limit")
+			return
+		}
+		if n > maxNotificationLimit {
+			n = maxNotificationLimit
+		}
+		limit = n
+	}
+	where := ""
+	if r.URL.Query().Get("unread") == "true" {
+		where = "AND n.read_at IS NULL"
+	}
+
+	rows, err := db.Query(`
+		SELECT n.id, n.type, n.video_id, n.comment_id, n.actor, c.content, n.created_at, n.read_at
+		FROM notifications n JOIN comments c ON c.id = n.comment_id
+		WHERE n.recipient = $1 AND `+commentFilter("c", false)+` `+where+`
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $2
+	`, user, limit)
+	if err != nil {
+		logger.Printf("Error querying notifications: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch notifications")
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Type, &n.VideoID, &n.CommentID, &n.Actor, &n.Content, &n.CreatedAt, &n.ReadAt); err != nil {
+			logger.Printf("Error scanning notification: %v", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	unread, err := unreadNotifications(user)
+	if err != nil {
+		logger.Printf("Error counting notifications: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch notifications")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationList{Notifications: notifications, Unread: unread})
+}
+
+// markNotificationsRead marks the caller's notifications listed in
+// {"ids": [...]} as read, or all of them without a body, and returns the new
+// unread count
+func markNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return
+	}
+
+	var body notificationsReadInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	query := "UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE recipient = $1 AND read_at IS NULL"
+	args := []interface{}{user}
+	if body.IDs != nil {
+		query += " AND id = ANY($2)"
+		args = append(args, pq.Array(body.IDs))
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		logger.Printf("Error marking notifications read: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update notifications")
+		return
+	}
+
+	unread, err := unreadNotifications(user)
+	if err != nil {
+		logger.Printf("Error counting notifications: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch notifications")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unreadCount{Unread: unread})
+}
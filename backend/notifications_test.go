@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"No mentions here", nil},
+		{"@alice what do you think?", []string{"alice"}},
+		{"Thanks @bob, @carol and @bob again", []string{"bob", "carol"}},
+		{"Ask @dave.", []string{"dave"}},
+		{"cc @guest-1a2b3c", []string{"guest-1a2b3c"}},
+		{"(@erin)", []string{"erin"}},
+		{"Mail me at frank@example.com", nil},
+		{"@@gina and @ alone", nil},
+	}
+
+	for _, test := range tests {
+		if result := parseMentions(test.content); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseMentions(%q) = %v; expected %v", test.content, result, test.expected)
+		}
+	}
+}
+
+func TestParseMentionsLimit(t *testing.T) {
+	var content []string
+	for i := 0; i < maxMentions+5; i++ {
+		content = append(content, "@user"+strings.Repeat("x", i))
+	}
+	if mentions := parseMentions(strings.Join(content, " ")); len(mentions) != maxMentions {
+		t.Errorf("parseMentions returned %d mentions; expected %d", len(mentions), maxMentions)
+	}
+}
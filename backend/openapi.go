@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOperation describes one route for the OpenAPI documents served at
+// /api/v1/openapi.json and /api/v2/openapi.json. Paths, methods and path
+// parameters are read from the router; everything else comes from here.
+// Request and response schemas are generated from the Go types handlers
+// decode and encode.
+type apiOperation struct {
+	summary string
+	tag     string
+	query   []apiParam
+
+	// identity and admin mark routes requiring X-User or the admin token
+	identity bool
+	admin    bool
+
+	// body is a value of the JSON request body's type
+	body interface{}
+
+	// status is the success status, 200 when unset
+	status int
+
+	// response is a value of the JSON response's type, an apiOneOf when it
+	// depends on the query, or an apiMedia for other content
+	response interface{}
+
+	// queued routes answer 202 Accepted while the content is generated
+	queued bool
+
+	// page is a value of the response's type in /api/v2 for listings, which
+	// take ?limit= and ?cursor= there
+	page interface{}
+}
+
+// apiParam is a query parameter. kind is its schema type.
+type apiParam struct {
+	name        string
+	kind        string
+	description string
+}
+
+// apiMedia lists the content types of a response that isn't JSON
+type apiMedia []string
+
+// apiOneOf lists the shapes a JSON response may take
+type apiOneOf []interface{}
+
+// Request bodies, as decoded by the handlers
+type (
+	commentInput struct {
+		Author           string `json:"author,omitempty"`
+		Content          string `json:"content"`
+		TimestampSeconds *int   `json:"timestamp_seconds,omitempty"`
+	}
+	commentEditInput struct {
+		Content string `json:"content"`
+	}
+	moderationInput struct {
+		Status *string `json:"status,omitempty"`
+		Hidden *bool   `json:"hidden,omitempty"`
+	}
+	likeInput struct {
+		Action string `json:"action"` // "like" or "unlike"; like when the body is missing
+	}
+	reactionInput struct {
+		Reaction string `json:"reaction"`
+		Active   *bool  `json:"active,omitempty"`
+	}
+	notificationsReadInput struct {
+		IDs []int64 `json:"ids,omitempty"`
+	}
+	tagInput struct {
+		Name string `json:"name"`
+	}
+	chaptersInput struct {
+		Chapters []chapterInput `json:"chapters"`
+	}
+	videoPatchInput struct {
+		Title       patchField[string]   `json:"title,omitempty"`
+		Description patchField[string]   `json:"description,omitempty"`
+		Tags        patchField[[]string] `json:"tags,omitempty"`
+		SortOrder   patchField[int]      `json:"sort_order,omitempty"`
+	}
+	playlistInput struct {
+		Name       string `json:"name"`
+		Visibility string `json:"visibility,omitempty"`
+		VideoIDs   []int  `json:"video_ids,omitempty"`
+	}
+	playlistUpdateInput struct {
+		Name       *string `json:"name,omitempty"`
+		Visibility *string `json:"visibility,omitempty"`
+	}
+	playlistItemInput struct {
+		VideoID  int  `json:"video_id"`
+		Position *int `json:"position,omitempty"` // appends when omitted
+	}
+	videoIDsInput struct {
+		VideoIDs []int `json:"video_ids"`
+	}
+)
+
+var (
+	commentListingParams = []apiParam{
+		{"depth", "integer", "Nest this many levels of replies under top-level comments (at most 10)"},
+		{"sort", "string", "newest, oldest, top or timestamp"},
+		{"around", "integer", "Only comments timestamped near this many seconds"},
+		{"window", "integer", "Seconds either side of around (default 30, at most 600)"},
+		{"limit", "integer", "Return one page of this many comments (at most 100)"},
+		{"cursor", "string", "next_cursor of the previous page"},
+	}
+	listPageParams = []apiParam{
+		{"limit", "integer", "Page size (default 50, at most 200)"},
+		{"cursor", "string", "next_cursor of the previous page"},
+	}
+	mediaQueued = "Queued for generation; try again later"
+)
+
+// apiOperations documents every route, keyed by method and path relative to
+// the version's prefix, with parameter patterns left out
+var apiOperations = map[string]apiOperation{
+	"GET /openapi.json": {summary: "This document", tag: "Meta", response: map[string]interface{}{}},
+	"GET /events": {summary: "Stream library, comment and notification events with Server-Sent Events", tag: "Events",
+		query: []apiParam{
+			{"video_id", "string", "Only events about these videos (repeatable or comma-separated)"},
+			{"type", "string", "Only events of these types (repeatable or comma-separated)"},
+			{"user", "string", "Identity to receive notifications for, when X-User can't be set"},
+			{"last_event_id", "string", "Resume after this event, when Last-Event-ID can't be set"},
+		},
+		response: apiMedia{"text/event-stream"}},
+
+	"GET /videos": {summary: "List videos", tag: "Videos",
+		query: []apiParam{
+			{"tag", "string", "Only videos carrying every given tag slug (repeatable)"},
+			{"facets", "boolean", "Wrap the list with tag counts across it"},
+		},
+		response: apiOneOf{[]Video{}, facetedVideos{}}, page: apiOneOf{listPage[Video]{}, videoPage{}}},
+	"POST /videos/refresh":          {summary: "Rescan the video directory", tag: "Videos", response: statusResponse{}},
+	"GET /videos/{id}":              {summary: "Get a video", tag: "Videos", response: Video{}},
+	"PATCH /videos/{id}":            {summary: "Edit a video's metadata; null clears a field", tag: "Videos", admin: true, body: videoPatchInput{}, response: Video{}},
+	"GET /videos/{id}/history":      {summary: "List a video's metadata edits", tag: "Videos", admin: true, response: []AuditEntry{}, page: listPage[AuditEntry]{}},
+	"POST /videos/{id}/view":        {summary: "Count a view", tag: "Videos", response: statusResponse{}},
+	"POST /videos/{id}/like":        {summary: "Like or unlike a video", tag: "Videos", body: likeInput{}, response: statusResponse{}},
+	"GET /videos/{id}/next-episode": {summary: "Get the episode after this one", tag: "Shows", response: Video{}},
+
+	"GET /videos/{id}/stream":    {summary: "Stream the video file; supports Range requests", tag: "Media", response: apiMedia{"video/*"}},
+	"GET /videos/{id}/thumbnail": {summary: "Get the thumbnail, or a placeholder", tag: "Media", response: apiMedia{"image/*"}},
+	"GET /videos/{id}/preview": {summary: "Get the animated hover preview", tag: "Media",
+		query:    []apiParam{{"format", "string", "webp (default) or mp4"}},
+		response: apiMedia{"image/webp", "video/mp4"}, queued: true},
+	"GET /videos/{id}/trickplay.vtt":          {summary: "Get the seek-preview thumbnails index", tag: "Media", response: apiMedia{"text/vtt"}, queued: true},
+	"GET /videos/{id}/trickplay/{sprite}.jpg": {summary: "Get a seek-preview sprite sheet", tag: "Media", response: apiMedia{"image/jpeg"}},
+	"GET /videos/{id}/subtitles":              {summary: "List subtitle tracks", tag: "Media", response: []SubtitleTrack{}, page: listPage[SubtitleTrack]{}},
+	"GET /videos/{id}/subtitles/{track}.vtt": {summary: "Get a subtitle track as WebVTT", tag: "Media",
+		query:    []apiParam{{"offset", "number", "Shift every cue by this many seconds"}},
+		response: apiMedia{"text/vtt"}, queued: true},
+	"GET /videos/{id}/chapters":     {summary: "List chapters", tag: "Media", response: []Chapter{}, page: listPage[Chapter]{}},
+	"PUT /videos/{id}/chapters":     {summary: "Replace the chapters", tag: "Media", admin: true, body: chaptersInput{}, response: []Chapter{}},
+	"DELETE /videos/{id}/chapters":  {summary: "Remove admin chapters", tag: "Media", admin: true, response: []Chapter{}},
+	"GET /videos/{id}/chapters.vtt": {summary: "Get chapters as WebVTT", tag: "Media", response: apiMedia{"text/vtt"}},
+
+	"GET /reactions":                                   {summary: "List the reactions that can be used", tag: "Reactions", response: []string{}},
+	"GET /videos/{id}/reactions":                       {summary: "Get a video's reaction counts and the caller's reactions", tag: "Reactions", response: reactionSummary{}},
+	"POST /videos/{id}/reactions":                      {summary: "Toggle a reaction on a video", tag: "Reactions", identity: true, body: reactionInput{}, response: reactionSummary{}},
+	"GET /videos/{id}/comments/{commentId}/reactions":  {summary: "Get a comment's reaction counts and the caller's reactions", tag: "Reactions", response: reactionSummary{}},
+	"POST /videos/{id}/comments/{commentId}/reactions": {summary: "Toggle a reaction on a comment", tag: "Reactions", identity: true, body: reactionInput{}, response: reactionSummary{}},
+
+	"GET /videos/{id}/comments": {summary: "List comments", tag: "Comments", query: commentListingParams,
+		response: apiOneOf{[]Comment{}, commentPage{}}, page: listPage[Comment]{}},
+	"POST /videos/{id}/comments": {summary: "Add a comment", tag: "Comments", body: commentInput{}, status: http.StatusCreated, response: Comment{}},
+	"GET /videos/{id}/comments/{commentId}/replies": {summary: "List replies to a comment", tag: "Comments",
+		query:    []apiParam{{"depth", "integer", "Nest this many further levels of replies (default 0)"}},
+		response: []Comment{}, page: listPage[Comment]{}},
+	"POST /videos/{id}/comments/{commentId}/replies": {summary: "Reply to a comment", tag: "Comments", body: commentInput{}, status: http.StatusCreated, response: Comment{}},
+	"PATCH /videos/{id}/comments/{commentId}":        {summary: "Edit one of the caller's comments", tag: "Comments", identity: true, body: commentEditInput{}, response: Comment{}},
+	"DELETE /videos/{id}/comments/{commentId}":       {summary: "Delete one of the caller's comments", tag: "Comments", identity: true, response: statusResponse{}},
+
+	"GET /notifications": {summary: "List the caller's notifications", tag: "Notifications", identity: true,
+		query: []apiParam{
+			{"unread", "boolean", "Only unread notifications"},
+			{"limit", "integer", "At most this many (default 50, at most 200)"},
+		},
+		response: notificationList{}},
+	"POST /notifications/read": {summary: "Mark notifications read, or all of them without a body", tag: "Notifications", identity: true,
+		body: notificationsReadInput{}, response: unreadCount{}},
+
+	"GET /videos/{id}/tags":          {summary: "List a video's tags", tag: "Tags", response: []VideoTag{}, page: listPage[VideoTag]{}},
+	"POST /videos/{id}/tags":         {summary: "Tag a video", tag: "Tags", identity: true, body: tagInput{}, status: http.StatusCreated, response: []VideoTag{}},
+	"DELETE /videos/{id}/tags/{tag}": {summary: "Untag a video", tag: "Tags", identity: true, response: []VideoTag{}},
+	"GET /tags":                      {summary: "List tags with video counts", tag: "Tags", response: []Tag{}, page: listPage[Tag]{}},
+	"PATCH /tags/{tag}":              {summary: "Rename a tag", tag: "Tags", admin: true, body: tagInput{}, response: Tag{}},
+	"DELETE /tags/{tag}":             {summary: "Delete a tag", tag: "Tags", admin: true, response: statusResponse{}},
+
+	"GET /shows":              {summary: "List shows", tag: "Shows", response: []Show{}, page: listPage[Show]{}},
+	"GET /shows/{id}":         {summary: "Get a show", tag: "Shows", response: Show{}},
+	"GET /shows/{id}/seasons": {summary: "List a show's seasons", tag: "Shows", response: []Season{}, page: listPage[Season]{}},
+	"GET /shows/{id}/seasons/{season}/episodes": {summary: "List a season's episodes", tag: "Shows", response: []Episode{}, page: listPage[Episode]{}},
+
+	"GET /admin/jobs": {summary: "List recent background jobs", tag: "Admin", admin: true,
+		query: []apiParam{
+			{"status", "string", "Only jobs in this status"},
+			{"type", "string", "Only jobs of this type"},
+			{"limit", "integer", "At most this many (default 100, at most 500)"},
+		},
+		response: jobList{}},
+	"POST /admin/jobs/{id}/cancel": {summary: "Cancel a pending or running job", tag: "Admin", admin: true, response: Job{}},
+	"GET /admin/comments": {summary: "List comments by moderation status", tag: "Admin", admin: true,
+		query: []apiParam{
+			{"status", "string", "pending (default), approved or rejected"},
+			{"hidden", "boolean", "List hidden comments instead"},
+		},
+		response: []Comment{}, page: listPage[Comment]{}},
+	"PATCH /admin/comments/{commentId}": {summary: "Approve, reject, hide or unhide a comment", tag: "Admin", admin: true, body: moderationInput{}, response: Comment{}},
+
+	"GET /playlists":                                 {summary: "List playlists visible to the caller", tag: "Playlists", response: []Playlist{}, page: listPage[Playlist]{}},
+	"POST /playlists":                                {summary: "Create a playlist", tag: "Playlists", identity: true, body: playlistInput{}, status: http.StatusCreated, response: Playlist{}},
+	"POST /playlists/preview":                        {summary: "Preview playlists built by grouping rules", tag: "Playlists", admin: true, body: GroupingConfig{}, response: groupingPreview{}},
+	"GET /playlists/{playlistId}":                    {summary: "Get a playlist", tag: "Playlists", response: Playlist{}},
+	"PATCH /playlists/{playlistId}":                  {summary: "Rename a playlist or change its visibility", tag: "Playlists", identity: true, body: playlistUpdateInput{}, response: Playlist{}},
+	"DELETE /playlists/{playlistId}":                 {summary: "Delete a playlist", tag: "Playlists", identity: true, response: statusResponse{}},
+	"POST /playlists/{playlistId}/items":             {summary: "Add a video to a playlist", tag: "Playlists", identity: true, body: playlistItemInput{}, response: Playlist{}},
+	"PUT /playlists/{playlistId}/items":              {summary: "Reorder a playlist", tag: "Playlists", identity: true, body: videoIDsInput{}, response: Playlist{}},
+	"DELETE /playlists/{playlistId}/items/{videoId}": {summary: "Remove a video from a playlist", tag: "Playlists", identity: true, response: Playlist{}},
+	"PUT /playlists/{playlistId}/order":              {summary: "Pin the order of an auto-generated playlist", tag: "Playlists", admin: true, body: videoIDsInput{}, response: Playlist{}},
+	"DELETE /playlists/{playlistId}/order":           {summary: "Unpin the order of an auto-generated playlist", tag: "Playlists", admin: true, response: Playlist{}},
+}
+
+// errorCodes lists every error code for the document
+var errorCodes = []string{
+	errorCodeInvalidID, errorCodeInvalidParameter, errorCodeInvalidBody, errorCodeValidation,
+	errorCodeCommentRejected, errorCodeIdentityRequired, errorCodeAdminRequired, errorCodeForbidden,
+	errorCodeNotFound, errorCodeMethodNotAllowed, errorCodeConflict, errorCodeRateLimited,
+	errorCodeUnavailable, errorCodeInternal,
+}
+
+var (
+	routePatternVar = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+	routeVar        = regexp.MustCompile(`\{(\w+)\}`)
+	pathWord        = regexp.MustCompile(`[A-Za-z0-9]+`)
+)
+
+// apiRoute is a method and path registered under /api
+type apiRoute struct {
+	method string
+	path   string
+}
+
+func (r apiRoute) key() string {
+	return r.method + " " + r.path
+}
+
+// apiRoutes lists the routes registered on the router under apiPrefix, in
+// registration order, with parameter patterns left out of the paths
+func apiRoutes(router *mux.Router) []apiRoute {
+	prefix := routePatternVar.ReplaceAllString(apiPrefix, "{$1}")
+	var routes []apiRoute
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods
+			return nil
+		}
+		path := strings.TrimPrefix(routePatternVar.ReplaceAllString(template, "{$1}"), prefix)
+		for _, method := range methods {
+			routes = append(routes, apiRoute{method: method, path: path})
+		}
+		return nil
+	})
+	return routes
+}
+
+// openAPISchemas collects the named schemas of a document as types are
+// referenced
+type openAPISchemas map[string]interface{}
+
+// openAPISchemer is implemented by types whose JSON form differs from their
+// Go kind
+type openAPISchemer interface {
+	openAPISchema() map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	schemerType    = reflect.TypeOf((*openAPISchemer)(nil)).Elem()
+)
+
+// openAPISchema accepts seconds or an HH:MM:SS.mmm string, like UnmarshalJSON
+func (chapterTime) openAPISchema() map[string]interface{} {
+	return map[string]interface{}{"oneOf": []interface{}{
+		map[string]interface{}{"type": "number"},
+		map[string]interface{}{"type": "string", "example": "01:02:03.500"},
+	}}
+}
+
+// openAPISchema is the field's type, which may be null to clear it
+func (patchField[T]) openAPISchema() map[string]interface{} {
+	return openAPISchemas{}.of(reflect.TypeOf((*T)(nil)))
+}
+
+// of returns the schema of values of type t, registering named structs in
+// the components
+func (s openAPISchemas) of(t reflect.Type) map[string]interface{} {
+	if t.Kind() != reflect.Ptr && t.Implements(schemerType) {
+		return reflect.Zero(t).Interface().(openAPISchemer).openAPISchema()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.of(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder for recursive types
+			s[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// schemaName names a struct's schema after its type, e.g. listPage[Video]
+// becomes VideoListPage
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.Index(name, "["); i >= 0 {
+		// Type arguments are package-qualified
+		var args strings.Builder
+		for _, arg := range strings.Split(name[i+1:len(name)-1], ",") {
+			args.WriteString(arg[strings.LastIndex(arg, ".")+1:])
+		}
+		name = args.String() + strings.ToUpper(name[:1]) + name[1:i]
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// object returns the schema of a struct as encoding/json writes it
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				addFields(f.Type)
+				continue
+			}
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.of(f.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	sort.Strings(required)
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// content returns the content map of a request or response
+func (s openAPISchemas) content(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case apiMedia:
+		content := make(map[string]interface{})
+		for _, media := range v {
+			schema := map[string]interface{}{"type": "string"}
+			if !strings.HasPrefix(media, "text/") {
+				schema["format"] = "binary"
+			}
+			content[media] = map[string]interface{}{"schema": schema}
+		}
+		return content
+	case apiOneOf:
+		var schemas []interface{}
+		for _, shape := range v {
+			schemas = append(schemas, s.of(reflect.TypeOf(shape)))
+		}
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"oneOf": schemas}}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(value))}}
+}
+
+// operation returns the OpenAPI operation object for a route in a version
+func (s openAPISchemas) operation(route apiRoute, op apiOperation, version string) map[string]interface{} {
+	query := op.query
+	if version != apiV1 && op.page != nil {
+		op.response = op.page
+		for _, p := range listPageParams {
+			if !hasParam(query, p.name) {
+				query = append(query, p)
+			}
+		}
+	}
+
+	parameters := []interface{}{}
+	for _, m := range routeVar.FindAllStringSubmatch(route.path, -1) {
+		schema := map[string]interface{}{"type": "string"}
+		if numericRouteParams[m[1]] {
+			schema = map[string]interface{}{"type": "integer", "minimum": 0}
+		}
+		parameters = append(parameters, map[string]interface{}{"name": m[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, p := range query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": p.name, "in": "query", "description": p.description,
+			"schema": map[string]interface{}{"type": p.kind},
+		})
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if op.response != nil {
+		success["content"] = s.content(op.response)
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default":            map[string]interface{}{"$ref": "#/components/responses/Error"},
+	}
+	if op.queued {
+		responses[strconv.Itoa(http.StatusAccepted)] = map[string]interface{}{
+			"description": mediaQueued,
+			"content":     s.content(statusResponse{}),
+		}
+	}
+
+	operation := map[string]interface{}{
+		"operationId": strings.ToLower(route.method) + operationName(route.path),
+		"summary":     op.summary,
+		"parameters":  parameters,
+		"responses":   responses,
+	}
+	if op.tag != "" {
+		operation["tags"] = []string{op.tag}
+	}
+	if op.body != nil {
+		operation["requestBody"] = map[string]interface{}{"required": true, "content": s.content(op.body)}
+	}
+	switch {
+	case op.admin:
+		operation["security"] = []interface{}{map[string]interface{}{"admin": []string{}}}
+	case op.identity:
+		operation["security"] = []interface{}{map[string]interface{}{"user": []string{}}}
+	}
+	return operation
+}
+
+func hasParam(params []apiParam, name string) bool {
+	for _, p := range params {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// operationName turns a path into the CamelCase part of an operationId, e.g.
+// /videos/{id}/comments into VideosIdComments
+func operationName(path string) string {
+	var name strings.Builder
+	for _, word := range pathWord.FindAllString(path, -1) {
+		name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return name.String()
+}
+
+// buildOpenAPI describes the routes registered on the router as a version of
+// the API serves them. It also returns the routes missing from
+// apiOperations, which are listed with no more than their path.
+func buildOpenAPI(router *mux.Router, version string) (map[string]interface{}, []string) {
+	schemas := openAPISchemas{}
+	paths := make(map[string]interface{})
+	var undocumented []string
+	for _, route := range apiRoutes(router) {
+		op, ok := apiOperations[route.key()]
+		if !ok {
+			undocumented = append(undocumented, route.key())
+		}
+		item, _ := paths[route.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[route.path] = item
+		}
+		item[strings.ToLower(route.method)] = schemas.operation(route, op, version)
+	}
+
+	apiErrorSchema := schemas.object(reflect.TypeOf(apiError{}))
+	apiErrorSchema["properties"].(map[string]interface{})["code"] = map[string]interface{}{"type": "string", "enum": errorCodes}
+	schemas["ApiError"] = apiErrorSchema
+	schemas["ErrorResponse"] = map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"error": map[string]interface{}{"$ref": "#/components/schemas/ApiError"}},
+		"required":             []string{"error"},
+		"additionalProperties": false,
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "StreamLite API",
+			"version":     version,
+			"description": "Every failed request returns an ErrorResponse; see the code enum for the error codes.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/api/" + version}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"user":  map[string]interface{}{"type": "apiKey", "in": "header", "name": userHeader},
+				"admin": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+			},
+		},
+	}, undocumented
+}
+
+// serveOpenAPI serves the document for the router in the version asked for,
+// built on first request once every route is registered
+func serveOpenAPI(router *mux.Router) http.HandlerFunc {
+	var mu sync.Mutex
+	documents := make(map[string][]byte)
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := requestAPIVersion(r)
+		mu.Lock()
+		document, ok := documents[version]
+		if !ok {
+			spec, _ := buildOpenAPI(router, version)
+			document, _ = json.Marshal(spec)
+			documents[version] = document
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAPIDocument returns a version's document as a client sees it
+func openAPIDocument(t *testing.T, version string) map[string]interface{} {
+	t.Helper()
+	spec, _ := buildOpenAPI(newRouter(), version)
+	return decodeDocument(t, spec)
+}
+
+func decodeDocument(t *testing.T, spec map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("document doesn't encode: %v", err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(data, &doc)
+	return doc
+}
+
+// schemaErrors checks a decoded JSON value against a schema, returning what
+// doesn't match. It understands the parts of OpenAPI the document uses.
+func schemaErrors(doc map[string]interface{}, schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{at + ": unknown schema " + ref}
+		}
+		return schemaErrors(doc, resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true || len(schema) == 0 {
+			return nil
+		}
+		return []string{at + ": null isn't allowed"}
+	}
+
+	var errs []string
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			errs = append(errs, schemaErrors(doc, s.(map[string]interface{}), value, at)...)
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		var matched bool
+		for _, s := range one {
+			if len(schemaErrors(doc, s.(map[string]interface{}), value, at)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, at+": matches none of oneOf")
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var found bool
+		for _, e := range enum {
+			found = found || e == value
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v isn't in the enum", at, value))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: %T isn't an object", at, value))
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				errs = append(errs, at+"."+name.(string)+": required but missing")
+			}
+		}
+		for name, v := range object {
+			if s, ok := properties[name].(map[string]interface{}); ok {
+				errs = append(errs, schemaErrors(doc, s, v, at+"."+name)...)
+			} else if extra, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errs = append(errs, schemaErrors(doc, extra, v, at+"."+name)...)
+			} else if schema["additionalProperties"] == false {
+				errs = append(errs, at+"."+name+": not in the schema")
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: %T isn't an array", at, value))
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range array {
+			errs = append(errs, schemaErrors(doc, items, v, at+"["+strconv.Itoa(i)+"]")...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			errs = append(errs, fmt.Sprintf("%s: %T isn't a string", at, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			errs = append(errs, fmt.Sprintf("%s: %v isn't an integer", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: %T isn't a number", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: %T isn't a boolean", at, value))
+		}
+	}
+	return errs
+}
+
+// sampleValue fills every field of a value of type t, so all of its JSON is
+// checked against the schema
+func sampleValue(t reflect.Type, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch {
+	case t == timeType:
+		v.Set(reflect.ValueOf(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+		return v
+	case t == rawMessageType:
+		v.SetBytes([]byte(`{"a":1}`))
+		return v
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if depth < 3 {
+			v.Set(sampleValue(t.Elem(), depth+1).Addr())
+		}
+	case reflect.Struct:
+		fillFields(v, depth)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(t, 0, 1))
+		if depth < 3 {
+			v.Set(reflect.Append(v, sampleValue(t.Elem(), depth+1)))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(sampleValue(t.Key(), depth+1), sampleValue(t.Elem(), depth+1))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(3)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("x")
+	}
+	return v
+}
+
+// fillFields fills the exported fields of a struct, including those promoted
+// from embedded structs of unexported types
+func fillFields(v reflect.Value, depth int) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.CanSet():
+			f.Set(sampleValue(f.Type(), depth+1))
+		case v.Type().Field(i).Anonymous && f.Kind() == reflect.Struct:
+			fillFields(f, depth)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	router := newRouter()
+	if _, undocumented := buildOpenAPI(router, apiV1); len(undocumented) > 0 {
+		t.Errorf("routes missing from apiOperations: %v", undocumented)
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range apiRoutes(router) {
+		registered[route.key()] = true
+	}
+	var stale []string
+	for key := range apiOperations {
+		if !registered[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	if len(stale) > 0 {
+		t.Errorf("apiOperations describes routes that aren't registered: %v", stale)
+	}
+}
+
+func TestOpenAPITypesMatchSchemas(t *testing.T) {
+	schemas := openAPISchemas{}
+	type sample struct {
+		key    string
+		schema map[string]interface{}
+		value  interface{}
+	}
+	var samples []sample
+	for key, op := range apiOperations {
+		for _, value := range []interface{}{op.body, op.response, op.page} {
+			shapes, ok := value.(apiOneOf)
+			if !ok {
+				shapes = apiOneOf{value}
+			}
+			for _, shape := range shapes {
+				if shape == nil {
+					continue
+				}
+				if _, ok := shape.(apiMedia); ok {
+					continue
+				}
+				samples = append(samples, sample{key, schemas.of(reflect.TypeOf(shape)), shape})
+			}
+		}
+	}
+
+	doc := decodeDocument(t, map[string]interface{}{"components": map[string]interface{}{"schemas": schemas}})
+	for _, s := range samples {
+		data, err := json.Marshal(sampleValue(reflect.TypeOf(s.value), 0).Interface())
+		if err != nil {
+			t.Errorf("%s: %T doesn't encode: %v", s.key, s.value, err)
+			continue
+		}
+		var decoded interface{}
+		json.Unmarshal(data, &decoded)
+		for _, e := range schemaErrors(doc, s.schema, decoded, fmt.Sprintf("%T", s.value)) {
+			t.Errorf("%s: %s", s.key, e)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Reactions = []string{"👍", "🎉"}
+	config.AdminToken = "secret"
+
+	router := newRouter()
+	docs := map[string]map[string]interface{}{
+		apiV1: openAPIDocument(t, apiV1),
+		apiV2: openAPIDocument(t, apiV2),
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		path   string
+		status int
+	}{
+		{"GET", "/api/openapi.json", "/openapi.json", http.StatusOK},
+		{"GET", "/api/v1/openapi.json", "/openapi.json", http.StatusOK},
+		{"GET", "/api/v2/openapi.json", "/openapi.json", http.StatusOK},
+		{"GET", "/api/reactions", "/reactions", http.StatusOK},
+		{"GET", "/api/v2/reactions", "/reactions", http.StatusOK},
+		{"GET", "/api/videos/abc", "/videos/{id}", http.StatusBadRequest},
+		{"GET", "/api/videos/1/comments/x/replies", "/videos/{id}/comments/{commentId}/replies", http.StatusBadRequest},
+		{"GET", "/api/notifications", "/notifications", http.StatusUnauthorized},
+		{"GET", "/api/admin/jobs", "/admin/jobs", http.StatusForbidden},
+		{"DELETE", "/api/reactions", "/reactions", http.StatusMethodNotAllowed},
+		{"DELETE", "/api/v2/reactions", "/reactions", http.StatusMethodNotAllowed},
+		{"GET", "/api/v2/videos/abc", "/videos/{id}", http.StatusBadRequest},
+		{"GET", "/api/nope", "", http.StatusNotFound},
+		{"GET", "/api/v3/videos", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(test.method, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s = %d; expected %d", test.method, test.url, rec.Code, test.status)
+			continue
+		}
+		checkDocumentedResponse(t, docs, test.method, test.url, test.path, rec)
+	}
+}
+
+// checkDocumentedResponse checks a response against the operation documented
+// for path in the version the URL was served under
+func checkDocumentedResponse(t *testing.T, docs map[string]map[string]interface{}, method, url, path string, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	doc := docs[apiV1]
+	if strings.HasPrefix(url, "/api/v2/") {
+		doc = docs[apiV2]
+	}
+	paths := doc["paths"].(map[string]interface{})
+
+	// Unknown routes and methods have no operation, but answer with the
+	// documented error
+	response := map[string]interface{}{"$ref": "#/components/responses/Error"}
+	item, _ := paths[path].(map[string]interface{})
+	if op, ok := item[strings.ToLower(method)].(map[string]interface{}); ok {
+		responses := op["responses"].(map[string]interface{})
+		if r, ok := responses[strconv.Itoa(rec.Code)].(map[string]interface{}); ok {
+			response = r
+		} else {
+			response = responses["default"].(map[string]interface{})
+		}
+	}
+	if ref, ok := response["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/responses/")
+		response = doc["components"].(map[string]interface{})["responses"].(map[string]interface{})[name].(map[string]interface{})
+	}
+	media, ok := response["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s %s: no JSON response documented for %d", method, url, rec.Code)
+		return
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Errorf("%s %s: response isn't JSON: %v", method, url, err)
+		return
+	}
+	for _, e := range schemaErrors(doc, media["schema"].(map[string]interface{}), body, "response") {
+		t.Errorf("%s %s: %s", method, url, e)
+	}
+}
+
+func TestOpenAPIHandlerResponses(t *testing.T) {
+	savedConfig, savedLogger := config, logger
+	defer func() { config, logger = savedConfig, savedLogger }()
+	logger = log.New(io.Discard, "", 0)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT EXISTS"):
+			return []string{"exists"}, [][]driver.Value{{true}}, nil
+		case strings.HasPrefix(strings.TrimSpace(query), "SELECT COUNT("):
+			return []string{"count"}, [][]driver.Value{{int64(1)}}, nil
+		case strings.Contains(query, "FROM comments"):
+			return make([]string, 13), [][]driver.Value{{int64(4), int64(3), nil, "Ann", "ann", "Hi", int64(12),
+				commentApproved, false, now, nil, int64(0), []byte(`{"👍": 2}`)}}, nil
+		case strings.Contains(query, "FROM user_playlists"):
+			return make([]string, 8), [][]driver.Value{{int64(1), "Week 1", "ann", visibilityShared, now, now, int64(60), []byte("{3}")}}, nil
+		case strings.Contains(query, "FROM auto_playlists"):
+			return make([]string, 8), [][]driver.Value{{"pl_0123456789ab", "Tutorial", "/videos", int64(60), now, now, false, []byte("{3}")}}, nil
+		case strings.Contains(query, "FROM videos v"):
+			return make([]string, 22), [][]driver.Value{{int64(3), "a.mp4", "/videos/a.mp4", "A", int64(0), int64(0), int64(60), int64(1024),
+				now, now, nil, nil, nil, "", []byte("{Drama}"), []byte("{}"), nil, nil, false, false, false, []byte("{}")}}, nil
+		}
+		t.Errorf("unexpected query: %s", query)
+		return nil, nil, nil
+	})
+
+	router := newRouter()
+	docs := map[string]map[string]interface{}{
+		apiV1: openAPIDocument(t, apiV1),
+		apiV2: openAPIDocument(t, apiV2),
+	}
+
+	tests := []struct {
+		url  string
+		path string
+	}{
+		{"/api/v1/videos", "/videos"},
+		{"/api/v2/videos", "/videos"},
+		{"/api/v1/videos/3", "/videos/{id}"},
+		{"/api/v1/videos/3/comments", "/videos/{id}/comments"},
+		{"/api/v2/videos/3/comments", "/videos/{id}/comments"},
+		{"/api/v1/playlists", "/playlists"},
+		{"/api/v2/playlists", "/playlists"},
+		{"/api/v1/playlists/up_1", "/playlists/{playlistId}"},
+		{"/api/v1/playlists/pl_0123456789ab", "/playlists/{playlistId}"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.url, nil)
+		req.Header.Set(userHeader, "ann")
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s = %d: %s", test.url, rec.Code, rec.Body.String())
+			continue
+		}
+		checkDocumentedResponse(t, docs, "GET", test.url, test.path, rec)
+	}
+}
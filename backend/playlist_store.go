@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// autoPlaylistColumns selects a materialized playlist with its items in
+// position order. The total duration is summed when read, so durations
+// probed after the scan are counted.
+const autoPlaylistColumns = `
+	SELECT p.id, p.name, p.directory, COALESCE(SUM(v.duration), 0), p.created_at, p.updated_at,
+		o.playlist_id IS NOT NULL,
+		COALESCE(array_agg(i.video_id ORDER BY i.position) FILTER (WHERE i.video_id IS NOT NULL), '{}')
+	FROM auto_playlists p
+	LEFT JOIN auto_playlist_items i ON i.playlist_id = p.id
+	LEFT JOIN videos v ON v.id = i.video_id
+	LEFT JOIN auto_playlist_order o ON o.playlist_id = p.id
+`
+
+// setPlaylistVideos fills the video list and the fields derived from it
+func setPlaylistVideos(p *Playlist, videoIDs pq.Int64Array) {
+	p.VideoIDs = make([]int, len(videoIDs))
+	for i, videoID := range videoIDs {
+		p.VideoIDs[i] = int(videoID)
+	}
+	p.VideoCount = len(p.VideoIDs)
+	if p.VideoCount > 0 {
+		p.ThumbnailID = p.VideoIDs[0]
+	}
+}
+
+// scanAutoPlaylist reads a row produced by autoPlaylistColumns
+func scanAutoPlaylist(row interface{ Scan(...interface{}) error }) (Playlist, error) {
+	var p Playlist
+	var videoIDs pq.Int64Array
+	if err := row.Scan(&p.ID, &p.Name, &p.Directory, &p.TotalDuration, &p.CreatedAt, &p.UpdatedAt, &p.OrderPinned, &videoIDs); err != nil {
+		return p, err
+	}
+
+	p.Source = playlistSourceAuto
+	setPlaylistVideos(&p, videoIDs)
+	return p, nil
+}
+
+// loadAutoPlaylists returns the materialized playlists on page, or every one
+// when page is nil
+func loadAutoPlaylists(page *pageParams) ([]Playlist, error) {
+	limit, args := page.limitClause(nil)
+	rows, err := db.Query(autoPlaylistColumns+`
+		GROUP BY p.id, o.playlist_id
+		ORDER BY p.name, p.id
+		`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		p, err := scanAutoPlaylist(rows)
+		if err != nil {
+			logger.Printf("Error scanning playlist: %v", err)
+			continue
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+// loadAutoPlaylist returns a single materialized playlist by its "pl_" ID
+func loadAutoPlaylist(id string) (Playlist, error) {
+	row := db.QueryRow(autoPlaylistColumns+`
+		WHERE p.id = $1
+		GROUP BY p.id, o.playlist_id
+	`, id)
+	return scanAutoPlaylist(row)
+}
+
+// materializeMu serializes playlist rebuilds triggered by scans and order pins
+var materializeMu sync.Mutex
+
+// samePlaylistContents reports whether a regrouped playlist matches the stored one
+func samePlaylistContents(stored, generated Playlist) bool {
+	if stored.Name != generated.Name || stored.Directory != generated.Directory ||
+		len(stored.VideoIDs) != len(generated.VideoIDs) {
+		return false
+	}
+	for i := range stored.VideoIDs {
+		if stored.VideoIDs[i] != generated.VideoIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// materializePlaylists regroups the library and writes the result to the
+// auto_playlists tables. Unchanged playlists are left alone so their
+// updated_at only moves when their contents do.
+func materializePlaylists() error {
+	materializeMu.Lock()
+	defer materializeMu.Unlock()
+
+	generated, err := generatePlaylists()
+	if err != nil {
+		return err
+	}
+
+	pins, err := loadPinnedOrders()
+	if err != nil {
+		return fmt.Errorf("loading pinned playlist orders: %w", err)
+	}
+	for i := range generated {
+		if pinned, ok := pins[generated[i].ID]; ok {
+			generated[i].VideoIDs = applyPinnedOrder(generated[i].VideoIDs, pinned)
+			generated[i].ThumbnailID = generated[i].VideoIDs[0]
+		}
+	}
+
+	stored, err := loadAutoPlaylists(nil)
+	if err != nil {
+		return fmt.Errorf("loading stored playlists: %w", err)
+	}
+	storedByID := make(map[string]Playlist, len(stored))
+	for _, p := range stored {
+		storedByID[p.ID] = p
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	written := 0
+	for _, p := range generated {
+		old, exists := storedByID[p.ID]
+		delete(storedByID, p.ID)
+		if exists && samePlaylistContents(old, p) {
+			continue
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO auto_playlists (id, name, directory)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO UPDATE
+			SET name = EXCLUDED.name, directory = EXCLUDED.directory, updated_at = CURRENT_TIMESTAMP
+		`, p.ID, p.Name, p.Directory); err != nil {
+			return fmt.Errorf("writing playlist %s: %w", p.ID, err)
+		}
+
+		if _, err := tx.Exec("DELETE FROM auto_playlist_items WHERE playlist_id = $1", p.ID); err != nil {
+			return fmt.Errorf("clearing items of playlist %s: %w", p.ID, err)
+		}
+		for position, videoID := range p.VideoIDs {
+			if _, err := tx.Exec(`
+				INSERT INTO auto_playlist_items (playlist_id, video_id, position)
+				VALUES ($1, $2, $3)
+			`, p.ID, videoID, position); err != nil {
+				return fmt.Errorf("writing item of playlist %s: %w", p.ID, err)
+			}
+		}
+		written++
+	}
+
+	// Anything left over no longer has enough matching videos
+	for id := range storedByID {
+		if _, err := tx.Exec("DELETE FROM auto_playlists WHERE id = $1", id); err != nil {
+			return fmt.Errorf("removing playlist %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing playlists: %w", err)
+	}
+
+	logger.Printf("Playlists materialized: %d total, %d written, %d removed", len(generated), written, len(storedByID))
+	return nil
+}
+
+// loadPinnedOrders returns the manually pinned video order for each playlist
+func loadPinnedOrders() (map[string][]int, error) {
+	rows, err := db.Query("SELECT playlist_id, video_ids FROM auto_playlist_order")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := make(map[string][]int)
+	for rows.Next() {
+		var id string
+		var videoIDs pq.Int64Array
+		if err := rows.Scan(&id, &videoIDs); err != nil {
+			return nil, err
+		}
+		order := make([]int, len(videoIDs))
+		for i, videoID := range videoIDs {
+			order[i] = int(videoID)
+		}
+		pins[id] = order
+	}
+	return pins, rows.Err()
+}
+
+// autoPlaylistForOrder loads the auto-generated playlist named in the route,
+// writing the error response when it returns false
+func autoPlaylistForOrder(w http.ResponseWriter, r *http.Request) (Playlist, bool) {
+	playlistID := mux.Vars(r)["playlistId"]
+	if strings.HasPrefix(playlistID, userPlaylistPrefix) {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Curated playlists are reordered through /items")
+		return Playlist{}, false
+	}
+
+	playlist, err := loadAutoPlaylist(playlistID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Playlist not found")
+		return Playlist{}, false
+	} else if err != nil {
+		logger.Printf("Error fetching playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlist")
+		return Playlist{}, false
+	}
+	return playlist, true
+}
+
+// writeAutoPlaylist reloads the playlist and writes it as the response
+func writeAutoPlaylist(w http.ResponseWriter, id string) {
+	playlist, err := loadAutoPlaylist(id)
+	if err != nil {
+		logger.Printf("Error reloading playlist %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlist")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func pinPlaylistOrder(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := autoPlaylistForOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var body videoIDsInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if !isPermutation(playlist.VideoIDs, body.VideoIDs) {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "video_ids must contain exactly the playlist's current videos")
+		return
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO auto_playlist_order (playlist_id, video_ids)
+		VALUES ($1, $2)
+		ON CONFLICT (playlist_id) DO UPDATE
+		SET video_ids = EXCLUDED.video_ids, updated_at = CURRENT_TIMESTAMP
+	`, playlist.ID, pq.Array(body.VideoIDs))
+	if err != nil {
+		logger.Printf("Error pinning playlist order: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to pin playlist order")
+		return
+	}
+
+	if err := materializePlaylists(); err != nil {
+		logger.Printf("Error materializing playlists: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to apply playlist order")
+		return
+	}
+
+	logger.Printf("Pinned order for playlist %s", playlist.ID)
+	writeAutoPlaylist(w, playlist.ID)
+}
+
+func unpinPlaylistOrder(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := autoPlaylistForOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM auto_playlist_order WHERE playlist_id = $1", playlist.ID); err != nil {
+		logger.Printf("Error unpinning playlist order: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to unpin playlist order")
+		return
+	}
+
+	if err := materializePlaylists(); err != nil {
+		logger.Printf("Error materializing playlists: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to apply playlist order")
+		return
+	}
+
+	logger.Printf("Unpinned order for playlist %s", playlist.ID)
+	writeAutoPlaylist(w, playlist.ID)
+}
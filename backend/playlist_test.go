@@ -22,6 +22,11 @@ func TestNormalizePlaylistName(t *testing.T) {
 		{"unique_video.mp4", "unique video"},
 		{"Video_Name_v3.mp4", "video name"},
 		{"test-video-1.mp4", "test video"},
+		{"Show.S01E03.mkv", "show"},
+		{"show_s02e10_the_finale.mkv", "show"},
+		{"Lecture Part 3.mp4", "lecture"},
+		{"lecture_part_12.mp4", "lecture"},
+		{"S01E01.mkv", "s01e01"},
 	}
 
 	for _, test := range tests {
@@ -31,3 +36,58 @@ func TestNormalizePlaylistName(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupPlaylists(t *testing.T) {
+	videos := []playlistVideo{
+		{ID: 1, Filename: "tutorial_2.mp4", Filepath: "/videos/course/tutorial_2.mp4", Duration: 60},
+		{ID: 2, Filename: "tutorial_1.mp4", Filepath: "/videos/course/tutorial_1.mp4", Duration: 30},
+		{ID: 3, Filename: "tutorial_3.mp4", Filepath: "/videos/other/tutorial_3.mp4", Duration: 10},
+		{ID: 4, Filename: "unique.mp4", Filepath: "/videos/course/unique.mp4", Duration: 5},
+	}
+
+	playlists := groupPlaylists(videos, defaultGroupingConfig())
+	if len(playlists) != 1 {
+		t.Fatalf("Expected 1 playlist, got %d", len(playlists))
+	}
+
+	p := playlists[0]
+	if p.Name != "Tutorial" || p.Directory != "/videos/course" || p.Source != playlistSourceAuto {
+		t.Errorf("Unexpected playlist: %+v", p)
+	}
+	if len(p.VideoIDs) != 2 || p.VideoIDs[0] != 2 || p.VideoIDs[1] != 1 {
+		t.Errorf("Expected video IDs [2 1], got %v", p.VideoIDs)
+	}
+	if p.TotalDuration != 90 {
+		t.Errorf("Expected total duration 90, got %d", p.TotalDuration)
+	}
+	if len(p.ID) != 15 || p.ID[:3] != "pl_" {
+		t.Errorf("Expected pl_ ID with 12 hex characters, got %q", p.ID)
+	}
+
+	// IDs are deterministic across regroupings
+	if again := groupPlaylists(videos, defaultGroupingConfig()); again[0].ID != p.ID {
+		t.Errorf("Playlist ID changed between runs: %q vs %q", p.ID, again[0].ID)
+	}
+}
+
+func TestSamePlaylistContents(t *testing.T) {
+	base := Playlist{Name: "Tutorial", Directory: "/videos", VideoIDs: []int{1, 2}, TotalDuration: 90}
+
+	same := base
+	if !samePlaylistContents(base, same) {
+		t.Error("Expected identical playlists to match")
+	}
+
+	reordered := base
+	reordered.VideoIDs = []int{2, 1}
+	if samePlaylistContents(base, reordered) {
+		t.Error("Expected reordered playlist to differ")
+	}
+
+	// Durations are summed when read, so probing a video doesn't rewrite it
+	longer := base
+	longer.TotalDuration = 120
+	if !samePlaylistContents(base, longer) {
+		t.Error("Expected changed duration alone to match")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// A preview is a handful of short clips taken from evenly spaced points
+	previewSegments       = 6
+	previewSegmentSeconds = 1
+	previewFrameRate      = 12
+	previewWidth          = 320
+	previewHeight         = 180
+)
+
+// previewFormats maps the formats a preview can be requested in to their
+// content types
+var previewFormats = map[string]string{
+	"webp": "image/webp",
+	"mp4":  "video/mp4",
+}
+
+// previewEncoders holds the ffmpeg output options for each preview format
+var previewEncoders = map[string][]string{
+	"webp": {"-c:v", "libwebp", "-lossless", "0", "-quality", "60", "-loop", "0"},
+	"mp4":  {"-c:v", "libx264", "-preset", "veryfast", "-crf", "30", "-pix_fmt", "yuv420p", "-movflags", "+faststart"},
+}
+
+// previewOffsets returns the second at which each clip starts. Short videos
+// get fewer clips so they don't overlap.
+func previewOffsets(duration int) []int {
+	if duration <= 0 {
+		return nil
+	}
+	count := previewSegments
+	if fit := duration / (2 * previewSegmentSeconds); fit < count {
+		count = fit
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	offsets := make([]int, count)
+	for i := range offsets {
+		offsets[i] = duration * (i + 1) / (count + 1)
+	}
+	return offsets
+}
+
+// previewFilter scales each clip to the same frame and joins them
+func previewFilter(clips int) string {
+	var b strings.Builder
+	for i := 0; i < clips; i++ {
+		fmt.Fprintf(&b, "[%d:v]fps=%d,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,setpts=PTS-STARTPTS[v%d];",
+			i, previewFrameRate, previewWidth, previewHeight, previewWidth, previewHeight, i)
+	}
+	for i := 0; i < clips; i++ {
+		fmt.Fprintf(&b, "[v%d]", i)
+	}
+	fmt.Fprintf(&b, "concat=n=%d:v=1:a=0[out]", clips)
+	return b.String()
+}
+
+func previewPath(videoID int, format string) string {
+	return filepath.Join(videoCacheDir(videoID), "preview."+format)
+}
+
+// previewStampPath records which version of the video a preview was made from
+func previewStampPath(videoID int, format string) string {
+	return previewPath(videoID, format) + ".json"
+}
+
+// loadPreviewStamp reads the source stamp of a video's cached preview
+func loadPreviewStamp(videoID int, format string) (sourceStamp, error) {
+	var stamp sourceStamp
+	data, err := os.ReadFile(previewStampPath(videoID, format))
+	if err != nil {
+		return stamp, err
+	}
+	err = json.Unmarshal(data, &stamp)
+	return stamp, err
+}
+
+// previewJobPayload is the payload of a preview job
+type previewJobPayload struct {
+	Format string `json:"format"`
+}
+
+// queuePreview schedules preview generation for a video
+func queuePreview(videoID int, format string) (bool, error) {
+	return enqueueJob("preview", videoID, previewJobPayload{Format: format}, jobPriorityRequest)
+}
+
+// runPreviewJob is the job handler for preview generation
+func runPreviewJob(ctx context.Context, job Job) error {
+	videoID, err := jobVideoID(job)
+	if err != nil {
+		return err
+	}
+	var payload previewJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("reading payload: %w", err)
+	}
+	if _, ok := previewFormats[payload.Format]; !ok {
+		return fmt.Errorf("unknown preview format %q", payload.Format)
+	}
+	return generatePreview(ctx, videoID, payload.Format)
+}
+
+// generatePreview renders a video's animated preview with ffmpeg, limited to
+// PREVIEW_THREADS threads and PREVIEW_TIMEOUT seconds
+func generatePreview(ctx context.Context, videoID int, format string) error {
+	var videoPath string
+	var duration int
+	if err := db.QueryRow("SELECT filepath, duration FROM videos WHERE id = $1", videoID).Scan(&videoPath, &duration); err != nil {
+		return fmt.Errorf("loading video: %w", err)
+	}
+	offsets := previewOffsets(duration)
+	if len(offsets) == 0 {
+		return fmt.Errorf("duration unknown")
+	}
+
+	fileInfo, err := os.Stat(videoPath)
+	if err != nil {
+		return fmt.Errorf("video not accessible: %w", err)
+	}
+	if stamp, err := loadPreviewStamp(videoID, format); err == nil && stamp.current(fileInfo) {
+		return nil
+	}
+
+	finalPath := previewPath(videoID, format)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	tmpPath := finalPath + ".tmp"
+	defer os.Remove(tmpPath)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.PreviewTimeout)*time.Second)
+	defer cancel()
+
+	threads := strconv.Itoa(config.PreviewThreads)
+	args := []string{"-v", "error", "-filter_complex_threads", threads}
+	for _, offset := range offsets {
+		args = append(args,
+			"-threads", threads,
+			"-ss", strconv.Itoa(offset),
+			"-t", strconv.Itoa(previewSegmentSeconds),
+			"-i", videoPath)
+	}
+	args = append(args, "-filter_complex", previewFilter(len(offsets)), "-map", "[out]", "-an", "-threads", threads)
+	args = append(args, previewEncoders[format]...)
+	args = append(args, "-f", format, "-y", tmpPath)
+
+	output, err := lowPriorityCommand(ctx, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return fmt.Errorf("moving preview into place: %w", err)
+	}
+	stamp, err := json.Marshal(newSourceStamp(fileInfo))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(previewStampPath(videoID, format), stamp, 0644); err != nil {
+		return fmt.Errorf("writing preview stamp: %w", err)
+	}
+	return nil
+}
+
+// getPreview serves a video's animated hover preview as WebP (the default) or
+// MP4 (?format=mp4). Like the trickplay index, a missing or stale preview is
+// queued for generation and 202 Accepted is returned.
+func getPreview(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "webp"
+	}
+	contentType, ok := previewFormats[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid preview format")
+		return
+	}
+
+	videoID, fileInfo, ok := videoFileInfo(w, r)
+	if !ok {
+		return
+	}
+
+	if stamp, err := loadPreviewStamp(videoID, format); err == nil && stamp.current(fileInfo) {
+		f, err := os.Open(previewPath(videoID, format))
+		if err == nil {
+			defer f.Close()
+			if info, err := f.Stat(); err == nil {
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Cache-Control", "public, max-age=86400")
+				http.ServeContent(w, r, filepath.Base(f.Name()), info.ModTime(), f)
+				return
+			}
+		}
+	}
+
+	if !durationKnown(w, videoID) {
+		return
+	}
+	queueMediaResponse(w, videoID, "Preview", func() (bool, error) {
+		return queuePreview(videoID, format)
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewOffsets(t *testing.T) {
+	tests := []struct {
+		duration int
+		expected []int
+	}{
+		{0, nil},
+		{1, []int{0}},
+		{5, []int{1, 3}},
+		{700, []int{100, 200, 300, 400, 500, 600}},
+	}
+
+	for _, test := range tests {
+		offsets := previewOffsets(test.duration)
+		if !reflect.DeepEqual(offsets, test.expected) {
+			t.Errorf("previewOffsets(%d) = %v; expected %v", test.duration, offsets, test.expected)
+		}
+	}
+}
+
+func TestPreviewFilter(t *testing.T) {
+	filter := previewFilter(3)
+
+	if !strings.HasSuffix(filter, "[v0][v1][v2]concat=n=3:v=1:a=0[out]") {
+		t.Errorf("Expected clips to be concatenated, got %q", filter)
+	}
+	for _, input := range []string{"[0:v]", "[1:v]", "[2:v]"} {
+		if strings.Count(filter, input) != 1 {
+			t.Errorf("Expected %s to be scaled once in %q", input, filter)
+		}
+	}
+	if strings.Contains(filter, "[3:v]") {
+		t.Errorf("Expected only 3 inputs in %q", filter)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds a single ffprobe run
+const probeTimeout = 30 * time.Second
+
+// probeStream is one stream as reported by ffprobe
+type probeStream struct {
+	Index       int               `json:"index"`
+	CodecName   string            `json:"codec_name"`
+	CodecType   string            `json:"codec_type"`
+	Disposition map[string]int    `json:"disposition"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// probeResult is the part of ffprobe's JSON output StreamLite uses
+type probeResult struct {
+	Streams  []probeStream  `json:"streams"`
+	Chapters []probeChapter `json:"chapters"`
+	Format   struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+var (
+	ffprobeOnce      sync.Once
+	ffprobeAvailable bool
+)
+
+// probeAvailable reports whether ffprobe can be run, logging once when it can't
+func probeAvailable() bool {
+	ffprobeOnce.Do(func() {
+		if _, err := exec.LookPath(config.FFprobePath); err != nil {
+			logger.Printf("Warning: %s not found, skipping media probing (durations and embedded subtitles): %v", config.FFprobePath, err)
+			return
+		}
+		ffprobeAvailable = true
+	})
+	return ffprobeAvailable
+}
+
+// probeFile runs ffprobe on a video
+func probeFile(ctx context.Context, path string) (probeResult, error) {
+	var result probeResult
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, config.FFprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		path,
+	).Output()
+	if err != nil {
+		return result, fmt.Errorf("running ffprobe: %w", err)
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return result, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+	return result, nil
+}
+
+// duration returns the container duration in whole seconds
+func (p probeResult) duration() int {
+	seconds, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return int(math.Round(seconds))
+}
+
+// queueProbe schedules probing of a new or changed file, or one that has
+// never been probed
+func queueProbe(videoID int) {
+	if !probeAvailable() {
+		return
+	}
+	if _, err := enqueueJob("probe", videoID, nil, jobPriorityScan); err != nil {
+		logger.Printf("Error queueing probe for video ID %d: %v", videoID, err)
+	}
+}
+
+// runProbeJob reads a video's duration, embedded subtitles and chapters, then
+// queues seek previews when TRICKPLAY_PREGENERATE is set
+func runProbeJob(ctx context.Context, job Job) error {
+	videoID, err := jobVideoID(job)
+	if err != nil {
+		return err
+	}
+	var path string
+	if err := db.QueryRow("SELECT filepath FROM videos WHERE id = $1", videoID).Scan(&path); err != nil {
+		return fmt.Errorf("loading video: %w", err)
+	}
+
+	result, err := probeFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE videos SET duration = $1, probed_at = CURRENT_TIMESTAMP WHERE id = $2",
+		result.duration(), videoID); err != nil {
+		return fmt.Errorf("saving probe results: %w", err)
+	}
+
+	syncEmbeddedSubtitles(videoID, path, result.Streams)
+	syncEmbeddedChapters(videoID, result.Chapters)
+	publishVideo(eventVideoUpdated, videoID)
+
+	if config.TrickplayPregenerate && result.duration() > 0 {
+		if _, err := queueTrickplay(videoID, jobPriorityScan); err != nil {
+			logger.Printf("Error queueing trickplay for video ID %d: %v", videoID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate-limited route groups, configurable with RATE_LIMITS
+const (
+	rateLimitComment  = "comment"
+	rateLimitLike     = "like"
+	rateLimitReaction = "reaction"
+	rateLimitView     = "view"
+	rateLimitRefresh  = "refresh"
+)
+
+// rateLimit allows count requests per period, in bursts of up to count. A
+// zero count turns the limit off.
+type rateLimit struct {
+	count  int
+	period time.Duration
+}
+
+// defaultRateLimits apply to each client IP and, separately, to each X-User.
+// Refreshing is far stricter since every request rescans the whole library.
+var defaultRateLimits = map[string]rateLimit{
+	rateLimitComment:  {count: 10, period: time.Minute},
+	rateLimitLike:     {count: 30, period: time.Minute},
+	rateLimitReaction: {count: 60, period: time.Minute},
+	rateLimitView:     {count: 30, period: time.Minute},
+	rateLimitRefresh:  {count: 2, period: 10 * time.Minute},
+}
+
+// parseRateLimits reads RATE_LIMITS, e.g. "comment=5/1m,refresh=1/1h", over
+// the defaults. A bare unit means one of it ("30/m"). Unknown groups and
+// malformed entries are skipped.
+func parseRateLimits(value string) map[string]rateLimit {
+	limits := make(map[string]rateLimit)
+	for name, limit := range defaultRateLimits {
+		limits[name] = limit
+	}
+	for _, entry := range strings.Split(value, ",") {
+		name, spec, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		count, per, found := strings.Cut(strings.TrimSpace(spec), "/")
+		if _, known := defaultRateLimits[name]; !known || !found {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 0 {
+			continue
+		}
+		per = strings.TrimSpace(per)
+		if per != "" && !strings.ContainsAny(per[:1], "0123456789") {
+			per = "1" + per
+		}
+		period, err := time.ParseDuration(per)
+		if err != nil || period <= 0 {
+			continue
+		}
+		limits[name] = rateLimit{count: n, period: period}
+	}
+	return limits
+}
+
+// tokenBucket holds what is left of one client's burst
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per client key for one route group
+type rateLimiter struct {
+	limit rateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// take spends a token from the bucket of every key, or from none of them if
+// any is empty, in which case it returns how long until they all have one
+func (l *rateLimiter) take(keys []string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	capacity := float64(l.limit.count)
+	perToken := l.limit.period.Seconds() / capacity
+	var wait time.Duration
+	buckets := make([]*tokenBucket, len(keys))
+	for i, key := range keys {
+		b := l.buckets[key]
+		if b == nil {
+			b = &tokenBucket{tokens: capacity, updated: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()/perToken)
+		b.updated = now
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) * perToken * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+// sweep forgets buckets idle long enough to have refilled, at most once a
+// period
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.limit.period {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.limit.period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the caller's address. Behind a reverse proxy every
+// request comes from the proxy, so with TRUST_PROXY the X-Real-IP header it
+// sets is used instead.
+func clientIP(r *http.Request) string {
+	if config.TrustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimited wraps a handler so each client IP, and each X-User, is held to
+// the group's limit. Admin requests aren't limited.
+func rateLimited(group string, next http.HandlerFunc) http.HandlerFunc {
+	limit := config.RateLimits[group]
+	if limit.count == 0 {
+		return next
+	}
+	limiter := newRateLimiter(limit)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAdmin(r) {
+			next(w, r)
+			return
+		}
+		keys := []string{"ip:" + clientIP(r)}
+		if user := requestUser(r); user != "" {
+			keys = append(keys, "user:"+user)
+		}
+		if wait := limiter.take(keys, time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tooManyRequests writes a 429 response telling the client when to retry
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeErrorDetails(w, http.StatusTooManyRequests, errorCodeRateLimited, fmt.Sprintf("Too many requests, try again in %d seconds", seconds),
+		map[string]int{"retry_after": seconds})
+}
+
+// cooldown lets one run of something expensive start at a time, and only
+// once period has passed since the last run finished
+type cooldown struct {
+	period time.Duration
+
+	mu      sync.Mutex
+	running bool
+	until   time.Time
+}
+
+// refreshCooldown spaces out library rescans requested through the API
+var refreshCooldown = &cooldown{}
+
+// start reports how long until a run may start, or 0 after marking one as
+// started. While a run is going the full period is suggested.
+func (c *cooldown) start(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return c.period
+	}
+	if now.Before(c.until) {
+		return c.until.Sub(now)
+	}
+	c.running = true
+	return 0
+}
+
+// finish marks the run as over and starts the cooldown
+func (c *cooldown) finish(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+	c.until = now.Add(c.period)
+}
+
+// coolingDown wraps a handler so it runs once at a time and not again until
+// the cooldown has passed, for everyone
+func coolingDown(c *cooldown, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait := c.start(time.Now()); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+		defer func() { c.finish(time.Now()) }()
+		next(w, r)
+	}
+}
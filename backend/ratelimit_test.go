@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits := parseRateLimits("comment=5/1m, like=100/h,view=0/1s,refresh=abc/1m,unknown=1/1m,reaction=3")
+	expected := map[string]rateLimit{
+		rateLimitComment:  {count: 5, period: time.Minute},
+		rateLimitLike:     {count: 100, period: time.Hour},
+		rateLimitReaction: defaultRateLimits[rateLimitReaction],
+		rateLimitView:     {count: 0, period: time.Second},
+		rateLimitRefresh:  defaultRateLimits[rateLimitRefresh],
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("parseRateLimits = %v; expected %v", limits, expected)
+	}
+
+	if limits := parseRateLimits(""); !reflect.DeepEqual(limits, defaultRateLimits) {
+		t.Errorf("parseRateLimits(\"\") = %v; expected the defaults", limits)
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	l := newRateLimiter(rateLimit{count: 2, period: time.Minute})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait := l.take([]string{"ip:a"}, now); wait != 0 {
+			t.Fatalf("request %d waited %v; expected the burst to allow it", i+1, wait)
+		}
+	}
+	if wait := l.take([]string{"ip:a"}, now); wait != 30*time.Second {
+		t.Errorf("third request waited %v; expected 30s", wait)
+	}
+	if wait := l.take([]string{"ip:b"}, now); wait != 0 {
+		t.Errorf("another client waited %v; expected its own bucket", wait)
+	}
+	if wait := l.take([]string{"ip:a"}, now.Add(30*time.Second)); wait != 0 {
+		t.Errorf("request after refilling waited %v", wait)
+	}
+}
+
+func TestRateLimiterTakeEveryKey(t *testing.T) {
+	l := newRateLimiter(rateLimit{count: 1, period: time.Minute})
+	now := time.Now()
+
+	if wait := l.take([]string{"ip:a", "user:alice"}, now); wait != 0 {
+		t.Fatalf("first request waited %v", wait)
+	}
+	// A new IP doesn't help a user who is out of tokens, and the refused
+	// request doesn't spend the new IP's token
+	if wait := l.take([]string{"ip:b", "user:alice"}, now); wait == 0 {
+		t.Error("user was allowed past their limit from another IP")
+	}
+	if wait := l.take([]string{"ip:b", "user:bob"}, now); wait != 0 {
+		t.Errorf("refused request spent a token: waited %v", wait)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(rateLimit{count: 1, period: time.Minute})
+	now := time.Now()
+	l.take([]string{"ip:a"}, now)
+	l.take([]string{"ip:b"}, now.Add(time.Minute))
+	if _, ok := l.buckets["ip:a"]; ok {
+		t.Error("idle bucket was kept after refilling")
+	}
+	if _, ok := l.buckets["ip:b"]; !ok {
+		t.Error("active bucket was swept")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.RateLimits = map[string]rateLimit{rateLimitLike: {count: 1, period: time.Minute}}
+	config.AdminToken = "secret"
+
+	handler := rateLimited(rateLimitLike, func(w http.ResponseWriter, r *http.Request) {})
+	request := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/videos/1/like", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := request("", ""); rec.Code != http.StatusOK {
+		t.Fatalf("first request got %d", rec.Code)
+	}
+	rec := request("", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request got %d; expected 429", rec.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "60" {
+		t.Errorf("Retry-After = %q; expected 60", retry)
+	}
+	if e := decodeError(t, rec); e.Code != errorCodeRateLimited {
+		t.Errorf("code = %q; expected %q", e.Code, errorCodeRateLimited)
+	}
+	if rec := request("Authorization", "Bearer secret"); rec.Code != http.StatusOK {
+		t.Errorf("admin request got %d; expected no limit", rec.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	saved := config.TrustProxy
+	defer func() { config.TrustProxy = saved }()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:51234"
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+
+	config.TrustProxy = false
+	if ip := clientIP(req); ip != "10.0.0.2" {
+		t.Errorf("clientIP = %q; expected the remote address when the proxy isn't trusted", ip)
+	}
+	config.TrustProxy = true
+	if ip := clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("clientIP = %q; expected X-Real-IP behind a trusted proxy", ip)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	c := &cooldown{period: time.Minute}
+	now := time.Now()
+
+	if wait := c.start(now); wait != 0 {
+		t.Fatalf("first run waited %v", wait)
+	}
+	if wait := c.start(now); wait != time.Minute {
+		t.Errorf("run during another waited %v; expected the full period", wait)
+	}
+	c.finish(now.Add(10 * time.Second))
+	if wait := c.start(now.Add(40 * time.Second)); wait != 30*time.Second {
+		t.Errorf("run during the cooldown waited %v; expected 30s", wait)
+	}
+	if wait := c.start(now.Add(70 * time.Second)); wait != 0 {
+		t.Errorf("run after the cooldown waited %v", wait)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// defaultReactions is the reaction set used when REACTIONS is unset
+const defaultReactions = "👍,❤️,😂,😮,😢,🎉"
+
+// maxReactionLength matches the reaction columns
+const maxReactionLength = 32
+
+// reactionTarget is something that can be reacted to: each kind keeps one
+// row per identity and reaction in its own table
+type reactionTarget struct {
+	table  string
+	column string
+}
+
+var (
+	videoReactions   = reactionTarget{table: "video_reactions", column: "video_id"}
+	commentReactions = reactionTarget{table: "comment_reactions", column: "comment_id"}
+)
+
+// reactionSummary is the response of the reaction endpoints: counts for
+// every reaction used on the target and the ones the caller has added
+type reactionSummary struct {
+	Reactions map[string]int `json:"reactions"`
+	Mine      []string       `json:"mine"`
+}
+
+// parseReactions reads REACTIONS, a comma-separated list of emoji, keeping
+// their order and dropping duplicates and empty or overlong entries
+func parseReactions(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		value = defaultReactions
+	}
+	var reactions []string
+	seen := make(map[string]bool)
+	for _, reaction := range strings.Split(value, ",") {
+		reaction = strings.TrimSpace(reaction)
+		if reaction == "" || len(reaction) > maxReactionLength || seen[reaction] {
+			continue
+		}
+		seen[reaction] = true
+		reactions = append(reactions, reaction)
+	}
+	return reactions
+}
+
+// validReaction reports whether a reaction is in the configured set
+func validReaction(reaction string) bool {
+	for _, r := range config.Reactions {
+		if r == reaction {
+			return true
+		}
+	}
+	return false
+}
+
+// countsColumn returns the SQL expression aggregating the reactions on the
+// target whose ID is idExpr as a JSON object of counts, read with
+// decodeReactions
+func (t reactionTarget) countsColumn(idExpr string) string {
+	return `COALESCE((SELECT json_object_agg(x.reaction, x.n) FROM (SELECT reaction, COUNT(*) AS n FROM ` +
+		t.table + ` WHERE ` + t.column + ` = ` + idExpr + ` GROUP BY reaction) x), '{}')`
+}
+
+// totalColumn returns the SQL expression counting every reaction on the
+// target whose ID is idExpr
+func (t reactionTarget) totalColumn(idExpr string) string {
+	return `(SELECT COUNT(*) FROM ` + t.table + ` WHERE ` + t.column + ` = ` + idExpr + `)`
+}
+
+// decodeReactions reads a column selected with countsColumn
+func decodeReactions(data []byte) map[string]int {
+	counts := make(map[string]int)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		logger.Printf("Error decoding reaction counts: %v", err)
+	}
+	return counts
+}
+
+// reactionTotal adds up reaction counts
+func reactionTotal(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// summary loads the reaction counts on a target and the caller's reactions
+func (t reactionTarget) summary(id int, user string) (reactionSummary, error) {
+	s := reactionSummary{Mine: []string{}}
+	var counts []byte
+	if err := db.QueryRow(`SELECT `+t.countsColumn("$1"), id).Scan(&counts); err != nil {
+		return s, err
+	}
+	s.Reactions = decodeReactions(counts)
+
+	if user == "" {
+		return s, nil
+	}
+	rows, err := db.Query(`SELECT reaction FROM `+t.table+` WHERE `+t.column+` = $1 AND identity = $2 ORDER BY created_at`, id, user)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reaction string
+		if err := rows.Scan(&reaction); err != nil {
+			return s, err
+		}
+		s.Mine = append(s.Mine, reaction)
+	}
+	return s, rows.Err()
+}
+
+// toggle adds the caller's reaction to a target, or removes it if it was
+// already there. active, when set, adds or removes it explicitly instead.
+func (t reactionTarget) toggle(id int, user, reaction string, active *bool) error {
+	if active == nil {
+		result, err := db.Exec(`DELETE FROM `+t.table+` WHERE `+t.column+` = $1 AND identity = $2 AND reaction = $3`, id, user, reaction)
+		if err != nil {
+			return err
+		}
+		if removed, _ := result.RowsAffected(); removed > 0 {
+			return nil
+		}
+		add := true
+		active = &add
+	}
+
+	if *active {
+		_, err := db.Exec(`INSERT INTO `+t.table+` (`+t.column+`, identity, reaction) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			id, user, reaction)
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM `+t.table+` WHERE `+t.column+` = $1 AND identity = $2 AND reaction = $3`, id, user, reaction)
+	return err
+}
+
+// getReactionSet lists the configured reactions
+func getReactionSet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config.Reactions)
+}
+
+// getVideoReactions returns a video's reaction counts and the caller's reactions
+func getVideoReactions(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	writeReactionSummary(w, r, videoReactions, videoID)
+}
+
+// getCommentReactions returns a comment's reaction counts and the caller's reactions
+func getCommentReactions(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := commentFromRoute(w, r, videoID)
+	if !ok {
+		return
+	}
+	writeReactionSummary(w, r, commentReactions, commentID)
+}
+
+// toggleVideoReaction adds or removes the caller's reaction on a video
+func toggleVideoReaction(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	if summary, ok := toggleReaction(w, r, videoReactions, videoID); ok {
+		events.publish(eventReactionsUpdated, videoID, map[string]interface{}{
+			"video_id": videoID, "reactions": summary.Reactions,
+		})
+	}
+}
+
+// toggleCommentReaction adds or removes the caller's reaction on a comment
+func toggleCommentReaction(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	commentID, ok := commentFromRoute(w, r, videoID)
+	if !ok {
+		return
+	}
+	if summary, ok := toggleReaction(w, r, commentReactions, commentID); ok {
+		events.publish(eventReactionsUpdated, videoID, map[string]interface{}{
+			"video_id": videoID, "comment_id": commentID, "reactions": summary.Reactions,
+		})
+	}
+}
+
+// toggleReaction applies a reaction request body ({"reaction", "active"}) to
+// a target and writes the updated summary. It returns false after writing
+// an error response.
+func toggleReaction(w http.ResponseWriter, r *http.Request, t reactionTarget, id int) (reactionSummary, bool) {
+	user := requestUser(r)
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return reactionSummary{}, false
+	}
+
+	var body reactionInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return reactionSummary{}, false
+	}
+	if !validReaction(body.Reaction) {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Unknown reaction")
+		return reactionSummary{}, false
+	}
+
+	if err := t.toggle(id, user, body.Reaction, body.Active); err != nil {
+		logger.Printf("Error updating %s: %v", t.table, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update reaction")
+		return reactionSummary{}, false
+	}
+	summary, err := t.summary(id, user)
+	if err != nil {
+		logger.Printf("Error loading %s: %v", t.table, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch reactions")
+		return reactionSummary{}, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+	return summary, true
+}
+
+func writeReactionSummary(w http.ResponseWriter, r *http.Request, t reactionTarget, id int) {
+	summary, err := t.summary(id, requestUser(r))
+	if err != nil {
+		logger.Printf("Error loading %s: %v", t.table, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch reactions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
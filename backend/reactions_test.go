@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReactions(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"", []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}},
+		{"  ", []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}},
+		{"👍,👎", []string{"👍", "👎"}},
+		{" 🔥 , ,🔥,👀 ", []string{"🔥", "👀"}},
+		{"ok,this-reaction-name-is-far-too-long-to-store", []string{"ok"}},
+	}
+
+	for _, test := range tests {
+		if result := parseReactions(test.value); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseReactions(%q) = %v; expected %v", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestValidReaction(t *testing.T) {
+	saved := config.Reactions
+	defer func() { config.Reactions = saved }()
+	config.Reactions = []string{"👍", "🎉"}
+
+	tests := map[string]bool{
+		"👍": true,
+		"🎉": true,
+		"👎": false,
+		"":  false,
+	}
+	for reaction, expected := range tests {
+		if result := validReaction(reaction); result != expected {
+			t.Errorf("validReaction(%q) = %v; expected %v", reaction, result, expected)
+		}
+	}
+}
+
+func TestDecodeReactions(t *testing.T) {
+	counts := decodeReactions([]byte(`{"👍": 3, "🎉": 1}`))
+	if !reflect.DeepEqual(counts, map[string]int{"👍": 3, "🎉": 1}) {
+		t.Errorf("decodeReactions = %v; expected 👍 3 and 🎉 1", counts)
+	}
+	if total := reactionTotal(counts); total != 4 {
+		t.Errorf("reactionTotal(%v) = %d; expected 4", counts, total)
+	}
+
+	if empty := decodeReactions([]byte(`{}`)); empty == nil || len(empty) != 0 {
+		t.Errorf("decodeReactions({}) = %#v; expected an empty map", empty)
+	}
+}
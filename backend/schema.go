@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+)
+
+// schema creates every table, column and index the server uses. Each
+// statement is idempotent, so it is applied on every start: new databases
+// are created and existing ones gain whatever later versions added.
+//
+//go:embed schema.sql
+var schema string
+
+// applySchema brings the database up to date with schema.sql
+func applySchema() error {
+	_, err := db.Exec(schema)
+	return err
+}
@@ -12,6 +12,142 @@ CREATE TABLE IF NOT EXISTS videos (
     modified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Create shows table for TV-style series detected from paths
+CREATE TABLE IF NOT EXISTS shows (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(500) NOT NULL,
+    name_key VARCHAR(500) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Link videos to their show, season and episode
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS show_id INTEGER REFERENCES shows(id) ON DELETE SET NULL;
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS season_number INTEGER;
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS episode_number INTEGER;
+
+-- Add metadata read from sidecar files (.nfo / .json next to each video)
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS cast_members TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS release_date DATE;
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS thumbnail_path VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS sidecar_signature TEXT NOT NULL DEFAULT '';
+
+-- Record when ffprobe last read the file (NULL until the first probe)
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS probed_at TIMESTAMP;
+
+-- Create manual metadata overrides. NULL means "not overridden", so scans
+-- and sidecar updates keep filling in the scanned values underneath.
+CREATE TABLE IF NOT EXISTS video_overrides (
+    video_id INTEGER PRIMARY KEY REFERENCES videos(id) ON DELETE CASCADE,
+    title VARCHAR(500),
+    description TEXT,
+    sort_order INTEGER,
+    updated_by VARCHAR(100) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create audit trail of metadata edits
+CREATE TABLE IF NOT EXISTS video_audit_log (
+    id SERIAL PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    field VARCHAR(50) NOT NULL,
+    old_value JSONB,
+    new_value JSONB,
+    changed_by VARCHAR(100) NOT NULL,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create tags table
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Link videos to tags. A tag can reach a video from several sources; scans
+-- only rewrite 'auto' and 'sidecar' rows, and a 'suppressed' row hides a
+-- scanned tag an admin removed.
+CREATE TABLE IF NOT EXISTS video_tags (
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    source VARCHAR(10) NOT NULL CHECK (source IN ('auto', 'sidecar', 'admin', 'user', 'suppressed')),
+    added_by VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (video_id, tag_id, source, added_by)
+);
+
+-- Slugs tags were renamed from, so scans that still find a tag under its old
+-- name add the renamed tag instead of bringing the old one back
+CREATE TABLE IF NOT EXISTS tag_aliases (
+    slug VARCHAR(100) PRIMARY KEY,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE
+);
+
+-- The tags each video visibly carries
+CREATE OR REPLACE VIEW effective_video_tags AS
+SELECT DISTINCT vt.video_id, vt.tag_id
+FROM video_tags vt
+WHERE vt.source <> 'suppressed'
+  AND NOT EXISTS (
+    SELECT 1 FROM video_tags s
+    WHERE s.video_id = vt.video_id AND s.tag_id = vt.tag_id AND s.source = 'suppressed'
+  );
+
+-- Create subtitle tracks table. Sidecar tracks point at the subtitle file
+-- next to the video and are converted to WebVTT when requested; embedded
+-- tracks point at the video and a stream index (-1 for sidecars).
+CREATE TABLE IF NOT EXISTS subtitle_tracks (
+    id SERIAL PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    source VARCHAR(10) NOT NULL CHECK (source IN ('sidecar', 'embedded')),
+    path VARCHAR(1000) NOT NULL,
+    stream_index INTEGER NOT NULL DEFAULT -1,
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    label VARCHAR(100) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    forced BOOLEAN NOT NULL DEFAULT FALSE,
+    hearing_impaired BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (video_id, path, stream_index)
+);
+
+-- Create chapters table. Each source keeps its own set; admin chapters win
+-- over sidecar chapters, which win over chapters embedded in the file.
+CREATE TABLE IF NOT EXISTS chapters (
+    id SERIAL PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    source VARCHAR(10) NOT NULL CHECK (source IN ('embedded', 'sidecar', 'admin')),
+    start_ms INTEGER NOT NULL CHECK (start_ms >= 0),
+    end_ms INTEGER,
+    title VARCHAR(500) NOT NULL,
+    UNIQUE (video_id, source, start_ms)
+);
+
+-- Create background jobs table. job_key identifies the work (type, video and
+-- payload) so the same work is only pending or running once at a time.
+CREATE TABLE IF NOT EXISTS jobs (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    job_key TEXT NOT NULL,
+    video_id INTEGER REFERENCES videos(id) ON DELETE CASCADE,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'completed', 'failed', 'cancelled')),
+    priority INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 3,
+    last_error TEXT,
+    run_after TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+-- rerun marks a running job whose work was queued again while it ran, so it
+-- goes back to pending once it finishes
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS rerun BOOLEAN NOT NULL DEFAULT false;
+
 -- Create comments table
 CREATE TABLE IF NOT EXISTS comments (
     id SERIAL PRIMARY KEY,
@@ -21,8 +157,109 @@ CREATE TABLE IF NOT EXISTS comments (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Thread replies under the comment they answer (NULL for top-level comments)
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
+
+-- Editing and moderation: owner is the X-User identity that posted the comment
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS owner VARCHAR(100);
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status VARCHAR(10) NOT NULL DEFAULT 'approved'
+    CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden BOOLEAN NOT NULL DEFAULT false;
+
+-- Playback position (in seconds) a comment is about, if any
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS timestamp_seconds INTEGER CHECK (timestamp_seconds >= 0);
+
+-- Emoji reactions on videos and comments, one row per identity and reaction
+CREATE TABLE IF NOT EXISTS video_reactions (
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    identity VARCHAR(100) NOT NULL,
+    reaction VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (video_id, identity, reaction)
+);
+
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    identity VARCHAR(100) NOT NULL,
+    reaction VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (comment_id, identity, reaction)
+);
+
+-- Notifications about replies and @mentions, addressed to X-User identities
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    recipient VARCHAR(100) NOT NULL,
+    type VARCHAR(10) NOT NULL CHECK (type IN ('reply', 'mention')),
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    actor VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP,
+    UNIQUE (recipient, comment_id)
+);
+
+-- Create auto-generated playlists table, rebuilt on each scan
+CREATE TABLE IF NOT EXISTS auto_playlists (
+    id VARCHAR(20) PRIMARY KEY,
+    name VARCHAR(500) NOT NULL,
+    directory VARCHAR(1000) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- Durations are summed from the videos when read, as probes fill them in
+-- after the scan that built the playlist
+ALTER TABLE auto_playlists DROP COLUMN IF EXISTS total_duration;
+
+-- Create auto-generated playlist items table
+CREATE TABLE IF NOT EXISTS auto_playlist_items (
+    playlist_id VARCHAR(20) NOT NULL REFERENCES auto_playlists(id) ON DELETE CASCADE,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (playlist_id, video_id)
+);
+
+-- Create manually pinned orders for auto-generated playlists. Not a foreign key
+-- so a pin survives a playlist briefly disappearing between scans.
+CREATE TABLE IF NOT EXISTS auto_playlist_order (
+    playlist_id VARCHAR(20) PRIMARY KEY,
+    video_ids INTEGER[] NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create user-curated playlists table
+CREATE TABLE IF NOT EXISTS user_playlists (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    owner VARCHAR(100) NOT NULL,
+    visibility VARCHAR(10) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'shared')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create user playlist items table
+CREATE TABLE IF NOT EXISTS user_playlist_items (
+    playlist_id INTEGER NOT NULL REFERENCES user_playlists(id) ON DELETE CASCADE,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (playlist_id, video_id)
+);
+
 -- Create indexes
 CREATE INDEX IF NOT EXISTS idx_videos_created_at ON videos(created_at DESC);
 CREATE INDEX IF NOT EXISTS idx_videos_modified_at ON videos(modified_at DESC);
 CREATE INDEX IF NOT EXISTS idx_comments_video_id ON comments(video_id);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
+CREATE INDEX IF NOT EXISTS idx_comments_status ON comments(status) WHERE status <> 'approved';
+CREATE INDEX IF NOT EXISTS idx_comments_timestamp ON comments(video_id, timestamp_seconds) WHERE timestamp_seconds IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_videos_filepath ON videos(filepath);
+CREATE INDEX IF NOT EXISTS idx_user_playlists_owner ON user_playlists(owner);
+CREATE INDEX IF NOT EXISTS idx_user_playlist_items_position ON user_playlist_items(playlist_id, position);
+CREATE INDEX IF NOT EXISTS idx_auto_playlist_items_position ON auto_playlist_items(playlist_id, position);
+CREATE INDEX IF NOT EXISTS idx_videos_show_episode ON videos(show_id, season_number, episode_number);
+CREATE INDEX IF NOT EXISTS idx_video_audit_log_video_id ON video_audit_log(video_id, changed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_video_tags_tag_id ON video_tags(tag_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_active_key ON jobs(job_key) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_jobs_pending ON jobs(type, priority DESC, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient ON notifications(recipient, created_at DESC);
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestSchemaIsIdempotent checks every statement in schema.sql can run again
+// on a database that already has it, as applySchema does on each start
+func TestSchemaIsIdempotent(t *testing.T) {
+	comments := regexp.MustCompile(`(?m)--.*$`)
+	idempotent := regexp.MustCompile(`^(CREATE TABLE IF NOT EXISTS|CREATE (UNIQUE )?INDEX IF NOT EXISTS|CREATE OR REPLACE VIEW|ALTER TABLE \w+ (ADD COLUMN IF NOT EXISTS|DROP COLUMN IF EXISTS))\b`)
+	for _, statement := range strings.Split(comments.ReplaceAllString(schema, ""), ";") {
+		statement = strings.Join(strings.Fields(statement), " ")
+		if statement != "" && !idempotent.MatchString(statement) {
+			t.Errorf("statement can't be applied twice: %.80s", statement)
+		}
+	}
+}
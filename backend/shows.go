@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Show represents a TV-style series detected from file paths
+type Show struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	SeasonCount  int       `json:"season_count"`
+	EpisodeCount int       `json:"episode_count"`
+	ThumbnailID  int       `json:"thumbnail_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Season summarizes one season of a show
+type Season struct {
+	Number       int `json:"number"`
+	EpisodeCount int `json:"episode_count"`
+	ThumbnailID  int `json:"thumbnail_id"`
+}
+
+// Episode is a video with its position in a show
+type Episode struct {
+	Video
+	PreviousEpisodeID *int `json:"previous_episode_id"`
+	NextEpisodeID     *int `json:"next_episode_id"`
+}
+
+// episodeInfo is what the scanner can tell about a video from its path
+type episodeInfo struct {
+	Show    string
+	Season  int
+	Episode int
+}
+
+var (
+	// crossEpisodePattern matches markers such as 1x03
+	crossEpisodePattern = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`)
+
+	// episodeOnlyPattern matches E03, Ep 3 and Episode 3 inside a season folder
+	episodeOnlyPattern = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:e|ep|episode)[ ._-]*(\d{1,4})(?:[^0-9]|$)`)
+
+	// seasonDirPattern matches folders such as "Season 1", Season_02 and S03
+	seasonDirPattern = regexp.MustCompile(`(?i)^(?:season|series|s)[ ._-]*(\d{1,3})$`)
+)
+
+// episodeOrder sorts specials (season 0) after the regular seasons
+const episodeOrder = `(v.season_number = 0), v.season_number, v.episode_number, v.filename`
+
+// parseSeasonDir reports the season number a folder name stands for
+func parseSeasonDir(name string) (int, bool) {
+	if strings.EqualFold(name, "specials") {
+		return 0, true
+	}
+	if m := seasonDirPattern.FindStringSubmatch(name); m != nil {
+		season, _ := strconv.Atoi(m[1])
+		return season, true
+	}
+	return 0, false
+}
+
+// cleanShowName turns a filename prefix or folder name into a display name
+func cleanShowName(name string) string {
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	name = strings.Trim(name, " -")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// parseEpisodePath extracts show, season and episode from a video path laid
+// out like Show/Season 1/Show.S01E03.mkv. The show name comes from the folder
+// above a season folder when there is one, and from the filename otherwise.
+func parseEpisodePath(path string) (episodeInfo, bool) {
+	root := filepath.Clean(config.VideoDir)
+	dir := filepath.Dir(path)
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	dirSeason, inSeasonDir := parseSeasonDir(filepath.Base(dir))
+
+	var info episodeInfo
+	var prefix string
+	if m := seasonEpisodePattern.FindStringSubmatchIndex(name); m != nil {
+		info.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		prefix = name[:m[2]-1]
+	} else if m := crossEpisodePattern.FindStringSubmatchIndex(name); m != nil {
+		info.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		prefix = name[:m[2]]
+	} else if m := episodeOnlyPattern.FindStringSubmatchIndex(name); m != nil && inSeasonDir {
+		info.Season = dirSeason
+		info.Episode, _ = strconv.Atoi(name[m[2]:m[3]])
+		prefix = name[:m[0]]
+	} else {
+		return info, false
+	}
+
+	if inSeasonDir && dir != root && filepath.Dir(dir) != root {
+		info.Show = cleanShowName(filepath.Base(filepath.Dir(dir)))
+	}
+	if info.Show == "" {
+		info.Show = cleanShowName(prefix)
+	}
+	if info.Show == "" && !inSeasonDir && dir != root {
+		info.Show = cleanShowName(filepath.Base(dir))
+	}
+	if info.Show == "" {
+		return info, false
+	}
+	return info, true
+}
+
+// indexShows parses every video path and links episodes to their shows,
+// creating shows as they appear and removing ones left without episodes
+func indexShows() error {
+	rows, err := db.Query("SELECT id, filepath, show_id, season_number, episode_number FROM videos")
+	if err != nil {
+		return fmt.Errorf("querying videos for shows: %w", err)
+	}
+
+	type videoEpisode struct {
+		id                      int
+		path                    string
+		showID, season, episode sql.NullInt64
+	}
+	var videos []videoEpisode
+	for rows.Next() {
+		var v videoEpisode
+		if err := rows.Scan(&v.id, &v.path, &v.showID, &v.season, &v.episode); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning video for shows: %w", err)
+		}
+		videos = append(videos, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading videos for shows: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	showIDs := make(map[string]int64)
+	updated := 0
+	for _, v := range videos {
+		var showID, season, episode sql.NullInt64
+		if info, ok := parseEpisodePath(v.path); ok {
+			key := groupKeyName(info.Show)
+			id, cached := showIDs[key]
+			if !cached {
+				err := tx.QueryRow(`
+					INSERT INTO shows (name, name_key) VALUES ($1, $2)
+					ON CONFLICT (name_key) DO UPDATE SET name = shows.name
+					RETURNING id
+				`, info.Show, key).Scan(&id)
+				if err != nil {
+					return fmt.Errorf("saving show %q: %w", info.Show, err)
+				}
+				showIDs[key] = id
+			}
+			showID = sql.NullInt64{Int64: id, Valid: true}
+			season = sql.NullInt64{Int64: int64(info.Season), Valid: true}
+			episode = sql.NullInt64{Int64: int64(info.Episode), Valid: true}
+		}
+
+		if showID == v.showID && season == v.season && episode == v.episode {
+			continue
+		}
+		if _, err := tx.Exec(`
+			UPDATE videos SET show_id = $1, season_number = $2, episode_number = $3
+			WHERE id = $4
+		`, showID, season, episode, v.id); err != nil {
+			return fmt.Errorf("linking video %d to show: %w", v.id, err)
+		}
+		updated++
+	}
+
+	result, err := tx.Exec("DELETE FROM shows WHERE NOT EXISTS (SELECT 1 FROM videos WHERE videos.show_id = shows.id)")
+	if err != nil {
+		return fmt.Errorf("removing empty shows: %w", err)
+	}
+	removed, _ := result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing shows: %w", err)
+	}
+
+	logger.Printf("Shows indexed: %d shows, %d episodes updated, %d shows removed", len(showIDs), updated, removed)
+	return nil
+}
+
+// showSummaryQuery aggregates episodes per show
+const showSummaryQuery = `
+	SELECT s.id, s.name, s.created_at,
+		COUNT(DISTINCT v.season_number), COUNT(v.id),
+		COALESCE((array_agg(v.id ORDER BY (v.season_number = 0), v.season_number, v.episode_number, v.filename))[1], 0)
+	FROM shows s
+	JOIN videos v ON v.show_id = s.id
+`
+
+func scanShow(row interface{ Scan(...interface{}) error }) (Show, error) {
+	var s Show
+	err := row.Scan(&s.ID, &s.Name, &s.CreatedAt, &s.SeasonCount, &s.EpisodeCount, &s.ThumbnailID)
+	return s, err
+}
+
+func getShows(w http.ResponseWriter, r *http.Request) {
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+	limit, args := page.limitClause(nil)
+	rows, err := db.Query(showSummaryQuery+`
+		GROUP BY s.id
+		ORDER BY s.name, s.id
+		`+limit, args...)
+	if err != nil {
+		logger.Printf("Error querying shows: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch shows")
+		return
+	}
+	defer rows.Close()
+
+	shows := []Show{}
+	for rows.Next() {
+		s, err := scanShow(rows)
+		if err != nil {
+			logger.Printf("Error scanning show: %v", err)
+			continue
+		}
+		shows = append(shows, s)
+	}
+
+	total, err := page.countRows(len(shows), `SELECT COUNT(*) FROM shows s WHERE EXISTS(SELECT 1 FROM videos v WHERE v.show_id = s.id)`)
+	if err != nil {
+		logger.Printf("Error counting shows: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch shows")
+		return
+	}
+	writePage(w, page, shows, total)
+}
+
+func getShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	s, err := scanShow(db.QueryRow(showSummaryQuery+`
+		WHERE s.id = $1
+		GROUP BY s.id
+	`, id))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Show not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching show: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch show")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func getShowSeasons(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	rows, err := db.Query(`
+		SELECT season_number, COUNT(*),
+			(array_agg(id ORDER BY episode_number, filename))[1]
+		FROM videos
+		WHERE show_id = $1
+		GROUP BY season_number
+		ORDER BY (season_number = 0), season_number
+	`, id)
+	if err != nil {
+		logger.Printf("Error querying seasons: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch seasons")
+		return
+	}
+	defer rows.Close()
+
+	seasons := []Season{}
+	for rows.Next() {
+		var s Season
+		if err := rows.Scan(&s.Number, &s.EpisodeCount, &s.ThumbnailID); err != nil {
+			logger.Printf("Error scanning season: %v", err)
+			continue
+		}
+		seasons = append(seasons, s)
+	}
+
+	if len(seasons) == 0 {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Show not found")
+		return
+	}
+
+	writeList(w, r, seasons)
+}
+
+// loadShowEpisodes returns every episode of a show in viewing order, linked
+// to the API under base
+func loadShowEpisodes(showID int, base string) ([]Video, error) {
+	rows, err := db.Query(`
+		SELECT `+videoColumns+`
+		FROM `+videoTables+`
+		WHERE v.show_id = $1
+		ORDER BY `+episodeOrder, showID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []Video
+	for rows.Next() {
+		v, err := scanVideo(rows)
+		if err != nil {
+			logger.Printf("Error scanning episode: %v", err)
+			continue
+		}
+		v.setLinks(base)
+		episodes = append(episodes, v)
+	}
+	return episodes, rows.Err()
+}
+
+// withNavigation links each episode to its neighbours across season boundaries
+func withNavigation(videos []Video) []Episode {
+	episodes := make([]Episode, len(videos))
+	for i, v := range videos {
+		episodes[i].Video = v
+		if i > 0 {
+			episodes[i].PreviousEpisodeID = &videos[i-1].ID
+		}
+		if i < len(videos)-1 {
+			episodes[i].NextEpisodeID = &videos[i+1].ID
+		}
+	}
+	return episodes
+}
+
+func getSeasonEpisodes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	showID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid show ID")
+		return
+	}
+	season, err := strconv.Atoi(vars["season"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid season number")
+		return
+	}
+
+	videos, err := loadShowEpisodes(showID, apiBase(r))
+	if err != nil {
+		logger.Printf("Error querying episodes: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch episodes")
+		return
+	}
+
+	episodes := []Episode{}
+	for _, e := range withNavigation(videos) {
+		if e.Season != nil && *e.Season == season {
+			episodes = append(episodes, e)
+		}
+	}
+	if len(episodes) == 0 {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Season not found")
+		return
+	}
+
+	writeList(w, r, episodes)
+}
+
+func getNextEpisode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid video ID")
+		return
+	}
+
+	var showID sql.NullInt64
+	err = db.QueryRow("SELECT show_id FROM videos WHERE id = $1", id).Scan(&showID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching video show: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
+		return
+	}
+	if !showID.Valid {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video is not part of a show")
+		return
+	}
+
+	videos, err := loadShowEpisodes(int(showID.Int64), apiBase(r))
+	if err != nil {
+		logger.Printf("Error querying episodes: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch episodes")
+		return
+	}
+
+	for i, v := range videos {
+		if v.ID == id {
+			if i == len(videos)-1 {
+				writeError(w, http.StatusNotFound, errorCodeNotFound, "No next episode")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(videos[i+1])
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestParseEpisodePath(t *testing.T) {
+	saved := config.VideoDir
+	config.VideoDir = "/videos"
+	defer func() { config.VideoDir = saved }()
+
+	tests := []struct {
+		path     string
+		expected episodeInfo
+		ok       bool
+	}{
+		{"/videos/The Office/Season 1/The.Office.S01E03.mkv", episodeInfo{"The Office", 1, 3}, true},
+		{"/videos/The Office/Season 2/Episode 4.mkv", episodeInfo{"The Office", 2, 4}, true},
+		{"/videos/The Office/Specials/E01 - Blooper reel.mkv", episodeInfo{"The Office", 0, 1}, true},
+		{"/videos/Firefly.S01E02.The.Train.Job.mkv", episodeInfo{"Firefly", 1, 2}, true},
+		{"/videos/tv/Doctor_Who_2x05.mp4", episodeInfo{"Doctor Who", 2, 5}, true},
+		{"/videos/Nova/s03e11.mkv", episodeInfo{"Nova", 3, 11}, true},
+		{"/videos/Season 1/S01E01.mkv", episodeInfo{}, false},
+		{"/videos/course/lecture_3.mp4", episodeInfo{}, false},
+		{"/videos/movies/1920x1080 demo.mp4", episodeInfo{}, false},
+		{"/videos/random/Episode 4.mkv", episodeInfo{}, false},
+	}
+
+	for _, test := range tests {
+		info, ok := parseEpisodePath(test.path)
+		if ok != test.ok || (ok && info != test.expected) {
+			t.Errorf("parseEpisodePath(%q) = %+v, %v; expected %+v, %v", test.path, info, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestParseSeasonDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		season int
+		ok     bool
+	}{
+		{"Season 1", 1, true},
+		{"season_02", 2, true},
+		{"S03", 3, true},
+		{"Specials", 0, true},
+		{"Extras", 0, false},
+		{"Seasonal", 0, false},
+	}
+
+	for _, test := range tests {
+		season, ok := parseSeasonDir(test.name)
+		if season != test.season || ok != test.ok {
+			t.Errorf("parseSeasonDir(%q) = %d, %v; expected %d, %v", test.name, season, ok, test.season, test.ok)
+		}
+	}
+}
+
+func TestWithNavigation(t *testing.T) {
+	episodes := withNavigation([]Video{{ID: 10}, {ID: 11}, {ID: 12}})
+
+	if episodes[0].PreviousEpisodeID != nil || *episodes[0].NextEpisodeID != 11 {
+		t.Errorf("Unexpected navigation for first episode: %+v", episodes[0])
+	}
+	if *episodes[1].PreviousEpisodeID != 10 || *episodes[1].NextEpisodeID != 12 {
+		t.Errorf("Unexpected navigation for middle episode: %+v", episodes[1])
+	}
+	if *episodes[2].PreviousEpisodeID != 11 || episodes[2].NextEpisodeID != nil {
+		t.Errorf("Unexpected navigation for last episode: %+v", episodes[2])
+	}
+}
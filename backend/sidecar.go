@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// sidecarMetadata holds the fields StreamLite reads from sidecar files
+type sidecarMetadata struct {
+	Title       string
+	Description string
+	Tags        []string
+	Cast        []string
+	Date        *time.Time
+	Thumbnail   string
+	Chapters    []chapterMark
+
+	// ignored holds why parts of the file were skipped, for readSidecars
+	// to log
+	ignored []error
+}
+
+// nfoDocument covers the Kodi movie, episodedetails, tvshow and musicvideo
+// formats, which share these element names
+type nfoDocument struct {
+	Title     string   `xml:"title"`
+	Plot      string   `xml:"plot"`
+	Outline   string   `xml:"outline"`
+	Tags      []string `xml:"tag"`
+	Genres    []string `xml:"genre"`
+	Premiered string   `xml:"premiered"`
+	Aired     string   `xml:"aired"`
+	Year      string   `xml:"year"`
+	Thumbs    []string `xml:"thumb"`
+	Actors    []struct {
+		Name string `xml:"name"`
+	} `xml:"actor"`
+}
+
+// jsonSidecar is the simple <name>.json format
+type jsonSidecar struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Tags        []string       `json:"tags"`
+	Date        string         `json:"date"`
+	Cast        []string       `json:"cast"`
+	Thumbnail   string         `json:"thumbnail"`
+	Chapters    []chapterInput `json:"chapters"`
+}
+
+// thumbnailExtensions limits custom thumbnails to image files
+var thumbnailExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".gif":  true,
+}
+
+// sidecarPaths returns the sidecar files StreamLite looks for, in the order
+// they are applied; later files override earlier ones field by field
+func sidecarPaths(videoPath string) []string {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	return []string{base + ".nfo", base + ".json"}
+}
+
+// chapterFilePath returns the chapter list sidecar for a video
+func chapterFilePath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + chapterFileSuffix
+}
+
+// sidecarSignature summarizes which sidecars exist and when they last
+// changed, so a scan only re-reads them when the signature moves
+func sidecarSignature(videoPath string) string {
+	var parts []string
+	for _, path := range append(sidecarPaths(videoPath), chapterFilePath(videoPath)) {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s@%d:%d", filepath.Base(path), info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(parts, ";")
+}
+
+// parseSidecarDate accepts full dates and bare years
+func parseSidecarDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// cleanList trims entries and drops blanks and duplicates
+func cleanList(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		result = append(result, v)
+	}
+	return result
+}
+
+// parseNFO reads a Kodi-style .nfo XML document
+func parseNFO(r io.Reader) (sidecarMetadata, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return sidecarMetadata{}, fmt.Errorf("no root element: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var doc nfoDocument
+		if err := decoder.DecodeElement(&doc, &start); err != nil {
+			return sidecarMetadata{}, err
+		}
+
+		meta := sidecarMetadata{
+			Title:       strings.TrimSpace(doc.Title),
+			Description: strings.TrimSpace(doc.Plot),
+			Tags:        cleanList(append(doc.Genres, doc.Tags...)),
+		}
+		if meta.Description == "" {
+			meta.Description = strings.TrimSpace(doc.Outline)
+		}
+		for _, actor := range doc.Actors {
+			meta.Cast = append(meta.Cast, actor.Name)
+		}
+		meta.Cast = cleanList(meta.Cast)
+		for _, date := range []string{doc.Premiered, doc.Aired, doc.Year} {
+			if meta.Date = parseSidecarDate(date); meta.Date != nil {
+				break
+			}
+		}
+		for _, thumb := range doc.Thumbs {
+			if thumb = strings.TrimSpace(thumb); thumb != "" {
+				meta.Thumbnail = thumb
+				break
+			}
+		}
+		return meta, nil
+	}
+}
+
+// parseJSONSidecar reads a <name>.json sidecar
+func parseJSONSidecar(r io.Reader) (sidecarMetadata, error) {
+	var doc jsonSidecar
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return sidecarMetadata{}, err
+	}
+	// Bad chapters are skipped rather than losing the rest of the file
+	chapters, problems := validChapterMarks(doc.Chapters)
+	return sidecarMetadata{
+		Title:       strings.TrimSpace(doc.Title),
+		Description: strings.TrimSpace(doc.Description),
+		Tags:        cleanList(doc.Tags),
+		Cast:        cleanList(doc.Cast),
+		Date:        parseSidecarDate(doc.Date),
+		Thumbnail:   strings.TrimSpace(doc.Thumbnail),
+		Chapters:    chapters,
+		ignored:     problems,
+	}, nil
+}
+
+// merge overlays the non-empty fields of other onto m
+func (m *sidecarMetadata) merge(other sidecarMetadata) {
+	if other.Title != "" {
+		m.Title = other.Title
+	}
+	if other.Description != "" {
+		m.Description = other.Description
+	}
+	if len(other.Tags) > 0 {
+		m.Tags = other.Tags
+	}
+	if len(other.Cast) > 0 {
+		m.Cast = other.Cast
+	}
+	if other.Date != nil {
+		m.Date = other.Date
+	}
+	if other.Thumbnail != "" {
+		m.Thumbnail = other.Thumbnail
+	}
+	if len(other.Chapters) > 0 {
+		m.Chapters = other.Chapters
+	}
+}
+
+// readSidecars parses every sidecar next to the video. Files that fail to
+// parse are logged and skipped so one bad file doesn't hide the other.
+func readSidecars(videoPath string) sidecarMetadata {
+	var meta sidecarMetadata
+	for _, path := range sidecarPaths(videoPath) {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		var parsed sidecarMetadata
+		if strings.HasSuffix(path, ".nfo") {
+			parsed, err = parseNFO(f)
+		} else {
+			parsed, err = parseJSONSidecar(f)
+		}
+		f.Close()
+		if err != nil {
+			logger.Printf("Warning: Cannot parse sidecar %s: %v", path, err)
+			continue
+		}
+		for _, problem := range parsed.ignored {
+			logger.Printf("Warning: Ignoring part of sidecar %s: %v", path, problem)
+		}
+		meta.merge(parsed)
+	}
+
+	// A chapter list file takes precedence over chapters in the JSON sidecar
+	if data, err := os.ReadFile(chapterFilePath(videoPath)); err == nil {
+		chapters, problems, err := parseChapterFile(decodeSubtitleText(data))
+		if err != nil {
+			logger.Printf("Warning: Cannot parse chapters %s: %v", chapterFilePath(videoPath), err)
+		}
+		for _, problem := range problems {
+			logger.Printf("Warning: Ignoring part of chapters %s: %v", chapterFilePath(videoPath), problem)
+		}
+		if len(chapters) > 0 {
+			meta.Chapters = chapters
+		}
+	}
+	return meta
+}
+
+// resolveSidecarThumbnail turns a sidecar thumbnail reference into a local
+// path. Only image files inside the video's own directory are accepted, so a
+// sidecar can't expose arbitrary files through the thumbnail endpoint.
+func resolveSidecarThumbnail(videoPath, ref string) string {
+	if ref == "" || strings.Contains(ref, "://") {
+		return ""
+	}
+	if !thumbnailExtensions[strings.ToLower(filepath.Ext(ref))] {
+		return ""
+	}
+
+	dir := filepath.Dir(videoPath)
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return path
+}
+
+// syncSidecar re-reads a video's sidecars when they have changed since the
+// last scan and stores what they provide. Sidecars are only ever read.
+func syncSidecar(videoID int, videoPath, storedSignature string) {
+	signature := sidecarSignature(videoPath)
+	if signature == storedSignature {
+		return
+	}
+
+	meta := readSidecars(videoPath)
+	title := meta.Title
+	if title == "" {
+		title = titleFromFilename(filepath.Base(videoPath))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE videos
+		SET title = $1, description = $2, cast_members = $3,
+			release_date = $4, thumbnail_path = $5, sidecar_signature = $6
+		WHERE id = $7
+	`, title, meta.Description, pq.Array(nonNil(meta.Cast)),
+		meta.Date, resolveSidecarThumbnail(videoPath, meta.Thumbnail), signature, videoID)
+	if err != nil {
+		logger.Printf("Error updating sidecar metadata for video ID %d: %v", videoID, err)
+		return
+	}
+
+	if err := replaceVideoTags(tx, videoID, tagSourceSidecar, meta.Tags, ""); err != nil {
+		logger.Printf("Error updating sidecar tags for video ID %d: %v", videoID, err)
+		return
+	}
+
+	if err := replaceChapters(tx, videoID, chapterSourceSidecar, meta.Chapters); err != nil {
+		logger.Printf("Error updating sidecar chapters for video ID %d: %v", videoID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing sidecar metadata for video ID %d: %v", videoID, err)
+		return
+	}
+
+	if signature == "" {
+		logger.Printf("Sidecar metadata removed for video ID %d", videoID)
+	} else {
+		logger.Printf("Read sidecar metadata for video ID %d", videoID)
+	}
+}
+
+// nonNil keeps empty lists from being stored as NULL
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
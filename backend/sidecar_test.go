@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNFO(t *testing.T) {
+	nfo := `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<episodedetails>
+    <title>The Train Job</title>
+    <plot>The crew takes a job.</plot>
+    <genre>Sci-Fi</genre>
+    <tag>archive</tag>
+    <tag>archive</tag>
+    <aired>2002-09-20</aired>
+    <thumb>poster.jpg</thumb>
+    <actor><name>Nathan Fillion</name><role>Mal</role></actor>
+    <actor><name>Gina Torres</name></actor>
+</episodedetails>`
+
+	meta, err := parseNFO(strings.NewReader(nfo))
+	if err != nil {
+		t.Fatalf("parseNFO failed: %v", err)
+	}
+	if meta.Title != "The Train Job" || meta.Description != "The crew takes a job." {
+		t.Errorf("Unexpected title/description: %+v", meta)
+	}
+	if len(meta.Tags) != 2 || meta.Tags[0] != "Sci-Fi" || meta.Tags[1] != "archive" {
+		t.Errorf("Expected deduplicated tags [Sci-Fi archive], got %v", meta.Tags)
+	}
+	if len(meta.Cast) != 2 || meta.Cast[0] != "Nathan Fillion" {
+		t.Errorf("Unexpected cast: %v", meta.Cast)
+	}
+	if meta.Date == nil || meta.Date.Format("2006-01-02") != "2002-09-20" {
+		t.Errorf("Unexpected date: %v", meta.Date)
+	}
+	if meta.Thumbnail != "poster.jpg" {
+		t.Errorf("Unexpected thumbnail: %q", meta.Thumbnail)
+	}
+}
+
+func TestParseNFOYearFallback(t *testing.T) {
+	meta, err := parseNFO(strings.NewReader(`<movie><title>Old</title><outline>Short</outline><year>1999</year></movie>`))
+	if err != nil {
+		t.Fatalf("parseNFO failed: %v", err)
+	}
+	if meta.Description != "Short" || meta.Date == nil || meta.Date.Year() != 1999 {
+		t.Errorf("Unexpected metadata: %+v", meta)
+	}
+}
+
+func TestParseJSONSidecarSkipsBadChapters(t *testing.T) {
+	meta, err := parseJSONSidecar(strings.NewReader(`{
+		"title": "Talk",
+		"description": "A talk.",
+		"tags": ["keynote"],
+		"chapters": [{"start": 0, "title": "Intro"}, {"start": 1e12, "title": "Too late"}, {"start": 60, "title": "Q&A"}]
+	}`))
+	if err != nil {
+		t.Fatalf("parseJSONSidecar failed: %v", err)
+	}
+	if meta.Title != "Talk" || meta.Description != "A talk." || len(meta.Tags) != 1 {
+		t.Errorf("Expected the rest of the sidecar to be kept, got %+v", meta)
+	}
+	if len(meta.Chapters) != 2 || meta.Chapters[1].Title != "Q&A" {
+		t.Errorf("Expected the valid chapters to be kept, got %+v", meta.Chapters)
+	}
+	if len(meta.ignored) != 1 {
+		t.Errorf("Expected the bad chapter to be reported, got %v", meta.ignored)
+	}
+}
+
+func TestReadSidecarsMergesJSONOverNFO(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "talk.mp4")
+	os.WriteFile(filepath.Join(dir, "talk.nfo"), []byte(`<movie><title>NFO title</title><plot>From NFO</plot></movie>`), 0644)
+	os.WriteFile(filepath.Join(dir, "talk.json"), []byte(`{"title": "JSON title", "tags": ["keynote"]}`), 0644)
+
+	meta := readSidecars(video)
+	if meta.Title != "JSON title" || meta.Description != "From NFO" || len(meta.Tags) != 1 {
+		t.Errorf("Expected JSON to override NFO field by field, got %+v", meta)
+	}
+
+	signature := sidecarSignature(video)
+	if !strings.Contains(signature, "talk.nfo@") || !strings.Contains(signature, "talk.json@") {
+		t.Errorf("Expected signature to cover both sidecars, got %q", signature)
+	}
+	if sidecarSignature(filepath.Join(dir, "other.mp4")) != "" {
+		t.Error("Expected empty signature without sidecars")
+	}
+}
+
+func TestResolveSidecarThumbnail(t *testing.T) {
+	video := "/videos/show/episode.mkv"
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"poster.jpg", "/videos/show/poster.jpg"},
+		{"art/fanart.PNG", "/videos/show/art/fanart.PNG"},
+		{"/videos/show/thumb.webp", "/videos/show/thumb.webp"},
+		{"../other/poster.jpg", ""},
+		{"/etc/passwd", ""},
+		{"notes.txt", ""},
+		{"http://example.com/poster.jpg", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := resolveSidecarThumbnail(video, test.ref); result != test.expected {
+			t.Errorf("resolveSidecarThumbnail(%q) = %q; expected %q", test.ref, result, test.expected)
+		}
+	}
+}
+
+func TestTitleFromFilename(t *testing.T) {
+	if title := titleFromFilename("my_great-video.mp4"); title != "my great video" {
+		t.Errorf("Unexpected title %q", title)
+	}
+}
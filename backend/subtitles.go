@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Subtitle track sources
+const (
+	subtitleSourceSidecar = "sidecar"
+
+	// maxSubtitleOffset bounds the ?offset= shift
+	maxSubtitleOffset = 24 * time.Hour
+)
+
+// subtitleFormats maps subtitle file extensions to their format
+var subtitleFormats = map[string]string{
+	".srt": "srt",
+	".vtt": "vtt",
+	".ass": "ass",
+	".ssa": "ass",
+}
+
+// languageNames maps spelled-out language names used in subtitle filenames to
+// their ISO 639-1 codes
+var languageNames = map[string]string{
+	"english":    "en",
+	"french":     "fr",
+	"german":     "de",
+	"spanish":    "es",
+	"italian":    "it",
+	"portuguese": "pt",
+	"dutch":      "nl",
+	"russian":    "ru",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"chinese":    "zh",
+	"arabic":     "ar",
+	"hindi":      "hi",
+}
+
+// languageCodes maps the ISO 639-2 codes common in MKV files and subtitle
+// filenames to ISO 639-1
+var languageCodes = map[string]string{
+	"eng": "en", "fre": "fr", "fra": "fr", "ger": "de", "deu": "de", "spa": "es",
+	"ita": "it", "por": "pt", "dut": "nl", "nld": "nl", "rus": "ru", "jpn": "ja",
+	"kor": "ko", "chi": "zh", "zho": "zh", "ara": "ar", "hin": "hi",
+}
+
+var languageCodePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,4})?$`)
+
+// SubtitleTrack is a subtitle track available for a video
+type SubtitleTrack struct {
+	ID              int    `json:"id"`
+	Language        string `json:"language"`
+	Label           string `json:"label"`
+	Format          string `json:"format"`
+	Source          string `json:"source"`
+	Forced          bool   `json:"forced"`
+	HearingImpaired bool   `json:"hearing_impaired"`
+	URL             string `json:"url"`
+
+	videoID     int
+	path        string // subtitle file, or the video for embedded tracks
+	streamIndex int    // ffprobe stream index of an embedded track
+}
+
+// subtitleCue is one timed caption
+type subtitleCue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Text     string
+}
+
+// parseSubtitleName matches a subtitle file against a video following the
+// name.lang.srt convention: the video's base name, then any number of
+// language and flag parts (Movie.en.srt, Movie.pt-BR.forced.srt, Movie.English.sdh.srt).
+// Parts that aren't recognized mean the file belongs to some other video.
+func parseSubtitleName(videoPath, subtitleName string) (SubtitleTrack, bool) {
+	format, ok := subtitleFormats[strings.ToLower(filepath.Ext(subtitleName))]
+	if !ok {
+		return SubtitleTrack{}, false
+	}
+
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+	name := strings.TrimSuffix(subtitleName, filepath.Ext(subtitleName))
+	if name != base && !strings.HasPrefix(name, base+".") {
+		return SubtitleTrack{}, false
+	}
+
+	track := SubtitleTrack{Format: format, Source: subtitleSourceSidecar}
+	for _, part := range strings.Split(strings.TrimPrefix(name, base), ".")[1:] {
+		lower := strings.ToLower(part)
+		switch {
+		case lower == "forced":
+			track.Forced = true
+		case lower == "sdh" || lower == "cc":
+			track.HearingImpaired = true
+		case lower == "default":
+		case languageNames[lower] != "":
+			track.Language = languageNames[lower]
+		case languageCodePattern.MatchString(part) && track.Language == "":
+			track.Language = normalizeLanguageCode(part)
+		default:
+			return SubtitleTrack{}, false
+		}
+	}
+
+	track.Label = subtitleLabel(track)
+	return track, true
+}
+
+// normalizeLanguageCode formats a language tag as en or pt-BR
+func normalizeLanguageCode(code string) string {
+	parts := strings.SplitN(strings.ReplaceAll(code, "_", "-"), "-", 2)
+	code = strings.ToLower(parts[0])
+	if short, ok := languageCodes[code]; ok {
+		code = short
+	}
+	if len(parts) == 2 {
+		code += "-" + strings.ToUpper(parts[1])
+	}
+	return code
+}
+
+// subtitleLabel builds the name shown in the player's track menu
+func subtitleLabel(track SubtitleTrack) string {
+	label := track.Language
+	for name, code := range languageNames {
+		if code == strings.SplitN(track.Language, "-", 2)[0] {
+			label = strings.ToUpper(name[:1]) + name[1:]
+			if strings.Contains(track.Language, "-") {
+				label += " (" + strings.SplitN(track.Language, "-", 2)[1] + ")"
+			}
+			break
+		}
+	}
+	if label == "" {
+		label = "Subtitles"
+	}
+	if track.Forced {
+		label += " [Forced]"
+	}
+	if track.HearingImpaired {
+		label += " [SDH]"
+	}
+	return label
+}
+
+// discoverSubtitles lists the sidecar subtitle files next to a video
+func discoverSubtitles(videoPath string) []SubtitleTrack {
+	entries, err := os.ReadDir(filepath.Dir(videoPath))
+	if err != nil {
+		logger.Printf("Warning: Cannot list subtitles for %s: %v", videoPath, err)
+		return nil
+	}
+
+	var tracks []SubtitleTrack
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if track, ok := parseSubtitleName(videoPath, entry.Name()); ok {
+			track.path = filepath.Join(filepath.Dir(videoPath), entry.Name())
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
+
+// syncSubtitles records a video's sidecar subtitle files. Tracks keep their
+// IDs across scans so player URLs stay valid.
+func syncSubtitles(videoID int, videoPath string) {
+	tracks := discoverSubtitles(videoPath)
+
+	rows, err := db.Query("SELECT path FROM subtitle_tracks WHERE video_id = $1 AND source = $2", videoID, subtitleSourceSidecar)
+	if err != nil {
+		logger.Printf("Error querying subtitles for video ID %d: %v", videoID, err)
+		return
+	}
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			stored[path] = true
+		}
+	}
+	rows.Close()
+
+	current := make(map[string]bool)
+	unchanged := len(tracks) == len(stored)
+	for _, track := range tracks {
+		current[track.path] = true
+		if !stored[track.path] {
+			unchanged = false
+		}
+	}
+	if unchanged {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	for path := range stored {
+		if current[path] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM subtitle_tracks WHERE video_id = $1 AND source = $2 AND path = $3",
+			videoID, subtitleSourceSidecar, path); err != nil {
+			logger.Printf("Error removing subtitle %s: %v", path, err)
+			return
+		}
+	}
+	for _, track := range tracks {
+		if _, err := tx.Exec(`
+			INSERT INTO subtitle_tracks (video_id, source, path, language, label, format, forced, hearing_impaired)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (video_id, path, stream_index) DO NOTHING
+		`, videoID, track.Source, track.path, track.Language, track.Label, track.Format, track.Forced, track.HearingImpaired); err != nil {
+			logger.Printf("Error saving subtitle %s: %v", track.path, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing subtitles for video ID %d: %v", videoID, err)
+		return
+	}
+	logger.Printf("Found %d subtitle tracks for video ID %d", len(tracks), videoID)
+}
+
+const subtitleColumns = `id, video_id, language, label, format, source, forced, hearing_impaired, path, stream_index`
+
+func scanSubtitleTrack(row interface{ Scan(...interface{}) error }) (SubtitleTrack, error) {
+	var t SubtitleTrack
+	var videoID int
+	err := row.Scan(&t.ID, &videoID, &t.Language, &t.Label, &t.Format, &t.Source, &t.Forced, &t.HearingImpaired,
+		&t.path, &t.streamIndex)
+	t.videoID = videoID
+	t.setLink("/api")
+	return t, err
+}
+
+// setLink points the track's URL at the API under base, see apiBase
+func (t *SubtitleTrack) setLink(base string) {
+	t.URL = fmt.Sprintf("%s/videos/%d/subtitles/%d.vtt", base, t.videoID, t.ID)
+}
+
+func getSubtitles(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+subtitleColumns+`
+		FROM subtitle_tracks
+		WHERE video_id = $1
+		ORDER BY forced, language, hearing_impaired, source DESC, id
+	`, videoID)
+	if err != nil {
+		logger.Printf("Error querying subtitles: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch subtitles")
+		return
+	}
+	defer rows.Close()
+
+	tracks := []SubtitleTrack{}
+	for rows.Next() {
+		track, err := scanSubtitleTrack(rows)
+		if err != nil {
+			logger.Printf("Error scanning subtitle track: %v", err)
+			continue
+		}
+		track.setLink(apiBase(r))
+		tracks = append(tracks, track)
+	}
+
+	writeList(w, r, tracks)
+}
+
+// getSubtitleVTT serves a track as WebVTT. ?offset= shifts every cue by the
+// given number of seconds (negative to show subtitles earlier).
+func getSubtitleVTT(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var offset time.Duration
+	if value := r.URL.Query().Get("offset"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(seconds) || math.Abs(seconds) > maxSubtitleOffset.Seconds() {
+			writeError(w, http.StatusBadRequest, errorCodeInvalidParameter, "Invalid offset")
+			return
+		}
+		offset = time.Duration(seconds * float64(time.Second))
+	}
+
+	track, err := scanSubtitleTrack(db.QueryRow(`
+		SELECT `+subtitleColumns+`
+		FROM subtitle_tracks
+		WHERE id = $1 AND video_id = $2
+	`, vars["track"], vars["id"]))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Subtitle track not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching subtitle track: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch subtitles")
+		return
+	}
+
+	var text, format string
+	if track.Source == subtitleSourceEmbedded {
+		// Extraction reads the whole file, so it runs as a job; until it has
+		// finished the request is answered with 202 Accepted
+		videoID, _ := strconv.Atoi(vars["id"])
+		var ok bool
+		if text, ok = cachedEmbeddedSubtitle(videoID, track); !ok {
+			queueMediaResponse(w, videoID, "Subtitle", func() (bool, error) {
+				return queueSubtitleExtraction(videoID, track.ID)
+			})
+			return
+		}
+		format = "vtt"
+	} else {
+		data, err := os.ReadFile(track.path)
+		if err != nil {
+			logger.Printf("Error reading subtitle file %s: %v", track.path, err)
+			writeError(w, http.StatusNotFound, errorCodeNotFound, "Subtitle file not accessible")
+			return
+		}
+		text, format = decodeSubtitleText(data), track.Format
+	}
+
+	cues, err := parseSubtitles(format, text)
+	if err != nil {
+		logger.Printf("Error parsing subtitle file %s: %v", track.path, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to convert subtitles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Write([]byte(renderVTT(shiftCues(cues, offset))))
+}
+
+// decodeSubtitleText returns subtitle file contents as UTF-8 with Unix line
+// endings. Files that aren't valid UTF-8 are assumed to be Latin-1, which
+// covers most older SRT files.
+func decodeSubtitleText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := string(data)
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+// parseSubtitles reads cues from a subtitle file in the given format
+func parseSubtitles(format, text string) ([]subtitleCue, error) {
+	switch format {
+	case "srt":
+		// SRT coordinates after the timing aren't valid WebVTT cue settings
+		cues := parseTimedBlocks(text)
+		for i := range cues {
+			cues[i].Settings = ""
+		}
+		return cues, nil
+	case "vtt":
+		return parseTimedBlocks(text), nil
+	case "ass":
+		return parseASS(text)
+	}
+	return nil, fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+var timingPattern = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})(.*)$`)
+
+// parseTimedBlocks reads SRT and WebVTT cues, which share the blank-line
+// separated "start --> end" block layout. Blocks without a timing line (the
+// WEBVTT header, NOTE and STYLE blocks, SRT junk) are skipped.
+func parseTimedBlocks(text string) []subtitleCue {
+	var cues []subtitleCue
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			m := timingPattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			start, err1 := parseTimestamp(m[1])
+			end, err2 := parseTimestamp(m[2])
+			if err1 != nil || err2 != nil {
+				break
+			}
+
+			cue := subtitleCue{Start: start, End: end, Settings: strings.TrimSpace(m[3])}
+			if i > 0 {
+				id := strings.TrimSpace(lines[i-1])
+				// Numeric SRT counters aren't worth keeping as cue identifiers
+				if _, err := strconv.Atoi(id); err != nil {
+					cue.ID = id
+				}
+			}
+			cue.Text = cleanCueText(strings.Join(lines[i+1:], "\n"))
+			cues = append(cues, cue)
+			break
+		}
+	}
+	return cues
+}
+
+// parseTimestamp reads 00:01:02,345, 01:02.345 and similar timestamps
+func parseTimestamp(value string) (time.Duration, error) {
+	total, err := parseSeconds(value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(math.Round(total*1000)) * time.Millisecond, nil
+}
+
+// parseSeconds reads a timestamp as parseTimestamp does, as seconds, so
+// values too large for a time.Duration can still be told apart and refused
+func parseSeconds(value string) (float64, error) {
+	value = strings.Replace(value, ",", ".", 1)
+	parts := strings.Split(value, ":")
+	var total float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		total = total*60 + n
+	}
+	return total, nil
+}
+
+var overrideTagPattern = regexp.MustCompile(`\{\\[^}]*\}`)
+
+const (
+	assFormatPrefix   = "Format:"
+	assDialoguePrefix = "Dialogue:"
+)
+
+// cleanCueText removes ASS-style {\...} override tags, which some SRT files
+// carry too, and stops cue text from ending a cue early
+func cleanCueText(text string) string {
+	text = overrideTagPattern.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, "-->", "->")
+	return strings.TrimSpace(text)
+}
+
+// parseASS reads the Dialogue lines of an ASS/SSA [Events] section
+func parseASS(text string) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	var fields []string
+	inEvents := false
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		if strings.HasPrefix(line, assFormatPrefix) {
+			fields = nil
+			for _, field := range strings.Split(strings.TrimPrefix(line, assFormatPrefix), ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(field)))
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, assDialoguePrefix) || len(fields) == 0 {
+			continue
+		}
+
+		// Text is the last field and may itself contain commas
+		values := strings.SplitN(strings.TrimPrefix(line, assDialoguePrefix), ",", len(fields))
+		if len(values) != len(fields) {
+			continue
+		}
+		var cue subtitleCue
+		var err error
+		for i, field := range fields {
+			value := strings.TrimSpace(values[i])
+			switch field {
+			case "start":
+				cue.Start, err = parseTimestamp(value)
+			case "end":
+				cue.End, err = parseTimestamp(value)
+			case "text":
+				value = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(values[i])
+				cue.Text = cleanCueText(value)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil || cue.Text == "" {
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("no [Events] format line")
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+// shiftCues moves every cue by offset, dropping cues that end up entirely
+// before the start of the video
+func shiftCues(cues []subtitleCue, offset time.Duration) []subtitleCue {
+	if offset == 0 {
+		return cues
+	}
+	shifted := make([]subtitleCue, 0, len(cues))
+	for _, cue := range cues {
+		cue.Start += offset
+		cue.End += offset
+		if cue.End <= 0 {
+			continue
+		}
+		if cue.Start < 0 {
+			cue.Start = 0
+		}
+		shifted = append(shifted, cue)
+	}
+	return shifted
+}
+
+// formatVTTTimestamp writes a duration as HH:MM:SS.mmm
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// renderVTT writes cues as a WebVTT document
+func renderVTT(cues []subtitleCue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n")
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		b.WriteString(formatVTTTimestamp(cue.Start) + " --> " + formatVTTTimestamp(cue.End))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n" + cue.Text + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSubtitleName(t *testing.T) {
+	tests := []struct {
+		name     string
+		ok       bool
+		language string
+		label    string
+		forced   bool
+	}{
+		{"Lecture 1.srt", true, "", "Subtitles", false},
+		{"Lecture 1.en.srt", true, "en", "English", false},
+		{"Lecture 1.pt_br.vtt", true, "pt-BR", "Portuguese (BR)", false},
+		{"Lecture 1.French.forced.ass", true, "fr", "French [Forced]", true},
+		{"Lecture 1.en.sdh.srt", true, "en", "English [SDH]", false},
+		{"Lecture 1.eng.SRT", true, "en", "English", false},
+		{"Lecture 1.tlh.srt", true, "tlh", "tlh", false},
+		{"Lecture 1.mp4", false, "", "", false},
+		{"Lecture 10.en.srt", false, "", "", false},
+		{"Lecture 1.Part2.en.srt", false, "", "", false},
+		{"Lecture 1.en.fr.srt", false, "", "", false},
+	}
+
+	for _, test := range tests {
+		track, ok := parseSubtitleName("/videos/course/Lecture 1.mp4", test.name)
+		if ok != test.ok {
+			t.Errorf("parseSubtitleName(%q) ok = %v; expected %v", test.name, ok, test.ok)
+			continue
+		}
+		if ok && (track.Language != test.language || track.Label != test.label || track.Forced != test.forced) {
+			t.Errorf("parseSubtitleName(%q) = %q/%q/%v; expected %q/%q/%v", test.name,
+				track.Language, track.Label, track.Forced, test.language, test.label, test.forced)
+		}
+	}
+}
+
+func TestConvertSRT(t *testing.T) {
+	srt := "1\r\n00:00:01,500 --> 00:00:03,000 X1:10 X2:20\r\n<i>Hello</i>\r\nworld\r\n\r\n2\r\n00:00:04,000 --> 00:00:05,250\r\n{\\an8}Top\r\n"
+	cues, err := parseSubtitles("srt", decodeSubtitleText([]byte(srt)))
+	if err != nil {
+		t.Fatalf("parseSubtitles failed: %v", err)
+	}
+
+	expected := "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\n<i>Hello</i>\nworld\n\n00:00:04.000 --> 00:00:05.250\nTop\n"
+	if result := renderVTT(cues); result != expected {
+		t.Errorf("renderVTT = %q; expected %q", result, expected)
+	}
+
+	shifted := renderVTT(shiftCues(cues, -2*time.Second))
+	expected = "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n<i>Hello</i>\nworld\n\n00:00:02.000 --> 00:00:03.250\nTop\n"
+	if shifted != expected {
+		t.Errorf("shifted renderVTT = %q; expected %q", shifted, expected)
+	}
+
+	if result := shiftCues(cues, -4*time.Second); len(result) != 1 {
+		t.Errorf("Expected cues ending before zero to be dropped, got %d cues", len(result))
+	}
+}
+
+func TestConvertVTTKeepsSettings(t *testing.T) {
+	vtt := "WEBVTT\n\nNOTE a comment\n\nintro\n01:02.000 --> 01:03.000 line:0\nHi\n"
+	cues, err := parseSubtitles("vtt", vtt)
+	if err != nil {
+		t.Fatalf("parseSubtitles failed: %v", err)
+	}
+	expected := "WEBVTT\n\nintro\n00:01:02.000 --> 00:01:03.000 line:0\nHi\n"
+	if result := renderVTT(cues); result != expected {
+		t.Errorf("renderVTT = %q; expected %q", result, expected)
+	}
+}
+
+func TestConvertASS(t *testing.T) {
+	ass := `[Script Info]
+Title: Test
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:05.00,0:00:06.50,Default,,0,0,0,,{\b1}Second{\b0}, with comma
+Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,First\Nline
+Comment: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,Hidden
+`
+	cues, err := parseSubtitles("ass", ass)
+	if err != nil {
+		t.Fatalf("parseSubtitles failed: %v", err)
+	}
+
+	expected := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nFirst\nline\n\n00:00:05.000 --> 00:00:06.500\nSecond, with comma\n"
+	if result := renderVTT(cues); result != expected {
+		t.Errorf("renderVTT = %q; expected %q", result, expected)
+	}
+
+	if _, err := parseSubtitles("ass", "[Script Info]\nTitle: Empty\n"); err == nil {
+		t.Error("Expected error for ASS file without events")
+	}
+}
+
+func TestDecodeSubtitleTextLatin1(t *testing.T) {
+	result := decodeSubtitleText([]byte("Caf\xe9\r\n"))
+	if result != "Café\n" {
+		t.Errorf("decodeSubtitleText = %q; expected %q", result, "Café\n")
+	}
+}
+
+func TestEmbeddedSubtitleTracks(t *testing.T) {
+	output := `{
+		"streams": [
+			{"index": 0, "codec_name": "h264", "codec_type": "video"},
+			{"index": 2, "codec_name": "subrip", "codec_type": "subtitle", "tags": {"language": "eng"}},
+			{"index": 3, "codec_name": "ass", "codec_type": "subtitle", "disposition": {"forced": 1}, "tags": {"language": "ger", "title": "Signs"}},
+			{"index": 4, "codec_name": "hdmv_pgs_subtitle", "codec_type": "subtitle", "tags": {"language": "fre"}},
+			{"index": 5, "codec_name": "mov_text", "codec_type": "subtitle", "tags": {"language": "und"}}
+		],
+		"format": {"duration": "1834.520000"}
+	}`
+
+	var result probeResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if result.duration() != 1835 {
+		t.Errorf("duration() = %d; expected 1835", result.duration())
+	}
+
+	tracks := embeddedSubtitleTracks(result.Streams)
+	if len(tracks) != 3 {
+		t.Fatalf("Expected 3 text tracks, got %d", len(tracks))
+	}
+
+	expected := []struct {
+		index    int
+		language string
+		label    string
+		forced   bool
+	}{
+		{2, "en", "English", false},
+		{3, "de", "Signs", true},
+		{5, "", "Subtitles", false},
+	}
+	for i, e := range expected {
+		track := tracks[i]
+		if track.streamIndex != e.index || track.Language != e.language || track.Label != e.label || track.Forced != e.forced {
+			t.Errorf("track %d = %d/%q/%q/%v; expected %d/%q/%q/%v", i, track.streamIndex, track.Language, track.Label,
+				track.Forced, e.index, e.language, e.label, e.forced)
+		}
+	}
+}
+
+func TestEmbeddedSubtitleExtractionIsQueued(t *testing.T) {
+	savedConfig, savedLogger := config, logger
+	defer func() { config, logger = savedConfig, savedLogger }()
+	config.ConfigDir = t.TempDir()
+	config.FFmpegPath = os.Args[0]
+	logger = log.New(io.Discard, "", 0)
+
+	videoPath := filepath.Join(t.TempDir(), "film.mkv")
+	if err := os.WriteFile(videoPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var queued []string
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM subtitle_tracks"):
+			return make([]string, 10), [][]driver.Value{{int64(7), int64(3), "en", "English", "subrip", subtitleSourceEmbedded,
+				false, false, videoPath, int64(2)}}, nil
+		case strings.Contains(query, "SELECT COALESCE"):
+			return []string{"failed"}, [][]driver.Value{{false}}, nil
+		case strings.Contains(query, "INSERT INTO jobs"):
+			queued = append(queued, args[1].(string))
+			return nil, [][]driver.Value{{}}, nil
+		}
+		t.Errorf("unexpected query: %s", query)
+		return nil, nil, nil
+	})
+	router := newRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/videos/3/subtitles/7.vtt", nil))
+	if rec.Code != http.StatusAccepted || len(queued) != 1 || queued[0] != `subtitle:3:{"track":7}` {
+		t.Fatalf("GET before extraction = %d, queued %q; expected 202 and a subtitle job", rec.Code, queued)
+	}
+
+	cachePath := subtitleCachePath(3, 2)
+	os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err := os.WriteFile(cachePath, []byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/videos/3/subtitles/7.vtt", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Hello") || len(queued) != 1 {
+		t.Errorf("GET after extraction = %d %q; expected the cached track", rec.Code, rec.Body.String())
+	}
+}
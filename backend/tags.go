@@ -0,0 +1,711 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Tag sources. Scans only ever rewrite auto and sidecar tags, so tags added
+// by people survive rescans.
+const (
+	tagSourceAuto    = "auto"    // derived from directory names
+	tagSourceSidecar = "sidecar" // read from .nfo/.json sidecars
+	tagSourceAdmin   = "admin"   // set through PATCH /api/videos/{id} or the tag endpoints
+	tagSourceUser    = "user"    // added by an identified user
+	// tagSourceSuppressed hides an auto or sidecar tag an admin removed
+	tagSourceSuppressed = "suppressed"
+)
+
+// Tag is a label attached to videos
+type Tag struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	VideoCount int    `json:"video_count"`
+}
+
+// VideoTag is a tag on one video with where it came from
+type VideoTag struct {
+	Name    string   `json:"name"`
+	Slug    string   `json:"slug"`
+	Sources []string `json:"sources"`
+}
+
+// Facet is a tag count within a video listing
+type Facet struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// facetedVideos is a video listing with tag counts across it
+type facetedVideos struct {
+	Videos []Video `json:"videos"`
+	Facets []Facet `json:"facets"`
+}
+
+// videoPage is a page of a faceted /api/v2 video listing, with tag counts
+// across every page
+type videoPage struct {
+	listPage[Video]
+	Facets []Facet `json:"facets"`
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// tagSlug builds the URL-safe identifier for a tag name
+func tagSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// directoryTags derives auto-tags from the folders between the video
+// directory and the file, skipping season folders
+func directoryTags(path string) []string {
+	root := filepath.Clean(config.VideoDir)
+	dir := filepath.Dir(path)
+
+	var parts []string
+	if rel, err := filepath.Rel(root, dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		parts = strings.Split(rel, string(filepath.Separator))
+	} else if dir != root {
+		// Outside the video directory (followed symlink): use the immediate folder
+		parts = []string{filepath.Base(dir)}
+	}
+
+	var tags []string
+	for _, part := range parts {
+		if _, isSeason := parseSeasonDir(part); isSeason {
+			continue
+		}
+		if name := cleanShowName(part); tagSlug(name) != "" {
+			tags = append(tags, name)
+		}
+	}
+	return cleanList(tags)
+}
+
+// ensureTag returns the ID of the tag with this name's slug, or of the tag
+// that slug was renamed to, creating it if needed
+func ensureTag(q querier, name string) (int, error) {
+	var id int
+	err := q.QueryRow("SELECT tag_id FROM tag_aliases WHERE slug = $1", tagSlug(name)).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	err = q.QueryRow(`
+		INSERT INTO tags (name, slug) VALUES ($1, $2)
+		ON CONFLICT (slug) DO UPDATE SET name = tags.name
+		RETURNING id
+	`, name, tagSlug(name)).Scan(&id)
+	return id, err
+}
+
+// tagAliases maps the slugs tags were renamed from to the tags' current names
+func tagAliases() (map[string]string, error) {
+	rows, err := db.Query("SELECT a.slug, t.name FROM tag_aliases a JOIN tags t ON t.id = a.tag_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var slug, name string
+		if err := rows.Scan(&slug, &name); err != nil {
+			return nil, err
+		}
+		aliases[slug] = name
+	}
+	return aliases, rows.Err()
+}
+
+// resolveTagAliases replaces names whose slug a tag was renamed from with
+// that tag's current name
+func resolveTagAliases(names []string, aliases map[string]string) []string {
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		if renamed, ok := aliases[tagSlug(name)]; ok {
+			name = renamed
+		}
+		resolved = append(resolved, name)
+	}
+	return cleanList(resolved)
+}
+
+// replaceVideoTags sets the tags a video has from one source
+func replaceVideoTags(q querier, videoID int, source string, names []string, addedBy string) error {
+	if _, err := q.Exec("DELETE FROM video_tags WHERE video_id = $1 AND source = $2", videoID, source); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if tagSlug(name) == "" {
+			continue
+		}
+		tagID, err := ensureTag(q, name)
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(`
+			INSERT INTO video_tags (video_id, tag_id, source, added_by)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, videoID, tagID, source, addedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexAutoTags refreshes directory-derived tags for every video and drops
+// tags no video uses any more. Folders named after a renamed tag get the
+// renamed tag.
+func indexAutoTags() error {
+	aliases, err := tagAliases()
+	if err != nil {
+		return fmt.Errorf("querying tag aliases: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT v.id, v.filepath,
+			COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM video_tags vt JOIN tags t ON t.id = vt.tag_id
+				WHERE vt.video_id = v.id AND vt.source = $1), '{}')
+		FROM videos v
+	`, tagSourceAuto)
+	if err != nil {
+		return fmt.Errorf("querying videos for auto-tags: %w", err)
+	}
+
+	type videoAutoTags struct {
+		id      int
+		current []string
+		wanted  []string
+	}
+	var changed []videoAutoTags
+	for rows.Next() {
+		var v videoAutoTags
+		var path string
+		var current pq.StringArray
+		if err := rows.Scan(&v.id, &path, &current); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning video for auto-tags: %w", err)
+		}
+		if config.AutoTags {
+			v.wanted = resolveTagAliases(directoryTags(path), aliases)
+		}
+		if !sameTagSet(current, v.wanted) {
+			changed = append(changed, v)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading videos for auto-tags: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, v := range changed {
+		if err := replaceVideoTags(tx, v.id, tagSourceAuto, v.wanted, ""); err != nil {
+			return fmt.Errorf("tagging video %d: %w", v.id, err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM video_tags WHERE video_tags.tag_id = tags.id)"); err != nil {
+		return fmt.Errorf("removing unused tags: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing auto-tags: %w", err)
+	}
+
+	logger.Printf("Auto-tags indexed: %d videos updated", len(changed))
+	return nil
+}
+
+// sameTagSet compares tag lists by slug, ignoring order
+func sameTagSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	slugs := make([]string, 0, len(a))
+	for _, name := range a {
+		slugs = append(slugs, tagSlug(name))
+	}
+	sort.Strings(slugs)
+	other := make([]string, 0, len(b))
+	for _, name := range b {
+		other = append(other, tagSlug(name))
+	}
+	sort.Strings(other)
+	for i := range slugs {
+		if slugs[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagFilterClause restricts a videos query (aliased v) to videos carrying
+// every one of the given tag slugs
+const tagFilterClause = `v.id IN (
+	SELECT e.video_id FROM effective_video_tags e JOIN tags t ON t.id = e.tag_id
+	WHERE t.slug = ANY($1)
+	GROUP BY e.video_id
+	HAVING COUNT(DISTINCT t.slug) = $2
+)`
+
+// loadFacets counts tags across the videos v matching where, so a page of
+// a listing carries the counts for the whole listing
+func loadFacets(where string, args []interface{}) ([]Facet, error) {
+	rows, err := db.Query(`
+		SELECT t.name, t.slug, COUNT(DISTINCT e.video_id)
+		FROM effective_video_tags e
+		JOIN tags t ON t.id = e.tag_id
+		JOIN videos v ON v.id = e.video_id
+		`+where+`
+		GROUP BY t.id
+		ORDER BY COUNT(DISTINCT e.video_id) DESC, t.name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []Facet{}
+	for rows.Next() {
+		var f Facet
+		if err := rows.Scan(&f.Name, &f.Slug, &f.Count); err != nil {
+			return nil, err
+		}
+		facets = append(facets, f)
+	}
+	return facets, rows.Err()
+}
+
+func getTags(w http.ResponseWriter, r *http.Request) {
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+	limit, args := page.limitClause(nil)
+	rows, err := db.Query(`
+		SELECT t.id, t.name, t.slug, COUNT(DISTINCT e.video_id)
+		FROM tags t
+		JOIN effective_video_tags e ON e.tag_id = t.id
+		GROUP BY t.id
+		ORDER BY t.name, t.id
+		`+limit, args...)
+	if err != nil {
+		logger.Printf("Error querying tags: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch tags")
+		return
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.VideoCount); err != nil {
+			logger.Printf("Error scanning tag: %v", err)
+			continue
+		}
+		tags = append(tags, t)
+	}
+
+	total, err := page.countRows(len(tags), `SELECT COUNT(DISTINCT tag_id) FROM effective_video_tags`)
+	if err != nil {
+		logger.Printf("Error counting tags: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch tags")
+		return
+	}
+	writePage(w, page, tags, total)
+}
+
+// loadVideoTags lists a video's visible tags with their sources
+func loadVideoTags(videoID int) ([]VideoTag, error) {
+	rows, err := db.Query(`
+		SELECT t.name, t.slug, array_agg(DISTINCT vt.source ORDER BY vt.source)
+		FROM effective_video_tags e
+		JOIN tags t ON t.id = e.tag_id
+		JOIN video_tags vt ON vt.video_id = e.video_id AND vt.tag_id = e.tag_id
+		WHERE e.video_id = $1
+		GROUP BY t.id
+		ORDER BY t.name
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []VideoTag{}
+	for rows.Next() {
+		var t VideoTag
+		var sources pq.StringArray
+		if err := rows.Scan(&t.Name, &t.Slug, &sources); err != nil {
+			return nil, err
+		}
+		t.Sources = []string(sources)
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func writeVideoTags(w http.ResponseWriter, videoID int, status int) {
+	tags, err := loadVideoTags(videoID)
+	if err != nil {
+		logger.Printf("Error querying video tags: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch tags")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tags)
+}
+
+// videoIDFromRoute parses the {id} route variable and checks the video exists,
+// writing the error response when it returns false
+func videoIDFromRoute(w http.ResponseWriter, r *http.Request) (int, bool) {
+	videoID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid video ID")
+		return 0, false
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM videos WHERE id = $1)", videoID).Scan(&exists); err != nil {
+		logger.Printf("Error checking video existence: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
+		return 0, false
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+		return 0, false
+	}
+	return videoID, true
+}
+
+func getVideoTags(w http.ResponseWriter, r *http.Request) {
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := loadVideoTags(videoID)
+	if err != nil {
+		logger.Printf("Error querying video tags: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch tags")
+		return
+	}
+	writeList(w, r, tags)
+}
+
+// addVideoTag tags a video. Admins add admin tags (lifting any suppression);
+// identified users add tags attributed to themselves.
+func addVideoTag(w http.ResponseWriter, r *http.Request) {
+	admin := isAdmin(r)
+	user := requestUser(r)
+	if !admin && user == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return
+	}
+
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	var body tagInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if tagSlug(name) == "" {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Tag name is required")
+		return
+	}
+	if len(name) > maxTagLength {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, fmt.Sprintf("Tag too long (max %d characters)", maxTagLength))
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add tag")
+		return
+	}
+	defer tx.Rollback()
+
+	tagID, err := ensureTag(tx, name)
+	if err != nil {
+		logger.Printf("Error saving tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add tag")
+		return
+	}
+
+	source := tagSourceUser
+	if admin {
+		source = tagSourceAdmin
+		if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = $1 AND tag_id = $2 AND source = $3",
+			videoID, tagID, tagSourceSuppressed); err != nil {
+			logger.Printf("Error lifting tag suppression: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add tag")
+			return
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id, source, added_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, videoID, tagID, source, user); err != nil {
+		logger.Printf("Error tagging video: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add tag")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add tag")
+		return
+	}
+
+	writeVideoTags(w, videoID, http.StatusCreated)
+}
+
+// removeVideoTag untags a video. Admins remove the tag whatever its source,
+// suppressing scanned tags so the next scan doesn't bring them back. Users
+// can only remove tags they added themselves.
+func removeVideoTag(w http.ResponseWriter, r *http.Request) {
+	admin := isAdmin(r)
+	user := requestUser(r)
+	if !admin && user == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return
+	}
+
+	videoID, ok := videoIDFromRoute(w, r)
+	if !ok {
+		return
+	}
+	slug := mux.Vars(r)["tag"]
+
+	var tagID int
+	err := db.QueryRow("SELECT id FROM tags WHERE slug = $1", slug).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Tag not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+		return
+	}
+
+	if !admin {
+		result, err := db.Exec(`
+			DELETE FROM video_tags
+			WHERE video_id = $1 AND tag_id = $2 AND source = $3 AND added_by = $4
+		`, videoID, tagID, tagSourceUser, user)
+		if err != nil {
+			logger.Printf("Error removing tag: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, http.StatusForbidden, errorCodeForbidden, "You can only remove tags you added")
+			return
+		}
+		writeVideoTags(w, videoID, http.StatusOK)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM video_tags WHERE video_id = $1 AND tag_id = $2 AND source IN ($3, $4)
+	`, videoID, tagID, tagSourceAdmin, tagSourceUser); err != nil {
+		logger.Printf("Error removing tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+		return
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id, source, added_by)
+		SELECT $1, $2, $3, $4
+		WHERE EXISTS (SELECT 1 FROM video_tags WHERE video_id = $1 AND tag_id = $2 AND source IN ($5, $6))
+		ON CONFLICT DO NOTHING
+	`, videoID, tagID, tagSourceSuppressed, user, tagSourceAuto, tagSourceSidecar); err != nil {
+		logger.Printf("Error suppressing tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing tag removal: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove tag")
+		return
+	}
+
+	writeVideoTags(w, videoID, http.StatusOK)
+}
+
+// renameTag changes a tag's display name (admin). The slug follows the name,
+// and renaming onto an existing tag merges the two.
+func renameTag(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["tag"]
+
+	var body tagInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	newSlug := tagSlug(name)
+	if newSlug == "" {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "Tag name is required")
+		return
+	}
+	if len(name) > maxTagLength {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, fmt.Sprintf("Tag too long (max %d characters)", maxTagLength))
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+		return
+	}
+	defer tx.Rollback()
+
+	var tagID int
+	err = tx.QueryRow("SELECT id FROM tags WHERE slug = $1", slug).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Tag not found")
+		return
+	} else if err != nil {
+		logger.Printf("Error fetching tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+		return
+	}
+
+	var targetID int
+	err = tx.QueryRow("SELECT id FROM tags WHERE slug = $1 AND id <> $2", newSlug, tagID).Scan(&targetID)
+	if err == nil {
+		// Merge into the existing tag
+		if _, err := tx.Exec(`
+			INSERT INTO video_tags (video_id, tag_id, source, added_by, created_at)
+			SELECT video_id, $1, source, added_by, created_at FROM video_tags WHERE tag_id = $2
+			ON CONFLICT DO NOTHING
+		`, targetID, tagID); err != nil {
+			logger.Printf("Error merging tags: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+			return
+		}
+		if _, err := tx.Exec("UPDATE tag_aliases SET tag_id = $1 WHERE tag_id = $2", targetID, tagID); err != nil {
+			logger.Printf("Error merging tag aliases: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM tags WHERE id = $1", tagID); err != nil {
+			logger.Printf("Error removing merged tag: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+			return
+		}
+		tagID = targetID
+	} else if err != sql.ErrNoRows {
+		logger.Printf("Error checking tag name: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE tags SET name = $1, slug = $2 WHERE id = $3", name, newSlug, tagID); err != nil {
+		logger.Printf("Error renaming tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+		return
+	}
+
+	// Remember the old slug so scans and sidecars that still use it keep
+	// adding the renamed tag
+	if slug != newSlug {
+		if _, err := tx.Exec("DELETE FROM tag_aliases WHERE slug = $1", newSlug); err != nil {
+			logger.Printf("Error updating tag aliases: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+			return
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO tag_aliases (slug, tag_id) VALUES ($1, $2)
+			ON CONFLICT (slug) DO UPDATE SET tag_id = EXCLUDED.tag_id
+		`, slug, tagID); err != nil {
+			logger.Printf("Error updating tag aliases: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing tag rename: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to rename tag")
+		return
+	}
+
+	logger.Printf("Renamed tag %s to %s", slug, newSlug)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Tag{ID: tagID, Name: name, Slug: newSlug})
+}
+
+// deleteTag removes a tag from every video (admin). Scanned tags come back
+// on the next scan unless their directory or sidecar changes.
+func deleteTag(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["tag"]
+
+	result, err := db.Exec("DELETE FROM tags WHERE slug = $1", slug)
+	if err != nil {
+		logger.Printf("Error deleting tag: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to delete tag")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Tag not found")
+		return
+	}
+
+	logger.Printf("Deleted tag %s", slug)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statusResponse{Status: "success"})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql/driver"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTagSlug(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Sci-Fi", "sci-fi"},
+		{"  Science Fiction  ", "science-fiction"},
+		{"Rock & Roll", "rock-roll"},
+		{"C++", "c"},
+		{"Café", "café"},
+		{"---", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		result := tagSlug(test.name)
+		if result != test.expected {
+			t.Errorf("tagSlug(%q) = %q; expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestDirectoryTags(t *testing.T) {
+	saved := config.VideoDir
+	config.VideoDir = "/videos"
+	defer func() { config.VideoDir = saved }()
+
+	tests := []struct {
+		path     string
+		expected []string
+	}{
+		{"/videos/movie.mp4", nil},
+		{"/videos/Documentaries/Nature/planet.mp4", []string{"Documentaries", "Nature"}},
+		{"/videos/TV/The_Office/Season 2/S02E01.mkv", []string{"TV", "The Office"}},
+		{"/videos/Anime/anime/ep1.mkv", []string{"Anime"}},
+		{"/mnt/elsewhere/Concerts/live.mp4", []string{"Concerts"}},
+	}
+
+	for _, test := range tests {
+		result := directoryTags(test.path)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("directoryTags(%q) = %v; expected %v", test.path, result, test.expected)
+		}
+	}
+}
+
+func TestSameTagSet(t *testing.T) {
+	tests := []struct {
+		a, b     []string
+		expected bool
+	}{
+		{nil, nil, true},
+		{[]string{"Nature", "Docs"}, []string{"docs", "nature"}, true},
+		{[]string{"Nature"}, []string{"Nature", "Docs"}, false},
+		{[]string{"Nature"}, []string{"Travel"}, false},
+	}
+
+	for _, test := range tests {
+		result := sameTagSet(test.a, test.b)
+		if result != test.expected {
+			t.Errorf("sameTagSet(%v, %v) = %v; expected %v", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestRenamedAutoTagSurvivesReindex(t *testing.T) {
+	savedConfig, savedLogger := config, logger
+	defer func() { config, logger = savedConfig, savedLogger }()
+	config.AdminToken = "secret"
+	config.AutoTags = true
+	config.VideoDir = "/videos"
+	logger = log.New(io.Discard, "", 0)
+
+	type tag struct {
+		id   int64
+		name string
+	}
+	tags := map[string]tag{"docs": {1, "Docs"}}
+	aliases := map[string]int64{}
+	videoTags := map[int64][]int64{1: {1}}
+	var retagged []int64
+	nameOf := func(id int64) string {
+		for _, t := range tags {
+			if t.id == id {
+				return t.name
+			}
+		}
+		return ""
+	}
+
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT id FROM tags WHERE slug = $1 AND id <> $2"):
+			return nil, nil, nil
+		case strings.Contains(query, "SELECT id FROM tags WHERE slug = $1"):
+			if t, ok := tags[args[0].(string)]; ok {
+				return []string{"id"}, [][]driver.Value{{t.id}}, nil
+			}
+			return nil, nil, nil
+		case strings.Contains(query, "UPDATE tags SET name"):
+			for slug, t := range tags {
+				if t.id == args[2].(int64) {
+					delete(tags, slug)
+				}
+			}
+			tags[args[1].(string)] = tag{args[2].(int64), args[0].(string)}
+			return nil, [][]driver.Value{{}}, nil
+		case strings.Contains(query, "DELETE FROM tag_aliases"):
+			delete(aliases, args[0].(string))
+			return nil, nil, nil
+		case strings.Contains(query, "INSERT INTO tag_aliases"):
+			aliases[args[0].(string)] = args[1].(int64)
+			return nil, [][]driver.Value{{}}, nil
+		case strings.Contains(query, "FROM tag_aliases a JOIN tags t"):
+			var rows [][]driver.Value
+			for slug, id := range aliases {
+				rows = append(rows, []driver.Value{slug, nameOf(id)})
+			}
+			return []string{"slug", "name"}, rows, nil
+		case strings.Contains(query, "SELECT tag_id FROM tag_aliases"):
+			if id, ok := aliases[args[0].(string)]; ok {
+				return []string{"tag_id"}, [][]driver.Value{{id}}, nil
+			}
+			return nil, nil, nil
+		case strings.Contains(query, "FROM videos v"):
+			var rows [][]driver.Value
+			for _, id := range []int64{1, 2} {
+				var names []string
+				for _, tagID := range videoTags[id] {
+					names = append(names, nameOf(tagID))
+				}
+				rows = append(rows, []driver.Value{id, "/videos/Docs/film" + strconv.FormatInt(id, 10) + ".mp4", []byte("{" + strings.Join(names, ",") + "}")})
+			}
+			return []string{"id", "filepath", "tags"}, rows, nil
+		case strings.Contains(query, "DELETE FROM video_tags"):
+			retagged = append(retagged, args[0].(int64))
+			delete(videoTags, args[0].(int64))
+			return nil, nil, nil
+		case strings.Contains(query, "INSERT INTO tags"):
+			slug := args[1].(string)
+			if _, ok := tags[slug]; !ok {
+				tags[slug] = tag{int64(len(tags) + 10), args[0].(string)}
+			}
+			return []string{"id"}, [][]driver.Value{{tags[slug].id}}, nil
+		case strings.Contains(query, "INSERT INTO video_tags"):
+			videoTags[args[0].(int64)] = append(videoTags[args[0].(int64)], args[1].(int64))
+			return nil, [][]driver.Value{{}}, nil
+		case strings.Contains(query, "DELETE FROM tags WHERE NOT EXISTS"):
+			return nil, nil, nil
+		}
+		t.Errorf("unexpected query: %s", query)
+		return nil, nil, nil
+	})
+
+	req := httptest.NewRequest("PATCH", "/api/tags/docs", strings.NewReader(`{"name": "Documentaries"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := indexAutoTags(); err != nil {
+		t.Fatalf("indexAutoTags failed: %v", err)
+	}
+	if _, ok := tags["docs"]; ok || len(tags) != 1 {
+		t.Errorf("Expected only the renamed tag, got %+v", tags)
+	}
+	for _, id := range []int64{1, 2} {
+		if !reflect.DeepEqual(videoTags[id], []int64{1}) {
+			t.Errorf("Video %d has tags %v; expected the renamed tag", id, videoTags[id])
+		}
+	}
+	if !reflect.DeepEqual(retagged, []int64{2}) {
+		t.Errorf("Retagged videos %v; expected only the untagged video", retagged)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	trickplayDir      = "trickplay"
+	trickplayManifest = "manifest.json"
+
+	// Sprite sheets are grids of fixed-size tiles so the index can address
+	// each frame without knowing the video's aspect ratio
+	trickplayTileWidth  = 160
+	trickplayTileHeight = 90
+	trickplayColumns    = 10
+	trickplayRows       = 10
+
+	trickplayTimeout = time.Hour
+)
+
+// trickplayInfo describes a generated set of sprite sheets. The source stamp
+// tells whether the sprites are still current.
+type trickplayInfo struct {
+	Interval   int `json:"interval"`
+	TileWidth  int `json:"tile_width"`
+	TileHeight int `json:"tile_height"`
+	Columns    int `json:"columns"`
+	Rows       int `json:"rows"`
+	Frames     int `json:"frames"`
+	Duration   int `json:"duration"`
+	sourceStamp
+}
+
+// newTrickplayInfo lays out the frames for a video of the given duration
+func newTrickplayInfo(duration, interval int) trickplayInfo {
+	return trickplayInfo{
+		Interval:   interval,
+		TileWidth:  trickplayTileWidth,
+		TileHeight: trickplayTileHeight,
+		Columns:    trickplayColumns,
+		Rows:       trickplayRows,
+		Frames:     (duration + interval - 1) / interval,
+		Duration:   duration,
+	}
+}
+
+// sprites returns the number of sprite sheets
+func (t trickplayInfo) sprites() int {
+	perSprite := t.Columns * t.Rows
+	return (t.Frames + perSprite - 1) / perSprite
+}
+
+// vtt renders the thumbnails index. Sprite URLs are relative to the index,
+// which is served from /api/videos/{id}/trickplay.vtt.
+func (t trickplayInfo) vtt() string {
+	perSprite := t.Columns * t.Rows
+	cues := make([]subtitleCue, 0, t.Frames)
+	for i := 0; i < t.Frames; i++ {
+		start := i * t.Interval
+		end := start + t.Interval
+		if end > t.Duration {
+			end = t.Duration
+		}
+		tile := i % perSprite
+		cues = append(cues, subtitleCue{
+			Start: time.Duration(start) * time.Second,
+			End:   time.Duration(end) * time.Second,
+			Text: fmt.Sprintf("trickplay/%d.jpg#xywh=%d,%d,%d,%d", i/perSprite+1,
+				tile%t.Columns*t.TileWidth, tile/t.Columns*t.TileHeight, t.TileWidth, t.TileHeight),
+		})
+	}
+	return renderVTT(cues)
+}
+
+func trickplayPath(videoID int, name string) string {
+	return filepath.Join(videoCacheDir(videoID), trickplayDir, name)
+}
+
+// loadTrickplayInfo reads the manifest of a video's generated sprites
+func loadTrickplayInfo(videoID int) (trickplayInfo, error) {
+	var info trickplayInfo
+	data, err := os.ReadFile(trickplayPath(videoID, trickplayManifest))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// queueTrickplay schedules sprite generation for a video
+func queueTrickplay(videoID, priority int) (bool, error) {
+	return enqueueJob("trickplay", videoID, nil, priority)
+}
+
+// runTrickplayJob is the job handler for sprite generation
+func runTrickplayJob(ctx context.Context, job Job) error {
+	videoID, err := jobVideoID(job)
+	if err != nil {
+		return err
+	}
+	return generateTrickplay(ctx, videoID)
+}
+
+// generateTrickplay renders a video's sprite sheets with ffmpeg. Sprites are
+// written to a temporary directory and swapped in once complete.
+func generateTrickplay(ctx context.Context, videoID int) error {
+	var videoPath string
+	var duration int
+	if err := db.QueryRow("SELECT filepath, duration FROM videos WHERE id = $1", videoID).Scan(&videoPath, &duration); err != nil {
+		return fmt.Errorf("loading video: %w", err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration unknown")
+	}
+
+	fileInfo, err := os.Stat(videoPath)
+	if err != nil {
+		return fmt.Errorf("video not accessible: %w", err)
+	}
+	if existing, err := loadTrickplayInfo(videoID); err == nil && existing.current(fileInfo) {
+		return nil
+	}
+
+	info := newTrickplayInfo(duration, config.TrickplayInterval)
+	info.sourceStamp = newSourceStamp(fileInfo)
+
+	finalDir := filepath.Dir(trickplayPath(videoID, trickplayManifest))
+	tmpDir := finalDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx, cancel := context.WithTimeout(ctx, trickplayTimeout)
+	defer cancel()
+
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		info.Interval, info.TileWidth, info.TileHeight, info.TileWidth, info.TileHeight, info.Columns, info.Rows)
+	output, err := lowPriorityCommand(ctx,
+		"-v", "error",
+		"-threads", "1",
+		"-skip_frame", "nokey",
+		"-i", videoPath,
+		"-an", "-sn",
+		"-vf", filter,
+		"-q:v", "5",
+		filepath.Join(tmpDir, "%d.jpg"),
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	manifest, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, trickplayManifest), manifest, 0644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	os.RemoveAll(finalDir)
+	if err := os.Rename(tmpDir, finalDir); err != nil {
+		return fmt.Errorf("moving sprites into place: %w", err)
+	}
+	return nil
+}
+
+// getTrickplayVTT serves the seek-preview thumbnails index. When the sprites
+// haven't been generated yet (or the video changed) generation is queued and
+// 202 Accepted is returned; clients should try again later.
+func getTrickplayVTT(w http.ResponseWriter, r *http.Request) {
+	videoID, fileInfo, ok := videoFileInfo(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := loadTrickplayInfo(videoID)
+	if err == nil && info.current(fileInfo) {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write([]byte(info.vtt()))
+		return
+	}
+
+	if !durationKnown(w, videoID) {
+		return
+	}
+	queueMediaResponse(w, videoID, "Trickplay", func() (bool, error) {
+		return queueTrickplay(videoID, jobPriorityRequest)
+	})
+}
+
+// getTrickplaySprite serves one sprite sheet
+func getTrickplaySprite(w http.ResponseWriter, r *http.Request) {
+	videoID, fileInfo, ok := videoFileInfo(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := loadTrickplayInfo(videoID)
+	if err != nil || !info.current(fileInfo) {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Sprite not found")
+		return
+	}
+
+	sprite, _ := strconv.Atoi(mux.Vars(r)["sprite"])
+	if sprite < 1 || sprite > info.sprites() {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Sprite not found")
+		return
+	}
+
+	f, err := os.Open(trickplayPath(videoID, fmt.Sprintf("%d.jpg", sprite)))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Sprite not found")
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Sprite not found")
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, filepath.Base(f.Name()), stat.ModTime(), f)
+}
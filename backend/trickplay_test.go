@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrickplayLayout(t *testing.T) {
+	tests := []struct {
+		duration, interval int
+		frames, sprites    int
+	}{
+		{5, 10, 1, 1},
+		{10, 10, 1, 1},
+		{11, 10, 2, 1},
+		{1000, 10, 100, 1},
+		{1001, 10, 101, 2},
+		{7200, 5, 1440, 15},
+	}
+
+	for _, test := range tests {
+		info := newTrickplayInfo(test.duration, test.interval)
+		if info.Frames != test.frames || info.sprites() != test.sprites {
+			t.Errorf("newTrickplayInfo(%d, %d) = %d frames, %d sprites; expected %d, %d",
+				test.duration, test.interval, info.Frames, info.sprites(), test.frames, test.sprites)
+		}
+	}
+}
+
+func TestTrickplayVTT(t *testing.T) {
+	info := newTrickplayInfo(1005, 10)
+	vtt := info.vtt()
+
+	expected := []string{
+		"WEBVTT\n",
+		"00:00:00.000 --> 00:00:10.000\ntrickplay/1.jpg#xywh=0,0,160,90\n",
+		"00:00:10.000 --> 00:00:20.000\ntrickplay/1.jpg#xywh=160,0,160,90\n",
+		"00:01:40.000 --> 00:01:50.000\ntrickplay/1.jpg#xywh=0,90,160,90\n",
+		"00:16:30.000 --> 00:16:40.000\ntrickplay/1.jpg#xywh=1440,810,160,90\n",
+		"00:16:40.000 --> 00:16:45.000\ntrickplay/2.jpg#xywh=0,0,160,90\n",
+	}
+	for _, cue := range expected {
+		if !strings.Contains(vtt, cue) {
+			t.Errorf("Expected trickplay index to contain %q", cue)
+		}
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// playlistSourceAuto marks playlists grouped from file names
+	playlistSourceAuto = "auto"
+	// playlistSourceUser marks playlists curated through the API
+	playlistSourceUser = "user"
+
+	// userPlaylistPrefix distinguishes curated playlist IDs from the md5-based "pl_" IDs
+	userPlaylistPrefix = "up_"
+
+	visibilityPrivate = "private"
+	visibilityShared  = "shared"
+
+	maxPlaylistNameLength = 200
+)
+
+// userPlaylistColumns selects a curated playlist with its items in position order
+const userPlaylistColumns = `
+	SELECT p.id, p.name, p.owner, p.visibility, p.created_at, p.updated_at,
+		COALESCE(SUM(v.duration), 0),
+		COALESCE(array_agg(i.video_id ORDER BY i.position) FILTER (WHERE i.video_id IS NOT NULL), '{}')
+	FROM user_playlists p
+	LEFT JOIN user_playlist_items i ON i.playlist_id = p.id
+	LEFT JOIN videos v ON v.id = i.video_id
+`
+
+// parseUserPlaylistID extracts the numeric ID from an "up_" playlist ID
+func parseUserPlaylistID(playlistID string) (int, bool) {
+	if !strings.HasPrefix(playlistID, userPlaylistPrefix) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(playlistID, userPlaylistPrefix))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// formatUserPlaylistID builds the public ID for a curated playlist
+func formatUserPlaylistID(id int) string {
+	return fmt.Sprintf("%s%d", userPlaylistPrefix, id)
+}
+
+// scanUserPlaylist reads a row produced by userPlaylistColumns
+func scanUserPlaylist(row interface{ Scan(...interface{}) error }) (Playlist, error) {
+	var p Playlist
+	var id int
+	var videoIDs pq.Int64Array
+	if err := row.Scan(&id, &p.Name, &p.Owner, &p.Visibility, &p.CreatedAt, &p.UpdatedAt, &p.TotalDuration, &videoIDs); err != nil {
+		return p, err
+	}
+
+	p.ID = formatUserPlaylistID(id)
+	p.Source = playlistSourceUser
+	setPlaylistVideos(&p, videoIDs)
+	return p, nil
+}
+
+// loadUserPlaylists returns the shared playlists plus the ones owned by user,
+// those on page or every one when page is nil
+func loadUserPlaylists(user string, page *pageParams) ([]Playlist, error) {
+	limit, args := page.limitClause([]interface{}{visibilityShared, user})
+	rows, err := db.Query(userPlaylistColumns+`
+		WHERE p.visibility = $1 OR p.owner = $2
+		GROUP BY p.id
+		ORDER BY p.name, p.id
+		`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		p, err := scanUserPlaylist(rows)
+		if err != nil {
+			logger.Printf("Error scanning user playlist: %v", err)
+			continue
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+// loadUserPlaylist returns a single curated playlist regardless of visibility
+func loadUserPlaylist(id int) (Playlist, error) {
+	row := db.QueryRow(userPlaylistColumns+`
+		WHERE p.id = $1
+		GROUP BY p.id
+	`, id)
+	return scanUserPlaylist(row)
+}
+
+func canViewUserPlaylist(p Playlist, r *http.Request) bool {
+	if p.Visibility == visibilityShared || isAdmin(r) {
+		return true
+	}
+	user := requestUser(r)
+	return user != "" && user == p.Owner
+}
+
+func canEditUserPlaylist(p Playlist, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+	user := requestUser(r)
+	return user != "" && user == p.Owner
+}
+
+// playlistForEdit loads the playlist named in the route and checks that the
+// caller may modify it, writing the error response when it returns false
+func playlistForEdit(w http.ResponseWriter, r *http.Request) (Playlist, int, bool) {
+	playlistID := mux.Vars(r)["playlistId"]
+
+	id, ok := parseUserPlaylistID(playlistID)
+	if !ok {
+		if strings.HasPrefix(playlistID, "pl_") {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, "Auto-generated playlists cannot be modified")
+		} else {
+			writeError(w, http.StatusNotFound, errorCodeNotFound, "Playlist not found")
+		}
+		return Playlist{}, 0, false
+	}
+
+	playlist, err := loadUserPlaylist(id)
+	if err == sql.ErrNoRows || (err == nil && !canViewUserPlaylist(playlist, r)) {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Playlist not found")
+		return Playlist{}, 0, false
+	} else if err != nil {
+		logger.Printf("Error fetching user playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlist")
+		return Playlist{}, 0, false
+	}
+
+	if !canEditUserPlaylist(playlist, r) {
+		writeError(w, http.StatusForbidden, errorCodeForbidden, "Only the playlist owner can modify it")
+		return Playlist{}, 0, false
+	}
+
+	return playlist, id, true
+}
+
+// validatePlaylistName trims the name and checks its length
+func validatePlaylistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("Playlist name is required")
+	}
+	if len(name) > maxPlaylistNameLength {
+		return "", fmt.Errorf("Playlist name too long (max %d characters)", maxPlaylistNameLength)
+	}
+	return name, nil
+}
+
+// validateVisibility defaults an empty value to private
+func validateVisibility(visibility string) (string, error) {
+	switch visibility {
+	case "":
+		return visibilityPrivate, nil
+	case visibilityPrivate, visibilityShared:
+		return visibility, nil
+	}
+	return "", fmt.Errorf("Visibility must be %q or %q", visibilityPrivate, visibilityShared)
+}
+
+// writeUserPlaylist reloads the playlist and writes it as the response
+func writeUserPlaylist(w http.ResponseWriter, id int, status int) {
+	playlist, err := loadUserPlaylist(id)
+	if err != nil {
+		logger.Printf("Error reloading user playlist %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch playlist")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(playlist)
+}
+
+func createPlaylist(w http.ResponseWriter, r *http.Request) {
+	owner := requestUser(r)
+	if owner == "" {
+		writeError(w, http.StatusUnauthorized, errorCodeIdentityRequired, "User identity required (set the "+userHeader+" header)")
+		return
+	}
+
+	var body playlistInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	name, err := validatePlaylistName(body.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+	visibility, err := validateVisibility(body.Visibility)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to create playlist")
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO user_playlists (name, owner, visibility)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, name, owner, visibility).Scan(&id)
+	if err != nil {
+		logger.Printf("Error inserting playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to create playlist")
+		return
+	}
+
+	seen := make(map[int]bool)
+	position := 0
+	for _, videoID := range body.VideoIDs {
+		if seen[videoID] {
+			continue
+		}
+		seen[videoID] = true
+
+		result, err := tx.Exec(`
+			INSERT INTO user_playlist_items (playlist_id, video_id, position)
+			SELECT $1, id, $3 FROM videos WHERE id = $2
+		`, id, videoID, position)
+		if err != nil {
+			logger.Printf("Error inserting playlist item: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to create playlist")
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, fmt.Sprintf("Video %d not found", videoID))
+			return
+		}
+		position++
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to create playlist")
+		return
+	}
+
+	logger.Printf("Created playlist %s for %s", formatUserPlaylistID(id), owner)
+	writeUserPlaylist(w, id, http.StatusCreated)
+}
+
+func updatePlaylist(w http.ResponseWriter, r *http.Request) {
+	_, id, ok := playlistForEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var body playlistUpdateInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if body.Name != nil {
+		name, err := validatePlaylistName(*body.Name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+			return
+		}
+		body.Name = &name
+	}
+	if body.Visibility != nil {
+		if *body.Visibility == "" {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, "Visibility cannot be empty")
+			return
+		}
+		if _, err := validateVisibility(*body.Visibility); err != nil {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+			return
+		}
+	}
+
+	_, err := db.Exec(`
+		UPDATE user_playlists
+		SET name = COALESCE($1, name), visibility = COALESCE($2, visibility), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, body.Name, body.Visibility, id)
+	if err != nil {
+		logger.Printf("Error updating playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update playlist")
+		return
+	}
+
+	writeUserPlaylist(w, id, http.StatusOK)
+}
+
+func deletePlaylist(w http.ResponseWriter, r *http.Request) {
+	_, id, ok := playlistForEdit(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM user_playlists WHERE id = $1", id); err != nil {
+		logger.Printf("Error deleting playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to delete playlist")
+		return
+	}
+
+	logger.Printf("Deleted playlist %s", formatUserPlaylistID(id))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statusResponse{Status: "success"})
+}
+
+func addPlaylistItem(w http.ResponseWriter, r *http.Request) {
+	playlist, id, ok := playlistForEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var body playlistItemInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	for _, videoID := range playlist.VideoIDs {
+		if videoID == body.VideoID {
+			writeError(w, http.StatusConflict, errorCodeConflict, "Video already in playlist")
+			return
+		}
+	}
+
+	position := len(playlist.VideoIDs)
+	if body.Position != nil {
+		if *body.Position < 0 || *body.Position > len(playlist.VideoIDs) {
+			writeError(w, http.StatusBadRequest, errorCodeValidation, "Position out of range")
+			return
+		}
+		position = *body.Position
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM videos WHERE id = $1)", body.VideoID).Scan(&exists); err != nil {
+		logger.Printf("Error checking video existence: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE user_playlist_items SET position = position + 1
+		WHERE playlist_id = $1 AND position >= $2
+	`, id, position); err != nil {
+		logger.Printf("Error shifting playlist items: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_playlist_items (playlist_id, video_id, position)
+		VALUES ($1, $2, $3)
+	`, id, body.VideoID, position); err != nil {
+		logger.Printf("Error inserting playlist item: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE user_playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", id); err != nil {
+		logger.Printf("Error touching playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing playlist item: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to add video to playlist")
+		return
+	}
+
+	writeUserPlaylist(w, id, http.StatusOK)
+}
+
+func removePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	_, id, ok := playlistForEdit(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := strconv.Atoi(mux.Vars(r)["videoId"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid video ID")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove video from playlist")
+		return
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRow(`
+		DELETE FROM user_playlist_items
+		WHERE playlist_id = $1 AND video_id = $2
+		RETURNING position
+	`, id, videoID).Scan(&position)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not in playlist")
+		return
+	} else if err != nil {
+		logger.Printf("Error removing playlist item: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove video from playlist")
+		return
+	}
+
+	// Close the gap left by the removed item
+	if _, err := tx.Exec(`
+		UPDATE user_playlist_items SET position = position - 1
+		WHERE playlist_id = $1 AND position > $2
+	`, id, position); err != nil {
+		logger.Printf("Error shifting playlist items: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove video from playlist")
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE user_playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", id); err != nil {
+		logger.Printf("Error touching playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove video from playlist")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing playlist item removal: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to remove video from playlist")
+		return
+	}
+
+	writeUserPlaylist(w, id, http.StatusOK)
+}
+
+func reorderPlaylistItems(w http.ResponseWriter, r *http.Request) {
+	playlist, id, ok := playlistForEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var body videoIDsInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if !isPermutation(playlist.VideoIDs, body.VideoIDs) {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, "video_ids must contain exactly the playlist's current videos")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to reorder playlist")
+		return
+	}
+	defer tx.Rollback()
+
+	for position, videoID := range body.VideoIDs {
+		if _, err := tx.Exec(`
+			UPDATE user_playlist_items SET position = $1
+			WHERE playlist_id = $2 AND video_id = $3
+		`, position, id, videoID); err != nil {
+			logger.Printf("Error reordering playlist item: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to reorder playlist")
+			return
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE user_playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", id); err != nil {
+		logger.Printf("Error touching playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to reorder playlist")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing playlist order: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to reorder playlist")
+		return
+	}
+
+	writeUserPlaylist(w, id, http.StatusOK)
+}
+
+// isPermutation reports whether b contains exactly the elements of a, each once
+func isPermutation(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[int]int, len(a))
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		counts[v]--
+		if counts[v] < 0 {
+			return false
+		}
+	}
+	return true
+}
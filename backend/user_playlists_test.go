@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUserPlaylistID(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+		ok       bool
+	}{
+		{"up_1", 1, true},
+		{"up_42", 42, true},
+		{"up_0", 0, false},
+		{"up_-3", 0, false},
+		{"up_abc", 0, false},
+		{"pl_0123456789ab", 0, false},
+		{"42", 0, false},
+	}
+
+	for _, test := range tests {
+		id, ok := parseUserPlaylistID(test.input)
+		if id != test.expected || ok != test.ok {
+			t.Errorf("parseUserPlaylistID(%q) = %d, %v; expected %d, %v", test.input, id, ok, test.expected, test.ok)
+		}
+	}
+
+	if got := formatUserPlaylistID(7); got != "up_7" {
+		t.Errorf("formatUserPlaylistID(7) = %q; expected %q", got, "up_7")
+	}
+}
+
+func TestValidateVisibility(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"", visibilityPrivate, true},
+		{"private", visibilityPrivate, true},
+		{"shared", visibilityShared, true},
+		{"public", "", false},
+	}
+
+	for _, test := range tests {
+		result, err := validateVisibility(test.input)
+		if result != test.expected || (err == nil) != test.valid {
+			t.Errorf("validateVisibility(%q) = %q, %v; expected %q, valid=%v", test.input, result, err, test.expected, test.valid)
+		}
+	}
+}
+
+func TestValidatePlaylistName(t *testing.T) {
+	if name, err := validatePlaylistName("  Week 1  "); err != nil || name != "Week 1" {
+		t.Errorf("Expected trimmed name, got %q, %v", name, err)
+	}
+	if _, err := validatePlaylistName("   "); err == nil {
+		t.Error("Expected error for blank name")
+	}
+	if _, err := validatePlaylistName(strings.Repeat("a", maxPlaylistNameLength+1)); err == nil {
+		t.Error("Expected error for overlong name")
+	}
+}
+
+func TestIsPermutation(t *testing.T) {
+	tests := []struct {
+		a, b     []int
+		expected bool
+	}{
+		{[]int{1, 2, 3}, []int{3, 1, 2}, true},
+		{[]int{}, []int{}, true},
+		{[]int{1, 2, 3}, []int{1, 2}, false},
+		{[]int{1, 2, 3}, []int{1, 2, 2}, false},
+		{[]int{1, 2, 3}, []int{1, 2, 4}, false},
+	}
+
+	for _, test := range tests {
+		if result := isPermutation(test.a, test.b); result != test.expected {
+			t.Errorf("isPermutation(%v, %v) = %v; expected %v", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestPlaylistPermissions(t *testing.T) {
+	saved := config.AdminToken
+	config.AdminToken = "secret"
+	defer func() { config.AdminToken = saved }()
+
+	private := Playlist{Owner: "alice", Visibility: visibilityPrivate}
+	shared := Playlist{Owner: "alice", Visibility: visibilityShared}
+
+	owner := httptest.NewRequest("GET", "/", nil)
+	owner.Header.Set(userHeader, "alice")
+	other := httptest.NewRequest("GET", "/", nil)
+	other.Header.Set(userHeader, "bob")
+	anonymous := httptest.NewRequest("GET", "/", nil)
+	admin := httptest.NewRequest("GET", "/", nil)
+	admin.Header.Set("Authorization", "Bearer secret")
+
+	if !canViewUserPlaylist(private, owner) || !canEditUserPlaylist(private, owner) {
+		t.Error("Owner should be able to view and edit their playlist")
+	}
+	if canViewUserPlaylist(private, other) || canViewUserPlaylist(private, anonymous) {
+		t.Error("Private playlist should be hidden from other users")
+	}
+	if !canViewUserPlaylist(shared, other) || canEditUserPlaylist(shared, other) {
+		t.Error("Shared playlist should be viewable but not editable by other users")
+	}
+	if !canViewUserPlaylist(private, admin) || !canEditUserPlaylist(private, admin) {
+		t.Error("Admin should be able to view and edit any playlist")
+	}
+}
+
+func TestGetPlaylistThroughRouter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		columns := []string{"id", "name", "c3", "c4", "created_at", "updated_at", "c7", "video_ids"}
+		switch {
+		case strings.Contains(query, "user_playlists") && len(args) == 1 && args[0] == int64(7):
+			return columns, [][]driver.Value{{int64(7), "Mine", "alice", visibilityShared, created, created, int64(90), []byte("{3,1}")}}, nil
+		case strings.Contains(query, "auto_playlists") && len(args) == 1 && args[0] == "pl_abc":
+			return columns, [][]driver.Value{{"pl_abc", "Show", "/videos/show", int64(60), created, created, false, []byte("{2}")}}, nil
+		}
+		return columns, nil, nil
+	})
+
+	router := newRouter()
+	for _, test := range []struct {
+		id     string
+		source string
+	}{
+		{"up_7", playlistSourceUser},
+		{"pl_abc", playlistSourceAuto},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/playlists/"+test.id, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET /api/v1/playlists/%s = %d; expected 200 (%s)", test.id, rec.Code, rec.Body.String())
+			continue
+		}
+		var p Playlist
+		json.Unmarshal(rec.Body.Bytes(), &p)
+		if p.ID != test.id || p.Source != test.source {
+			t.Errorf("GET /api/v1/playlists/%s returned %q from %q", test.id, p.ID, p.Source)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/playlists/up_8", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing playlist = %d; expected 404", rec.Code)
+	}
+}
+
+func TestGetPlaylistsPagesAcrossSources(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	auto := [][]driver.Value{
+		{"pl_a", "A", "/videos/a", int64(60), created, created, false, []byte("{1}")},
+		{"pl_b", "B", "/videos/b", int64(60), created, created, false, []byte("{2}")},
+		{"pl_c", "C", "/videos/c", int64(60), created, created, false, []byte("{3}")},
+	}
+	curated := [][]driver.Value{
+		{int64(1), "Mine", "alice", visibilityShared, created, created, int64(90), []byte("{3,1}")},
+		{int64(2), "Theirs", "bob", visibilityShared, created, created, int64(30), []byte("{2}")},
+	}
+	// page answers a query ending in LIMIT and OFFSET with those rows
+	page := func(rows [][]driver.Value, args []driver.Value) [][]driver.Value {
+		limit, offset := int(args[len(args)-2].(int64)), int(args[len(args)-1].(int64))
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		if offset+limit < len(rows) {
+			return rows[offset : offset+limit]
+		}
+		return rows[offset:]
+	}
+	columns := []string{"id", "name", "c3", "c4", "created_at", "updated_at", "c7", "video_ids"}
+	withFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "COUNT(*) FROM auto_playlists"):
+			return []string{"count"}, [][]driver.Value{{int64(len(auto))}}, nil
+		case strings.Contains(query, "COUNT(*) FROM user_playlists"):
+			return []string{"count"}, [][]driver.Value{{int64(len(curated))}}, nil
+		case !strings.Contains(query, "LIMIT"):
+			t.Errorf("v2 playlists queried without a LIMIT: %s", query)
+		case strings.Contains(query, "FROM auto_playlists"):
+			return columns, page(auto, args), nil
+		case strings.Contains(query, "FROM user_playlists"):
+			return columns, page(curated, args), nil
+		}
+		return columns, nil, nil
+	})
+
+	router := newRouter()
+	var pages [][]string
+	for cursor := ""; ; {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v2/playlists?limit=2&cursor="+cursor, nil))
+		var p listPage[Playlist]
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("GET /api/v2/playlists = %d: %s", rec.Code, rec.Body.String())
+		}
+		if p.Total != len(auto)+len(curated) {
+			t.Errorf("total = %d; expected every playlist of both sources", p.Total)
+		}
+		var ids []string
+		for _, playlist := range p.Items {
+			ids = append(ids, playlist.ID)
+		}
+		pages = append(pages, ids)
+		if cursor = p.NextCursor; cursor == "" || len(pages) > 5 {
+			break
+		}
+	}
+
+	expected := [][]string{{"pl_a", "pl_b"}, {"pl_c", "up_1"}, {"up_2"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("pages = %v; expected %v", pages, expected)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	maxTitleLength       = 500
+	maxDescriptionLength = 10000
+	maxTagLength         = 100
+	maxTagsPerVideo      = 50
+)
+
+// videoOverride holds manual edits; a nil field means "not overridden".
+// Tags are stored in video_tags rather than video_overrides.
+type videoOverride struct {
+	Title       *string
+	Description *string
+	Tags        *[]string
+	SortOrder   *int
+}
+
+// AuditEntry records one field change made through the API
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	VideoID   int             `json:"video_id"`
+	Field     string          `json:"field"`
+	OldValue  json.RawMessage `json:"old_value"`
+	NewValue  json.RawMessage `json:"new_value"`
+	ChangedBy string          `json:"changed_by"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// loadVideoOverride reads and locks the override row for a video
+func loadVideoOverride(tx *sql.Tx, videoID int) (videoOverride, error) {
+	var o videoOverride
+	var tags pq.StringArray
+	var hasTags bool
+	err := tx.QueryRow(`
+		SELECT COALESCE(array_agg(t.name ORDER BY t.name) FILTER (WHERE vt.source = $2), '{}'), COUNT(*) > 0
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_id = $1 AND vt.source IN ($2, $3)
+	`, videoID, tagSourceAdmin, tagSourceSuppressed).Scan(&tags, &hasTags)
+	if err != nil {
+		return o, err
+	}
+	if hasTags {
+		list := nonNil([]string(tags))
+		o.Tags = &list
+	}
+
+	var title, description sql.NullString
+	var sortOrder sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT title, description, sort_order
+		FROM video_overrides
+		WHERE video_id = $1
+		FOR UPDATE
+	`, videoID).Scan(&title, &description, &sortOrder)
+	if err == sql.ErrNoRows {
+		return o, nil
+	} else if err != nil {
+		return o, err
+	}
+
+	if title.Valid {
+		o.Title = &title.String
+	}
+	if description.Valid {
+		o.Description = &description.String
+	}
+	if sortOrder.Valid {
+		order := int(sortOrder.Int64)
+		o.SortOrder = &order
+	}
+	return o, nil
+}
+
+// empty reports whether the video_overrides row is no longer needed
+func (o videoOverride) empty() bool {
+	return o.Title == nil && o.Description == nil && o.SortOrder == nil
+}
+
+// setAdminTags stores a tags override: the listed tags become admin tags and
+// scanned tags missing from the list are suppressed. A nil list removes the
+// override so scanned tags show through again. User-added tags are untouched.
+func setAdminTags(tx *sql.Tx, videoID int, tags *[]string, actor string) error {
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = $1 AND source IN ($2, $3)",
+		videoID, tagSourceAdmin, tagSourceSuppressed); err != nil {
+		return err
+	}
+	if tags == nil {
+		return nil
+	}
+
+	if err := replaceVideoTags(tx, videoID, tagSourceAdmin, *tags, actor); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id, source, added_by)
+		SELECT DISTINCT video_id, tag_id, $2, $3
+		FROM video_tags
+		WHERE video_id = $1 AND source IN ($4, $5)
+			AND tag_id NOT IN (SELECT tag_id FROM video_tags WHERE video_id = $1 AND source = $6)
+		ON CONFLICT DO NOTHING
+	`, videoID, tagSourceSuppressed, actor, tagSourceAuto, tagSourceSidecar, tagSourceAdmin)
+	return err
+}
+
+// validateTags cleans a tag list and enforces its limits
+func validateTags(tags []string) ([]string, error) {
+	tags = nonNil(cleanList(tags))
+	if len(tags) > maxTagsPerVideo {
+		return nil, fmt.Errorf("Too many tags (max %d)", maxTagsPerVideo)
+	}
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tag too long (max %d characters)", maxTagLength)
+		}
+	}
+	return tags, nil
+}
+
+// patchField is a field of a PATCH body. It tells a field set to null apart
+// from one left out, and is decoded by value so errors can name the field.
+type patchField[T any] struct {
+	raw json.RawMessage
+}
+
+func (f *patchField[T]) UnmarshalJSON(data []byte) error {
+	f.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (f patchField[T]) MarshalJSON() ([]byte, error) {
+	if f.raw == nil {
+		return []byte("null"), nil
+	}
+	return f.raw, nil
+}
+
+// set reports whether the body had the field
+func (f patchField[T]) set() bool {
+	return f.raw != nil
+}
+
+// value decodes the field, returning nil when it is null
+func (f patchField[T]) value() (*T, error) {
+	if bytes.Equal(bytes.TrimSpace(f.raw), []byte("null")) {
+		return nil, nil
+	}
+	var v T
+	if err := json.Unmarshal(f.raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// errInvalidPatchBody is returned by decodeVideoPatch for bodies that aren't
+// a JSON object
+var errInvalidPatchBody = errors.New("invalid request body")
+
+// decodeVideoPatch reads a PATCH body, refusing fields that can't be edited
+func decodeVideoPatch(r io.Reader) (videoPatchInput, error) {
+	var body videoPatchInput
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return body, fmt.Errorf("Field %s cannot be edited", field)
+		}
+		return body, errInvalidPatchBody
+	}
+	return body, nil
+}
+
+// applyVideoPatch applies a PATCH body to an override. A field set to null
+// clears its override so the scanned value shows through again. The changed
+// fields are returned in the order they are applied and written to the
+// audit log: title, description, tags, sort_order.
+func applyVideoPatch(o *videoOverride, body videoPatchInput) ([]string, error) {
+	var changed []string
+	if body.Title.set() {
+		title, err := body.Title.value()
+		if err != nil {
+			return nil, fmt.Errorf("title must be a string")
+		}
+		if title != nil {
+			*title = strings.TrimSpace(*title)
+			if *title == "" {
+				return nil, fmt.Errorf("Title cannot be empty")
+			}
+			if len(*title) > maxTitleLength {
+				return nil, fmt.Errorf("Title too long (max %d characters)", maxTitleLength)
+			}
+		}
+		o.Title = title
+		changed = append(changed, "title")
+	}
+	if body.Description.set() {
+		description, err := body.Description.value()
+		if err != nil {
+			return nil, fmt.Errorf("description must be a string")
+		}
+		if description != nil {
+			*description = strings.TrimSpace(*description)
+			if len(*description) > maxDescriptionLength {
+				return nil, fmt.Errorf("Description too long (max %d characters)", maxDescriptionLength)
+			}
+		}
+		o.Description = description
+		changed = append(changed, "description")
+	}
+	if body.Tags.set() {
+		tags, err := body.Tags.value()
+		if err != nil {
+			return nil, fmt.Errorf("tags must be a list of strings")
+		}
+		if tags != nil {
+			cleaned, err := validateTags(*tags)
+			if err != nil {
+				return nil, err
+			}
+			tags = &cleaned
+		}
+		o.Tags = tags
+		changed = append(changed, "tags")
+	}
+	if body.SortOrder.set() {
+		order, err := body.SortOrder.value()
+		if err != nil {
+			return nil, fmt.Errorf("sort_order must be an integer")
+		}
+		o.SortOrder = order
+		changed = append(changed, "sort_order")
+	}
+	return changed, nil
+}
+
+// overrideValue returns the JSON form of one overridden field for the audit log
+func overrideValue(o videoOverride, field string) string {
+	var value interface{}
+	switch field {
+	case "title":
+		value = o.Title
+	case "description":
+		value = o.Description
+	case "tags":
+		value = o.Tags
+	case "sort_order":
+		value = o.SortOrder
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// auditActor names who made a change: the X-User identity when given, since
+// the admin token itself is shared
+func auditActor(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return user
+	}
+	return "admin"
+}
+
+func updateVideo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidID, "Invalid video ID")
+		return
+	}
+
+	body, err := decodeVideoPatch(r.Body)
+	if err == errInvalidPatchBody {
+		writeError(w, http.StatusBadRequest, errorCodeInvalidBody, "Invalid request body")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Printf("Error starting transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM videos WHERE id = $1)", videoID).Scan(&exists); err != nil {
+		logger.Printf("Error checking video existence: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, errorCodeNotFound, "Video not found")
+		return
+	}
+
+	before, err := loadVideoOverride(tx, videoID)
+	if err != nil {
+		logger.Printf("Error loading video overrides: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+		return
+	}
+
+	after := before
+	changed, err := applyVideoPatch(&after, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errorCodeValidation, err.Error())
+		return
+	}
+
+	actor := auditActor(r)
+	if after.empty() {
+		_, err = tx.Exec("DELETE FROM video_overrides WHERE video_id = $1", videoID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO video_overrides (video_id, title, description, sort_order, updated_by)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (video_id) DO UPDATE
+			SET title = EXCLUDED.title, description = EXCLUDED.description,
+				sort_order = EXCLUDED.sort_order, updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP
+		`, videoID, after.Title, after.Description, after.SortOrder, actor)
+	}
+	if err != nil {
+		logger.Printf("Error saving video overrides: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+		return
+	}
+
+	if body.Tags.set() {
+		if err := setAdminTags(tx, videoID, after.Tags, actor); err != nil {
+			logger.Printf("Error saving tag overrides: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+			return
+		}
+	}
+
+	for _, field := range changed {
+		oldValue, newValue := overrideValue(before, field), overrideValue(after, field)
+		if oldValue == newValue {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO video_audit_log (video_id, field, old_value, new_value, changed_by)
+			VALUES ($1, $2, $3::jsonb, $4::jsonb, $5)
+		`, videoID, field, oldValue, newValue, actor); err != nil {
+			logger.Printf("Error writing audit log: %v", err)
+			writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("Error committing video overrides: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to update video")
+		return
+	}
+
+	logger.Printf("Video ID %d edited by %s: %s", videoID, actor, strings.Join(changed, ", "))
+
+	v, err := scanVideo(db.QueryRow(`
+		SELECT `+videoColumns+`
+		FROM `+videoTables+`
+		WHERE v.id = $1
+	`, videoID))
+	if err != nil {
+		logger.Printf("Error reloading video: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch video")
+		return
+	}
+	publishVideoData(eventVideoUpdated, v)
+
+	v.setLinks(apiBase(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func getVideoHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	page, ok := listingPage(w, r)
+	if !ok {
+		return
+	}
+	limit, args := page.limitClause([]interface{}{id})
+	rows, err := db.Query(`
+		SELECT id, video_id, field, old_value, new_value, changed_by, changed_at
+		FROM video_audit_log
+		WHERE video_id = $1
+		ORDER BY changed_at DESC, id DESC
+		`+limit, args...)
+	if err != nil {
+		logger.Printf("Error querying audit log: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch history")
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var oldValue, newValue []byte
+		if err := rows.Scan(&e.ID, &e.VideoID, &e.Field, &oldValue, &newValue, &e.ChangedBy, &e.ChangedAt); err != nil {
+			logger.Printf("Error scanning audit entry: %v", err)
+			continue
+		}
+		e.OldValue = nullableJSON(oldValue)
+		e.NewValue = nullableJSON(newValue)
+		entries = append(entries, e)
+	}
+
+	total, err := page.countRows(len(entries), `SELECT COUNT(*) FROM video_audit_log WHERE video_id = $1`, id)
+	if err != nil {
+		logger.Printf("Error counting audit log: %v", err)
+		writeError(w, http.StatusInternalServerError, errorCodeInternal, "Failed to fetch history")
+		return
+	}
+	writePage(w, page, entries, total)
+}
+
+// nullableJSON maps a NULL JSONB column to JSON null
+func nullableJSON(value []byte) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(value)
+}
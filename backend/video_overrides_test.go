@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func patchBody(t *testing.T, body string) videoPatchInput {
+	t.Helper()
+	input, err := decodeVideoPatch(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Invalid test body: %v", err)
+	}
+	return input
+}
+
+func TestApplyVideoPatch(t *testing.T) {
+	var o videoOverride
+	changed, err := applyVideoPatch(&o, patchBody(t, `{"title": "  Nice title ", "tags": ["a", " b ", "A"], "sort_order": 3}`))
+	if err != nil {
+		t.Fatalf("applyVideoPatch failed: %v", err)
+	}
+	if strings.Join(changed, ",") != "title,tags,sort_order" {
+		t.Errorf("Expected the 3 changed fields in a fixed order, got %v", changed)
+	}
+	if o.Title == nil || *o.Title != "Nice title" {
+		t.Errorf("Expected trimmed title, got %v", o.Title)
+	}
+	if o.Tags == nil || len(*o.Tags) != 2 {
+		t.Errorf("Expected deduplicated tags, got %v", o.Tags)
+	}
+	if o.SortOrder == nil || *o.SortOrder != 3 {
+		t.Errorf("Expected sort order 3, got %v", o.SortOrder)
+	}
+	if o.Description != nil {
+		t.Error("Description should not be overridden")
+	}
+
+	// null clears an override
+	if _, err := applyVideoPatch(&o, patchBody(t, `{"title": null, "tags": null, "sort_order": null}`)); err != nil {
+		t.Fatalf("applyVideoPatch failed: %v", err)
+	}
+	if !o.empty() || o.Tags != nil {
+		t.Errorf("Expected all overrides cleared, got %+v", o)
+	}
+}
+
+func TestApplyVideoPatchValidation(t *testing.T) {
+	invalid := []string{
+		`{"title": ""}`,
+		`{"title": 5}`,
+		`{"title": "` + strings.Repeat("x", maxTitleLength+1) + `"}`,
+		`{"tags": "not a list"}`,
+		`{"sort_order": "first"}`,
+	}
+	for _, body := range invalid {
+		var o videoOverride
+		if _, err := applyVideoPatch(&o, patchBody(t, body)); err == nil {
+			t.Errorf("Expected %s to be rejected", body)
+		}
+	}
+
+	if _, err := decodeVideoPatch(strings.NewReader(`{"views": 1000}`)); err == nil || err == errInvalidPatchBody {
+		t.Errorf("Expected an uneditable field to be refused by name, got %v", err)
+	}
+	if _, err := decodeVideoPatch(strings.NewReader(`{"title"`)); err != errInvalidPatchBody {
+		t.Errorf("Expected a malformed body to be invalid, got %v", err)
+	}
+}
+
+func TestOverrideValue(t *testing.T) {
+	title := "Edited"
+	o := videoOverride{Title: &title}
+	if v := overrideValue(o, "title"); v != `"Edited"` {
+		t.Errorf("Unexpected title value %s", v)
+	}
+	if v := overrideValue(o, "tags"); v != "null" {
+		t.Errorf("Expected null for unset override, got %s", v)
+	}
+}
@@ -19,11 +19,44 @@ const getApiBaseUrl = () => {
 
 const API_BASE_URL = getApiBaseUrl();
 
+// StreamLite has no accounts; requests that need an identity send a name
+// kept in local storage, generated on first use
+const USER_KEY = 'streamlite-user';
+
+export const getIdentity = () => {
+  let user = localStorage.getItem(USER_KEY);
+  if (!user) {
+    user = `guest-${Math.random().toString(36).slice(2, 10)}`;
+    localStorage.setItem(USER_KEY, user);
+  }
+  return user;
+};
+
+const identityHeaders = () => ({ 'X-User': getIdentity() });
+
+// Failed requests answer with {"error": {"code", "message", "details",
+// "request_id"}}; apiError returns that object for an axios error, or null
+// when the server couldn't be reached
+export const apiError = (error) => error.response?.data?.error || null;
+
 export const getVideos = async () => {
   const response = await axios.get(`${API_BASE_URL}/videos`);
   return response.data;
 };
 
+// Fetch videos carrying every given tag slug, with tag counts for the result
+export const getFacetedVideos = async (tags = []) => {
+  const params = new URLSearchParams({ facets: 'true' });
+  tags.forEach((tag) => params.append('tag', tag));
+  const response = await axios.get(`${API_BASE_URL}/videos?${params.toString()}`);
+  return response.data;
+};
+
+export const getTags = async () => {
+  const response = await axios.get(`${API_BASE_URL}/tags`);
+  return response.data;
+};
+
 export const refreshVideos = async () => {
   const response = await axios.post(`${API_BASE_URL}/videos/refresh`);
   return response.data;
@@ -38,6 +71,60 @@ export const getVideoStreamUrl = (id) => {
   return `${API_BASE_URL}/videos/${id}/stream`;
 };
 
+export const getSubtitles = async (id) => {
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/subtitles`);
+  return response.data;
+};
+
+export const getSubtitleUrl = (id, trackId) => {
+  return `${API_BASE_URL}/videos/${id}/subtitles/${trackId}.vtt`;
+};
+
+// Embedded subtitle tracks are extracted by a background job, and their URL
+// answers 202 Accepted until the WebVTT is ready. waitForSubtitle checks
+// every few seconds while keepWaiting() is true, resolving to whether the
+// track can be loaded.
+const SUBTITLE_POLL_MS = 5000;
+const SUBTITLE_MAX_POLLS = 120;
+
+export const waitForSubtitle = async (id, trackId, keepWaiting) => {
+  for (let i = 0; i < SUBTITLE_MAX_POLLS && keepWaiting(); i++) {
+    const response = await axios.get(getSubtitleUrl(id, trackId), { responseType: 'text' });
+    if (response.status !== 202) {
+      return true;
+    }
+    await new Promise((resolve) => setTimeout(resolve, SUBTITLE_POLL_MS));
+  }
+  return false;
+};
+
+export const getChapters = async (id) => {
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/chapters`);
+  return response.data;
+};
+
+export const getChaptersUrl = (id) => {
+  return `${API_BASE_URL}/videos/${id}/chapters.vtt`;
+};
+
+// Subscribe to server-sent events. handlers maps event types to callbacks
+// receiving the decoded event data; pass video IDs to only hear about those
+// videos. Call close() on the returned EventSource to unsubscribe. Pass user
+// to also receive that user's notifications.
+export const subscribeToEvents = (handlers, videoIds = [], user) => {
+  const params = new URLSearchParams();
+  Object.keys(handlers).forEach((type) => params.append('type', type));
+  videoIds.forEach((id) => params.append('video_id', id));
+  if (user) {
+    params.set('user', user);
+  }
+  const source = new EventSource(`${API_BASE_URL}/events?${params.toString()}`);
+  Object.entries(handlers).forEach(([type, handler]) => {
+    source.addEventListener(type, (event) => handler(JSON.parse(event.data)));
+  });
+  return source;
+};
+
 export const incrementView = async (id) => {
   await axios.post(`${API_BASE_URL}/videos/${id}/view`);
 };
@@ -46,15 +133,109 @@ export const toggleLike = async (id, action) => {
   await axios.post(`${API_BASE_URL}/videos/${id}/like`, { action });
 };
 
-export const getComments = async (id) => {
-  const response = await axios.get(`${API_BASE_URL}/videos/${id}/comments`);
+// The reactions that can be added to videos and comments
+export const getReactionSet = async () => {
+  const response = await axios.get(`${API_BASE_URL}/reactions`);
+  return response.data;
+};
+
+// Reaction counts on a video and the ones added by this browser, as
+// { reactions, mine }
+export const getVideoReactions = async (id) => {
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/reactions`, { headers: identityHeaders() });
+  return response.data;
+};
+
+export const toggleVideoReaction = async (id, reaction) => {
+  const response = await axios.post(`${API_BASE_URL}/videos/${id}/reactions`, { reaction }, {
+    headers: identityHeaders(),
+  });
+  return response.data;
+};
+
+export const toggleCommentReaction = async (id, commentId, reaction) => {
+  const response = await axios.post(`${API_BASE_URL}/videos/${id}/comments/${commentId}/reactions`, { reaction }, {
+    headers: identityHeaders(),
+  });
+  return response.data;
+};
+
+// Without a depth the comments come back as a flat list; with one, replies
+// are nested that many levels under each top-level comment
+export const getComments = async (id, depth) => {
+  const params = depth === undefined ? '' : `?depth=${depth}`;
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/comments${params}`);
   return response.data;
 };
 
-export const addComment = async (id, author, content) => {
+// One page of top-level comments, with replies nested depth levels, as
+// { comments, total, next_cursor }. Pass next_cursor back to get the next page.
+export const getCommentPage = async (id, { depth, sort = 'newest', cursor, limit = 20 } = {}) => {
+  const params = new URLSearchParams({ sort, limit });
+  if (depth !== undefined) {
+    params.set('depth', depth);
+  }
+  if (cursor) {
+    params.set('cursor', cursor);
+  }
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/comments?${params}`);
+  return response.data;
+};
+
+// timestampSeconds optionally ties the comment to a playback position
+export const addComment = async (id, author, content, timestampSeconds) => {
   const response = await axios.post(`${API_BASE_URL}/videos/${id}/comments`, {
     author,
     content,
+    timestamp_seconds: timestampSeconds,
+  }, { headers: identityHeaders() });
+  return response.data;
+};
+
+// Comments tied to a playback position, in playback order
+export const getTimestampedComments = async (id) => {
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/comments?sort=timestamp`);
+  return response.data;
+};
+
+export const addReply = async (id, commentId, author, content) => {
+  const response = await axios.post(`${API_BASE_URL}/videos/${id}/comments/${commentId}/replies`, {
+    author,
+    content,
+  }, { headers: identityHeaders() });
+  return response.data;
+};
+
+export const getReplies = async (id, commentId, depth = 0) => {
+  const response = await axios.get(`${API_BASE_URL}/videos/${id}/comments/${commentId}/replies?depth=${depth}`);
+  return response.data;
+};
+
+// Editing and deleting need the X-User identity that posted the comment
+export const editComment = async (id, commentId, content, user) => {
+  const response = await axios.patch(`${API_BASE_URL}/videos/${id}/comments/${commentId}`, { content }, {
+    headers: { 'X-User': user },
+  });
+  return response.data;
+};
+
+export const deleteComment = async (id, commentId, user) => {
+  const response = await axios.delete(`${API_BASE_URL}/videos/${id}/comments/${commentId}`, {
+    headers: { 'X-User': user },
+  });
+  return response.data;
+};
+
+// This browser's notifications, newest first, as { notifications, unread }
+export const getNotifications = async () => {
+  const response = await axios.get(`${API_BASE_URL}/notifications`, { headers: identityHeaders() });
+  return response.data;
+};
+
+// Mark the given notifications read, or all of them without ids
+export const markNotificationsRead = async (ids) => {
+  const response = await axios.post(`${API_BASE_URL}/notifications/read`, ids ? { ids } : {}, {
+    headers: identityHeaders(),
   });
   return response.data;
 };
@@ -6,23 +6,116 @@ import {
   Button,
   Box,
   Avatar,
+  Checkbox,
+  FormControl,
+  FormControlLabel,
+  InputLabel,
   List,
   ListItem,
   ListItemAvatar,
   ListItemText,
+  MenuItem,
+  Select,
 } from '@mui/material';
+import ReactionBar from './ReactionBar';
+import { apiError } from '../api';
 
-const CommentSection = ({ comments, onAddComment }) => {
+// Insert a new comment or reply into a thread tree, skipping comments already
+// shown and replies whose parent isn't loaded
+export const addToThread = (comments, comment) => {
+  if (comments.some((c) => c.id === comment.id)) {
+    return comments;
+  }
+  if (comment.parent_id === null || comment.parent_id === undefined) {
+    return [comment, ...comments];
+  }
+  return comments.map((c) => {
+    if (c.id === comment.parent_id) {
+      const replies = c.replies || [];
+      if (replies.some((r) => r.id === comment.id)) {
+        return c;
+      }
+      return { ...c, reply_count: c.reply_count + 1, replies: [...replies, comment] };
+    }
+    return c.replies ? { ...c, replies: addToThread(c.replies, comment) } : c;
+  });
+};
+
+// Replace an edited comment anywhere in a thread tree, keeping its loaded replies
+export const updateInThread = (comments, comment) =>
+  comments.map((c) => {
+    if (c.id === comment.id) {
+      return { ...c, ...comment, replies: c.replies };
+    }
+    return c.replies ? { ...c, replies: updateInThread(c.replies, comment) } : c;
+  });
+
+// Remove a deleted or hidden comment, and its replies, from a thread tree
+export const removeFromThread = (comments, commentId) =>
+  comments
+    .filter((c) => c.id !== commentId)
+    .map((c) => {
+      if (!c.replies) {
+        return c;
+      }
+      const replies = removeFromThread(c.replies, commentId);
+      if (replies.length === c.replies.length) {
+        return { ...c, replies };
+      }
+      return { ...c, reply_count: Math.max(c.reply_count - 1, 0), replies };
+    });
+
+// Replace the loaded replies of a comment anywhere in a thread tree
+export const withReplies = (comments, commentId, replies) =>
+  comments.map((c) => {
+    if (c.id === commentId) {
+      return { ...c, replies };
+    }
+    return c.replies ? { ...c, replies: withReplies(c.replies, commentId, replies) } : c;
+  });
+
+const formatDate = (dateString) => {
+  const date = new Date(dateString);
+  const now = new Date();
+  const diffTime = Math.abs(now - date);
+  const diffMinutes = Math.floor(diffTime / (1000 * 60));
+  const diffHours = Math.floor(diffTime / (1000 * 60 * 60));
+  const diffDays = Math.floor(diffTime / (1000 * 60 * 60 * 24));
+
+  if (diffMinutes < 1) {
+    return 'Just now';
+  } else if (diffMinutes < 60) {
+    return `${diffMinutes} minute${diffMinutes > 1 ? 's' : ''} ago`;
+  } else if (diffHours < 24) {
+    return `${diffHours} hour${diffHours > 1 ? 's' : ''} ago`;
+  } else if (diffDays < 7) {
+    return `${diffDays} day${diffDays > 1 ? 's' : ''} ago`;
+  } else {
+    return date.toLocaleDateString();
+  }
+};
+
+// Format a playback position as m:ss or h:mm:ss
+export const formatTimestamp = (seconds) => {
+  const hours = Math.floor(seconds / 3600);
+  const minutes = Math.floor((seconds % 3600) / 60);
+  const secs = String(seconds % 60).padStart(2, '0');
+  return hours > 0 ? `${hours}:${String(minutes).padStart(2, '0')}:${secs}` : `${minutes}:${secs}`;
+};
+
+// getCurrentTime, when given, offers to tie the comment to the playback position
+const CommentForm = ({ onSubmit, label, submitLabel, rows = 3, onCancel, getCurrentTime }) => {
   const [author, setAuthor] = useState('');
   const [content, setContent] = useState('');
+  const [atCurrentTime, setAtCurrentTime] = useState(false);
   const [submitting, setSubmitting] = useState(false);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
-    
+
     const trimmedContent = content.trim();
     const trimmedAuthor = author.trim();
-    
+
     if (!trimmedContent) {
       return;
     }
@@ -39,93 +132,226 @@ const CommentSection = ({ comments, onAddComment }) => {
       return;
     }
 
+    const timestamp = atCurrentTime && getCurrentTime ? Math.floor(getCurrentTime()) : undefined;
+
     setSubmitting(true);
     try {
-      await onAddComment(trimmedAuthor || 'Anonymous', trimmedContent);
+      await onSubmit(trimmedAuthor || 'Anonymous', trimmedContent, timestamp);
       setContent('');
       setAuthor('');
+      setAtCurrentTime(false);
     } catch (error) {
       console.error('Failed to submit comment:', error);
-      alert('Failed to post comment. Please try again.');
+      // Refused comments (banned words, links, bad timestamps) and rate
+      // limits explain why
+      const apiErr = apiError(error);
+      if (apiErr && (error.response.status === 400 || apiErr.code === 'rate_limited')) {
+        alert(apiErr.message);
+      } else {
+        alert('Failed to post comment. Please try again.');
+      }
     } finally {
       setSubmitting(false);
     }
   };
 
-  const formatDate = (dateString) => {
-    const date = new Date(dateString);
-    const now = new Date();
-    const diffTime = Math.abs(now - date);
-    const diffMinutes = Math.floor(diffTime / (1000 * 60));
-    const diffHours = Math.floor(diffTime / (1000 * 60 * 60));
-    const diffDays = Math.floor(diffTime / (1000 * 60 * 60 * 24));
-
-    if (diffMinutes < 1) {
-      return 'Just now';
-    } else if (diffMinutes < 60) {
-      return `${diffMinutes} minute${diffMinutes > 1 ? 's' : ''} ago`;
-    } else if (diffHours < 24) {
-      return `${diffHours} hour${diffHours > 1 ? 's' : ''} ago`;
-    } else if (diffDays < 7) {
-      return `${diffDays} day${diffDays > 1 ? 's' : ''} ago`;
-    } else {
-      return date.toLocaleDateString();
+  return (
+    <Box component="form" onSubmit={handleSubmit} sx={{ mb: 3 }}>
+      <TextField
+        fullWidth
+        label="Your Name (optional)"
+        value={author}
+        onChange={(e) => setAuthor(e.target.value)}
+        margin="normal"
+        size="small"
+      />
+      <TextField
+        fullWidth
+        label={label}
+        value={content}
+        onChange={(e) => setContent(e.target.value)}
+        margin="normal"
+        multiline
+        rows={rows}
+        required
+      />
+      {getCurrentTime && (
+        <FormControlLabel
+          control={<Checkbox checked={atCurrentTime} onChange={(e) => setAtCurrentTime(e.target.checked)} />}
+          label="Comment on the current moment"
+        />
+      )}
+      <Button
+        type="submit"
+        variant="contained"
+        sx={{ mt: 1 }}
+        disabled={submitting || !content.trim()}
+      >
+        {submitting ? 'Posting...' : submitLabel}
+      </Button>
+      {onCancel && (
+        <Button sx={{ mt: 1, ml: 1 }} onClick={onCancel}>
+          Cancel
+        </Button>
+      )}
+    </Box>
+  );
+};
+
+const CommentItem = ({ comment, onAddReply, onLoadReplies, onSeek, reactionSet, onReact }) => {
+  const [replying, setReplying] = useState(false);
+  const [myReactions, setMyReactions] = useState([]);
+  const replies = comment.replies || [];
+
+  const handleReact = async (reaction) => {
+    const summary = await onReact(comment.id, reaction);
+    if (summary) {
+      setMyReactions(summary.mine);
     }
   };
 
+  const handleReply = async (author, content) => {
+    await onAddReply(comment.id, author, content);
+    setReplying(false);
+  };
+
   return (
-    <Paper elevation={3} sx={{ p: 3 }}>
-      <Typography variant="h6" gutterBottom>
-        Comments ({comments.length})
-      </Typography>
-      <Box component="form" onSubmit={handleSubmit} sx={{ mb: 3 }}>
-        <TextField
-          fullWidth
-          label="Your Name (optional)"
-          value={author}
-          onChange={(e) => setAuthor(e.target.value)}
-          margin="normal"
-          size="small"
-        />
-        <TextField
-          fullWidth
-          label="Add a comment..."
-          value={content}
-          onChange={(e) => setContent(e.target.value)}
-          margin="normal"
-          multiline
-          rows={3}
-          required
+    <>
+      <ListItem alignItems="flex-start" sx={{ px: 0 }}>
+        <ListItemAvatar>
+          <Avatar>{comment.author.charAt(0).toUpperCase()}</Avatar>
+        </ListItemAvatar>
+        <ListItemText
+          primary={
+            <Box sx={{ display: 'flex', alignItems: 'center', gap: 1 }}>
+              <Typography variant="subtitle2">{comment.author}</Typography>
+              <Typography variant="caption" color="text.secondary">
+                {formatDate(comment.created_at)}
+                {comment.edited_at && ' (edited)'}
+              </Typography>
+              {comment.timestamp_seconds !== null && comment.timestamp_seconds !== undefined && (
+                <Button size="small" sx={{ minWidth: 0, py: 0 }} onClick={() => onSeek && onSeek(comment.timestamp_seconds)}>
+                  {formatTimestamp(comment.timestamp_seconds)}
+                </Button>
+              )}
+            </Box>
+          }
+          secondaryTypographyProps={{ component: 'div' }}
+          secondary={
+            <>
+              <Typography variant="body2" component="span" display="block">
+                {comment.content}
+              </Typography>
+              {onReact && (
+                <Box sx={{ mt: 0.5 }}>
+                  <ReactionBar
+                    available={reactionSet}
+                    reactions={comment.reactions}
+                    mine={myReactions}
+                    onToggle={handleReact}
+                  />
+                </Box>
+              )}
+              <Button size="small" sx={{ px: 0, minWidth: 0 }} onClick={() => setReplying(!replying)}>
+                Reply
+              </Button>
+              {comment.reply_count > replies.length && (
+                <Button size="small" sx={{ ml: 1 }} onClick={() => onLoadReplies(comment.id)}>
+                  Show {comment.reply_count} {comment.reply_count === 1 ? 'reply' : 'replies'}
+                </Button>
+              )}
+            </>
+          }
         />
-        <Button
-          type="submit"
-          variant="contained"
-          sx={{ mt: 1 }}
-          disabled={submitting || !content.trim()}
-        >
-          {submitting ? 'Posting...' : 'Post Comment'}
-        </Button>
+      </ListItem>
+      {(replying || replies.length > 0) && (
+        <Box sx={{ pl: 4, borderLeft: 1, borderColor: 'divider' }}>
+          {replying && (
+            <CommentForm
+              onSubmit={handleReply}
+              label="Add a reply..."
+              submitLabel="Post Reply"
+              rows={2}
+              onCancel={() => setReplying(false)}
+            />
+          )}
+          {replies.length > 0 && (
+            <List disablePadding>
+              {replies.map((reply) => (
+                <CommentItem
+                  key={reply.id}
+                  comment={reply}
+                  onAddReply={onAddReply}
+                  onLoadReplies={onLoadReplies}
+                  onSeek={onSeek}
+                  reactionSet={reactionSet}
+                  onReact={onReact}
+                />
+              ))}
+            </List>
+          )}
+        </Box>
+      )}
+    </>
+  );
+};
+
+const CommentSection = ({
+  comments,
+  total,
+  sort,
+  onSortChange,
+  hasMore,
+  onLoadMore,
+  onAddComment,
+  onAddReply,
+  onLoadReplies,
+  getCurrentTime,
+  onSeek,
+  reactionSet,
+  onReact,
+}) => {
+  return (
+    <Paper elevation={3} sx={{ p: 3 }}>
+      <Box sx={{ display: 'flex', alignItems: 'center', justifyContent: 'space-between' }}>
+        <Typography variant="h6" gutterBottom>
+          Comments ({total ?? comments.length})
+        </Typography>
+        {onSortChange && (
+          <FormControl size="small" sx={{ minWidth: 120 }}>
+            <InputLabel>Sort by</InputLabel>
+            <Select value={sort} label="Sort by" onChange={(e) => onSortChange(e.target.value)}>
+              <MenuItem value="newest">Newest</MenuItem>
+              <MenuItem value="oldest">Oldest</MenuItem>
+              <MenuItem value="top">Top</MenuItem>
+            </Select>
+          </FormControl>
+        )}
       </Box>
+      <CommentForm
+        onSubmit={onAddComment}
+        label="Add a comment..."
+        submitLabel="Post Comment"
+        getCurrentTime={getCurrentTime}
+      />
       <List>
         {comments.map((comment) => (
-          <ListItem key={comment.id} alignItems="flex-start" sx={{ px: 0 }}>
-            <ListItemAvatar>
-              <Avatar>{comment.author.charAt(0).toUpperCase()}</Avatar>
-            </ListItemAvatar>
-            <ListItemText
-              primary={
-                <Box sx={{ display: 'flex', alignItems: 'center', gap: 1 }}>
-                  <Typography variant="subtitle2">{comment.author}</Typography>
-                  <Typography variant="caption" color="text.secondary">
-                    {formatDate(comment.created_at)}
-                  </Typography>
-                </Box>
-              }
-              secondary={<Typography variant="body2">{comment.content}</Typography>}
-            />
-          </ListItem>
+          <CommentItem
+            key={comment.id}
+            comment={comment}
+            onAddReply={onAddReply}
+            onLoadReplies={onLoadReplies}
+            onSeek={onSeek}
+            reactionSet={reactionSet}
+            onReact={onReact}
+          />
         ))}
       </List>
+      {hasMore && (
+        <Button fullWidth onClick={onLoadMore}>
+          Load more comments
+        </Button>
+      )}
     </Paper>
   );
 };
@@ -0,0 +1,83 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { Badge, IconButton, Menu, MenuItem, ListItemText, Tooltip, Typography } from '@mui/material';
+import NotificationsIcon from '@mui/icons-material/Notifications';
+import { getIdentity, getNotifications, markNotificationsRead, subscribeToEvents } from '../api';
+
+// Replies to this browser's comments and @mentions of its identity, updated
+// live while the page is open
+const NotificationBell = () => {
+  const navigate = useNavigate();
+  const [notifications, setNotifications] = useState([]);
+  const [unread, setUnread] = useState(0);
+  const [anchorEl, setAnchorEl] = useState(null);
+
+  useEffect(() => {
+    const fetchNotifications = async () => {
+      try {
+        const data = await getNotifications();
+        setNotifications(data.notifications);
+        setUnread(data.unread);
+      } catch (error) {
+        console.error('Failed to fetch notifications:', error);
+      }
+    };
+
+    fetchNotifications();
+    const source = subscribeToEvents({
+      notification: (notification) => {
+        setNotifications((current) => [notification, ...current.filter((n) => n.id !== notification.id)]);
+        setUnread((count) => count + 1);
+      },
+    }, [], getIdentity());
+    return () => source.close();
+  }, []);
+
+  const handleOpen = async (event) => {
+    setAnchorEl(event.currentTarget);
+    if (unread > 0) {
+      try {
+        const data = await markNotificationsRead();
+        setUnread(data.unread);
+      } catch (error) {
+        console.error('Failed to mark notifications read:', error);
+      }
+    }
+  };
+
+  const handleClick = (notification) => {
+    setAnchorEl(null);
+    navigate(`/video/${notification.video_id}`);
+  };
+
+  return (
+    <>
+      <Tooltip title="Notifications">
+        <IconButton color="inherit" onClick={handleOpen} aria-label="notifications">
+          <Badge badgeContent={unread} color="error">
+            <NotificationsIcon />
+          </Badge>
+        </IconButton>
+      </Tooltip>
+      <Menu anchorEl={anchorEl} open={Boolean(anchorEl)} onClose={() => setAnchorEl(null)}>
+        {notifications.length === 0 && (
+          <MenuItem disabled>
+            <Typography variant="body2">No notifications yet</Typography>
+          </MenuItem>
+        )}
+        {notifications.map((notification) => (
+          <MenuItem key={notification.id} onClick={() => handleClick(notification)} sx={{ maxWidth: 360 }}>
+            <ListItemText
+              primary={`${notification.actor} ${notification.type === 'reply' ? 'replied to you' : 'mentioned you'}`}
+              secondary={notification.content}
+              primaryTypographyProps={{ fontWeight: notification.read_at ? 'normal' : 'bold' }}
+              secondaryTypographyProps={{ noWrap: true }}
+            />
+          </MenuItem>
+        ))}
+      </Menu>
+    </>
+  );
+};
+
+export default NotificationBell;
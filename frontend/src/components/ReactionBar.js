@@ -0,0 +1,32 @@
+import React from 'react';
+import { Box, Chip } from '@mui/material';
+
+// Chips for each available reaction with its count; the ones in mine are
+// highlighted and clicking a chip toggles it
+const ReactionBar = ({ available, reactions = {}, mine = [], onToggle, dark = false }) => {
+  if (!available || available.length === 0) {
+    return null;
+  }
+
+  return (
+    <Box sx={{ display: 'flex', flexWrap: 'wrap', gap: 0.5 }}>
+      {available.map((reaction) => {
+        const selected = mine.includes(reaction);
+        const count = reactions[reaction] || 0;
+        return (
+          <Chip
+            key={reaction}
+            size="small"
+            label={count > 0 ? `${reaction} ${count}` : reaction}
+            variant={selected ? 'filled' : 'outlined'}
+            color={selected ? 'primary' : 'default'}
+            onClick={() => onToggle(reaction)}
+            sx={dark && !selected ? { color: 'white', borderColor: 'grey.600' } : undefined}
+          />
+        );
+      })}
+    </Box>
+  );
+};
+
+export default ReactionBar;
@@ -19,11 +19,15 @@ import {
 } from '@mui/material';
 import RefreshIcon from '@mui/icons-material/Refresh';
 import PlaylistPlayIcon from '@mui/icons-material/PlaylistPlay';
-import { getVideos, refreshVideos, getPlaylists } from '../api';
+import { getFacetedVideos, refreshVideos, getPlaylists, subscribeToEvents, apiError } from '../api';
+import NotificationBell from '../components/NotificationBell';
 
 const HomePage = () => {
   const [videos, setVideos] = useState([]);
   const [playlists, setPlaylists] = useState([]);
+  const [facets, setFacets] = useState([]);
+  const [selectedTags, setSelectedTags] = useState([]);
+  const [previewId, setPreviewId] = useState(null);
   const [loading, setLoading] = useState(true);
   const [refreshing, setRefreshing] = useState(false);
   const [snackbar, setSnackbar] = useState({ open: false, message: '', severity: 'success' });
@@ -33,10 +37,11 @@ const HomePage = () => {
     const fetchData = async () => {
       try {
         const [videosData, playlistsData] = await Promise.all([
-          getVideos(),
+          getFacetedVideos(selectedTags),
           getPlaylists(),
         ]);
-        setVideos(videosData);
+        setVideos(videosData.videos);
+        setFacets(videosData.facets);
         setPlaylists(playlistsData);
       } catch (error) {
         console.error('Failed to fetch data:', error);
@@ -47,27 +52,91 @@ const HomePage = () => {
     };
 
     fetchData();
+    // Only load playlists on mount; tag changes are handled below
+    // eslint-disable-next-line react-hooks/exhaustive-deps
   }, []);
 
+  useEffect(() => {
+    if (loading) {
+      return;
+    }
+    const fetchFiltered = async () => {
+      try {
+        const videosData = await getFacetedVideos(selectedTags);
+        setVideos(videosData.videos);
+        setFacets(videosData.facets);
+      } catch (error) {
+        console.error('Failed to filter videos:', error);
+        setSnackbar({ open: true, message: 'Failed to filter videos', severity: 'error' });
+      }
+    };
+
+    fetchFiltered();
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [selectedTags]);
+
+  // Keep the grid live: reload (at most once a second) when the library changes
+  useEffect(() => {
+    let timer = null;
+    const reload = (withPlaylists) => () => {
+      clearTimeout(timer);
+      timer = setTimeout(async () => {
+        try {
+          const videosData = await getFacetedVideos(selectedTags);
+          setVideos(videosData.videos);
+          setFacets(videosData.facets);
+          if (withPlaylists) {
+            setPlaylists(await getPlaylists());
+          }
+        } catch (error) {
+          console.error('Failed to reload videos:', error);
+        }
+      }, 1000);
+    };
+
+    const source = subscribeToEvents({
+      'video.added': reload(false),
+      'video.updated': reload(false),
+      'video.removed': reload(false),
+      'scan.completed': reload(true),
+      reset: reload(true),
+    });
+    return () => {
+      clearTimeout(timer);
+      source.close();
+    };
+  }, [selectedTags]);
+
   const handleRefresh = async () => {
     setRefreshing(true);
     try {
       await refreshVideos();
       const [videosData, playlistsData] = await Promise.all([
-        getVideos(),
+        getFacetedVideos(selectedTags),
         getPlaylists(),
       ]);
-      setVideos(videosData);
+      setVideos(videosData.videos);
+      setFacets(videosData.facets);
       setPlaylists(playlistsData);
       setSnackbar({ open: true, message: 'Videos refreshed successfully', severity: 'success' });
     } catch (error) {
       console.error('Failed to refresh videos:', error);
-      setSnackbar({ open: true, message: 'Failed to refresh videos', severity: 'error' });
+      const retryAfter = apiError(error)?.code === 'rate_limited' && apiError(error).details.retry_after;
+      const message = retryAfter
+        ? `Videos were refreshed recently, try again in ${retryAfter} seconds`
+        : 'Failed to refresh videos';
+      setSnackbar({ open: true, message, severity: retryAfter ? 'warning' : 'error' });
     } finally {
       setRefreshing(false);
     }
   };
 
+  const handleTagToggle = (slug) => {
+    setSelectedTags((current) =>
+      current.includes(slug) ? current.filter((tag) => tag !== slug) : [...current, slug]
+    );
+  };
+
   const handleSnackbarClose = () => {
     setSnackbar({ ...snackbar, open: false });
   };
@@ -127,6 +196,7 @@ const HomePage = () => {
               {refreshing ? <CircularProgress size={24} color="inherit" /> : <RefreshIcon />}
             </IconButton>
           </Tooltip>
+          <NotificationBell />
         </Toolbar>
       </AppBar>
       <Container maxWidth="xl" sx={{ mt: 4, mb: 4 }}>
@@ -208,16 +278,36 @@ const HomePage = () => {
             <Typography variant="h5" gutterBottom sx={{ mb: 2, fontWeight: 'bold' }}>
               All Videos
             </Typography>
+            {(facets.length > 0 || selectedTags.length > 0) && (
+              <Box sx={{ display: 'flex', flexWrap: 'wrap', gap: 1, mb: 3 }}>
+                {selectedTags.length > 0 && (
+                  <Chip label="All" variant="outlined" onClick={() => setSelectedTags([])} />
+                )}
+                {facets.map((facet) => (
+                  <Chip
+                    key={facet.slug}
+                    label={`${facet.name} (${facet.count})`}
+                    color={selectedTags.includes(facet.slug) ? 'primary' : 'default'}
+                    variant={selectedTags.includes(facet.slug) ? 'filled' : 'outlined'}
+                    onClick={() => handleTagToggle(facet.slug)}
+                  />
+                ))}
+              </Box>
+            )}
             <Grid container spacing={3}>
               {videos.map((video) => (
                 <Grid item xs={12} sm={6} md={4} key={video.id}>
                   <Card
                     sx={{ cursor: 'pointer', height: '100%', display: 'flex', flexDirection: 'column' }}
                     onClick={() => handleVideoClick(video.id)}
+                    onMouseEnter={() => setPreviewId(video.id)}
+                    onMouseLeave={() => setPreviewId(null)}
                   >
+                    {/* The preview 404s or returns 202 until it has been generated; fall back to the thumbnail */}
                     <CardMedia
                       component="img"
-                      image={video.thumbnail_url}
+                      image={previewId === video.id ? video.preview_url : video.thumbnail_url}
+                      onError={() => setPreviewId(null)}
                       alt={video.title}
                       sx={{
                         aspectRatio: '16/9',
@@ -19,6 +19,8 @@ import {
   ListItem,
   ListItemButton,
   Divider,
+  Chip,
+  Tooltip,
 } from '@mui/material';
 import {
   ArrowBack,
@@ -30,14 +32,49 @@ import {
 import {
   getVideo,
   getVideoStreamUrl,
+  getSubtitles,
+  getSubtitleUrl,
+  waitForSubtitle,
+  getChapters,
+  getChaptersUrl,
   incrementView,
   toggleLike,
-  getComments,
+  getReactionSet,
+  getVideoReactions,
+  toggleVideoReaction,
+  toggleCommentReaction,
+  getCommentPage,
+  getTimestampedComments,
   addComment,
+  addReply,
+  getReplies,
   getPlaylist,
   getVideos,
+  subscribeToEvents,
 } from '../api';
-import CommentSection from '../components/CommentSection';
+import ReactionBar from '../components/ReactionBar';
+import NotificationBell from '../components/NotificationBell';
+import CommentSection, {
+  addToThread,
+  updateInThread,
+  removeFromThread,
+  withReplies,
+  formatTimestamp,
+} from '../components/CommentSection';
+
+// Reply levels loaded with the comments; deeper threads load on demand
+const COMMENT_DEPTH = 3;
+
+// Add a timestamped comment to the timeline markers, kept in playback order
+const addMarker = (markers, comment) => {
+  if (comment.timestamp_seconds === null || comment.timestamp_seconds === undefined) {
+    return markers;
+  }
+  if (markers.some((m) => m.id === comment.id)) {
+    return markers;
+  }
+  return [...markers, comment].sort((a, b) => a.timestamp_seconds - b.timestamp_seconds);
+};
 
 const VideoPlayerPage = () => {
   const { id } = useParams();
@@ -52,11 +89,21 @@ const VideoPlayerPage = () => {
   const [isFullscreen, setIsFullscreen] = useState(false);
   const [playbackSpeed, setPlaybackSpeed] = useState(1);
   const [comments, setComments] = useState([]);
+  const [commentSort, setCommentSort] = useState('newest');
+  const [commentTotal, setCommentTotal] = useState(0);
+  const [nextCommentCursor, setNextCommentCursor] = useState(null);
+  const [markers, setMarkers] = useState([]);
+  const [reactionSet, setReactionSet] = useState([]);
+  const [videoReactions, setVideoReactions] = useState({ reactions: {}, mine: [] });
+  const [subtitles, setSubtitles] = useState([]);
+  const [chapters, setChapters] = useState([]);
   const [playlist, setPlaylist] = useState(null);
   const [playlistVideos, setPlaylistVideos] = useState([]);
   const [currentVideoIndex, setCurrentVideoIndex] = useState(0);
 
   useEffect(() => {
+    let active = true;
+
     const fetchVideo = async () => {
       try {
         const data = await getVideo(id);
@@ -70,12 +117,50 @@ const VideoPlayerPage = () => {
       }
     };
 
-    const fetchComments = async () => {
+    const fetchReactions = async () => {
       try {
-        const data = await getComments(id);
-        setComments(data);
+        const [available, summary] = await Promise.all([getReactionSet(), getVideoReactions(id)]);
+        setReactionSet(available);
+        setVideoReactions(summary);
       } catch (error) {
-        console.error('Failed to fetch comments:', error);
+        console.error('Failed to fetch reactions:', error);
+      }
+    };
+
+    const fetchMarkers = async () => {
+      try {
+        const data = await getTimestampedComments(id);
+        setMarkers(data);
+      } catch (error) {
+        console.error('Failed to fetch comment markers:', error);
+      }
+    };
+
+    const fetchSubtitles = async () => {
+      try {
+        const data = await getSubtitles(id);
+        setSubtitles(data.filter((track) => track.source !== 'embedded'));
+        // Embedded tracks are extracted on first request; add each once it's ready
+        data.filter((track) => track.source === 'embedded').forEach(async (track) => {
+          try {
+            if (await waitForSubtitle(id, track.id, () => active) && active) {
+              setSubtitles((current) => [...current, track]);
+            }
+          } catch (error) {
+            console.error('Failed to extract subtitles:', error);
+          }
+        });
+      } catch (error) {
+        console.error('Failed to fetch subtitles:', error);
+      }
+    };
+
+    const fetchChapters = async () => {
+      try {
+        const data = await getChapters(id);
+        setChapters(data);
+      } catch (error) {
+        console.error('Failed to fetch chapters:', error);
       }
     };
 
@@ -106,10 +191,66 @@ const VideoPlayerPage = () => {
     };
 
     fetchVideo();
-    fetchComments();
+    fetchMarkers();
+    fetchReactions();
+    fetchSubtitles();
+    fetchChapters();
     fetchPlaylist();
+
+    return () => {
+      active = false;
+    };
   }, [id, searchParams]);
 
+  // Load the first page of comments again whenever the order changes
+  useEffect(() => {
+    const fetchComments = async () => {
+      try {
+        const page = await getCommentPage(id, { depth: COMMENT_DEPTH, sort: commentSort });
+        setComments(page.comments);
+        setCommentTotal(page.total);
+        setNextCommentCursor(page.next_cursor || null);
+      } catch (error) {
+        console.error('Failed to fetch comments:', error);
+      }
+    };
+
+    fetchComments();
+  }, [id, commentSort]);
+
+  // Show new, edited and removed comments and like counts from other viewers
+  // as they happen
+  useEffect(() => {
+    const source = subscribeToEvents({
+      'comment.added': (comment) => {
+        setComments((current) => {
+          if (!comment.parent_id && !current.some((c) => c.id === comment.id)) {
+            setCommentTotal((total) => total + 1);
+          }
+          return addToThread(current, comment);
+        });
+        setMarkers((current) => addMarker(current, comment));
+      },
+      'comment.updated': (comment) => {
+        setComments((current) => updateInThread(current, comment));
+        setMarkers((current) => current.map((m) => (m.id === comment.id ? { ...m, ...comment } : m)));
+      },
+      'comment.removed': (data) => {
+        setComments((current) => removeFromThread(current, data.id));
+        setMarkers((current) => current.filter((m) => m.id !== data.id));
+      },
+      'likes.updated': (data) => setLocalLikes(data.likes),
+      'reactions.updated': (data) => {
+        if (data.comment_id) {
+          setComments((current) => updateInThread(current, { id: data.comment_id, reactions: data.reactions }));
+        } else {
+          setVideoReactions((current) => ({ ...current, reactions: data.reactions }));
+        }
+      },
+    }, [id]);
+    return () => source.close();
+  }, [id]);
+
   useEffect(() => {
     if (videoRef.current) {
       videoRef.current.playbackRate = playbackSpeed;
@@ -146,6 +287,50 @@ const VideoPlayerPage = () => {
     }
   };
 
+  const handleSeek = (seconds) => {
+    if (videoRef.current) {
+      videoRef.current.currentTime = seconds;
+      videoRef.current.play();
+    }
+  };
+
+  const getCurrentTime = () => (videoRef.current ? videoRef.current.currentTime : 0);
+
+  const handleVideoReaction = async (reaction) => {
+    try {
+      const summary = await toggleVideoReaction(id, reaction);
+      setVideoReactions(summary);
+    } catch (error) {
+      console.error('Failed to toggle reaction:', error);
+    }
+  };
+
+  const handleCommentReaction = async (commentId, reaction) => {
+    try {
+      const summary = await toggleCommentReaction(id, commentId, reaction);
+      setComments((current) => updateInThread(current, { id: commentId, reactions: summary.reactions }));
+      return summary;
+    } catch (error) {
+      console.error('Failed to toggle reaction:', error);
+      return null;
+    }
+  };
+
+  const handleChapterClick = (chapter) => {
+    if (videoRef.current) {
+      videoRef.current.currentTime = chapter.start;
+      videoRef.current.play();
+    }
+  };
+
+  const formatChapterTime = (seconds) => {
+    const total = Math.floor(seconds);
+    const hours = Math.floor(total / 3600);
+    const minutes = Math.floor((total % 3600) / 60);
+    const secs = String(total % 60).padStart(2, '0');
+    return hours > 0 ? `${hours}:${String(minutes).padStart(2, '0')}:${secs}` : `${minutes}:${secs}`;
+  };
+
   const handleFullscreen = () => {
     if (!isFullscreen) {
       const elem = containerRef.current;
@@ -227,16 +412,63 @@ const VideoPlayerPage = () => {
     };
   }, [isFullscreen]);
 
-  const handleAddComment = async (author, content) => {
+  const handleAddComment = async (author, content, timestampSeconds) => {
     try {
-      const newComment = await addComment(id, author, content);
-      setComments([newComment, ...comments]);
+      const newComment = await addComment(id, author, content, timestampSeconds);
+      if (newComment.status === 'pending') {
+        alert('Your comment will appear once a moderator approves it.');
+        return;
+      }
+      setComments((current) => {
+        if (!current.some((c) => c.id === newComment.id)) {
+          setCommentTotal((total) => total + 1);
+        }
+        return addToThread(current, newComment);
+      });
+      setMarkers((current) => addMarker(current, newComment));
     } catch (error) {
       console.error('Failed to add comment:', error);
       throw error;
     }
   };
 
+  const handleAddReply = async (commentId, author, content) => {
+    try {
+      const reply = await addReply(id, commentId, author, content);
+      if (reply.status === 'pending') {
+        alert('Your reply will appear once a moderator approves it.');
+        return;
+      }
+      setComments((current) => addToThread(current, reply));
+    } catch (error) {
+      console.error('Failed to add reply:', error);
+      throw error;
+    }
+  };
+
+  const handleLoadMoreComments = async () => {
+    try {
+      const page = await getCommentPage(id, { depth: COMMENT_DEPTH, sort: commentSort, cursor: nextCommentCursor });
+      setComments((current) => [
+        ...current,
+        ...page.comments.filter((c) => !current.some((existing) => existing.id === c.id)),
+      ]);
+      setCommentTotal(page.total);
+      setNextCommentCursor(page.next_cursor || null);
+    } catch (error) {
+      console.error('Failed to fetch more comments:', error);
+    }
+  };
+
+  const handleLoadReplies = async (commentId) => {
+    try {
+      const replies = await getReplies(id, commentId, COMMENT_DEPTH);
+      setComments((current) => withReplies(current, commentId, replies));
+    } catch (error) {
+      console.error('Failed to fetch replies:', error);
+    }
+  };
+
   const handlePlaylistVideoClick = (videoId) => {
     navigate(`/video/${videoId}?playlist=${playlist.id}`);
   };
@@ -278,6 +510,7 @@ const VideoPlayerPage = () => {
           <Typography variant="h6" component="div" sx={{ flexGrow: 1 }}>
             StreamLite
           </Typography>
+          <NotificationBell />
         </Toolbar>
       </AppBar>
       <Box sx={{ backgroundColor: '#000', minHeight: 'calc(100vh - 64px)' }}>
@@ -293,6 +526,7 @@ const VideoPlayerPage = () => {
                     width="100%"
                     height={isFullscreen ? '100%' : 'auto'}
                     controls
+                    crossOrigin="anonymous"
                     src={getVideoStreamUrl(id)}
                     style={{ 
                       display: 'block',
@@ -300,7 +534,20 @@ const VideoPlayerPage = () => {
                       aspectRatio: '16/9',
                       maxHeight: isFullscreen ? '100vh' : '70vh'
                     }}
-                  />
+                  >
+                    {subtitles.map((track) => (
+                      <track
+                        key={track.id}
+                        kind="subtitles"
+                        src={getSubtitleUrl(id, track.id)}
+                        srcLang={track.language || undefined}
+                        label={track.label}
+                      />
+                    ))}
+                    {chapters.length > 0 && (
+                      <track kind="chapters" src={getChaptersUrl(id)} />
+                    )}
+                  </video>
                   <Box
                     sx={{
                       position: 'absolute',
@@ -347,6 +594,29 @@ const VideoPlayerPage = () => {
                     </IconButton>
                   </Box>
                 </Box>
+                {/* Comment markers along the timeline */}
+                {video.duration > 0 && markers.length > 0 && (
+                  <Box sx={{ position: 'relative', height: 8, backgroundColor: '#222' }}>
+                    {markers.map((marker) => (
+                      <Tooltip
+                        key={marker.id}
+                        title={`${formatTimestamp(marker.timestamp_seconds)} ${marker.author}: ${marker.content}`}
+                      >
+                        <Box
+                          onClick={() => handleSeek(marker.timestamp_seconds)}
+                          sx={{
+                            position: 'absolute',
+                            left: `${Math.min(marker.timestamp_seconds / video.duration, 1) * 100}%`,
+                            width: 4,
+                            height: '100%',
+                            backgroundColor: '#ffca28',
+                            cursor: 'pointer',
+                          }}
+                        />
+                      </Tooltip>
+                    ))}
+                  </Box>
+                )}
               </Paper>
               
               {/* Video Info */}
@@ -365,12 +635,46 @@ const VideoPlayerPage = () => {
                   >
                     {localLikes}
                   </Button>
+                  <ReactionBar
+                    available={reactionSet}
+                    reactions={videoReactions.reactions}
+                    mine={videoReactions.mine}
+                    onToggle={handleVideoReaction}
+                    dark
+                  />
                 </Box>
+                {chapters.length > 0 && (
+                  <Box sx={{ display: 'flex', flexWrap: 'wrap', gap: 1 }}>
+                    {chapters.map((chapter) => (
+                      <Chip
+                        key={chapter.id}
+                        label={`${formatChapterTime(chapter.start)} ${chapter.title}`}
+                        onClick={() => handleChapterClick(chapter)}
+                        variant="outlined"
+                        sx={{ color: 'white', borderColor: 'grey.600' }}
+                      />
+                    ))}
+                  </Box>
+                )}
               </Paper>
 
               {/* Comments Section */}
               <Box sx={{ backgroundColor: '#fff' }}>
-                <CommentSection comments={comments} onAddComment={handleAddComment} />
+                <CommentSection
+                  comments={comments}
+                  total={commentTotal}
+                  sort={commentSort}
+                  onSortChange={setCommentSort}
+                  hasMore={nextCommentCursor !== null}
+                  onLoadMore={handleLoadMoreComments}
+                  onAddComment={handleAddComment}
+                  onAddReply={handleAddReply}
+                  onLoadReplies={handleLoadReplies}
+                  getCurrentTime={getCurrentTime}
+                  onSeek={handleSeek}
+                  reactionSet={reactionSet}
+                  onReact={handleCommentReaction}
+                />
               </Box>
             </Box>
 